@@ -1,64 +1,15 @@
 package auth
 
 import (
+	"crypto/hmac"
 	"crypto/rand"
+	"crypto/sha256"
 	"encoding/hex"
 	"fmt"
 	"net/http"
 	"strings"
-	"time"
-
-	jwt "github.com/golang-jwt/jwt/v5"
-	"github.com/google/uuid"
-	"golang.org/x/crypto/bcrypt"
 )
 
-func HashPassword(password string) (string, error) {
-
-	hashedPasswordData, errHash := bcrypt.GenerateFromPassword([]byte(password), 0)
-	if errHash != nil {
-		return "", errHash
-	}
-
-	return string(hashedPasswordData), nil
-}
-
-func CheckPasswordHash(hashedPassword, password string) error {
-	return bcrypt.CompareHashAndPassword([]byte(hashedPassword), []byte(password))
-}
-
-func SignUserJWT(userID uuid.UUID, jwtSecret string, expiresIn time.Duration) (string, error) {
-	t := jwt.NewWithClaims(jwt.SigningMethodHS256,
-		jwt.RegisteredClaims{
-			Issuer:    "chirpy",
-			IssuedAt:  jwt.NewNumericDate(time.Now().UTC()),
-			ExpiresAt: jwt.NewNumericDate(time.Now().UTC().Add(expiresIn)),
-			Subject:   userID.String(),
-		},
-	)
-	return t.SignedString([]byte(jwtSecret))
-}
-
-func ValidateUserJWT(tokenString, jwtSecret string) (uuid.UUID, error) {
-	claims := jwt.RegisteredClaims{}
-	userJWT, err := jwt.ParseWithClaims(tokenString, &claims,
-		func(token *jwt.Token) (interface{}, error) {
-			if token.Method.Alg() != jwt.SigningMethodHS256.Alg() {
-				return nil, fmt.Errorf("error signing method must be HS256. Token's method is %s", token.Method.Alg())
-			}
-			return []byte(jwtSecret), nil
-		},
-	)
-	if err != nil {
-		return uuid.UUID{}, err
-	}
-	id, err := userJWT.Claims.GetSubject()
-	if err != nil {
-		return uuid.UUID{}, err
-	}
-	return uuid.Parse(id)
-}
-
 func GetBearerToken(headers *http.Header) (string, error) {
 	authHeader, err := getAuthHeader(headers)
 	if err != nil {
@@ -74,11 +25,72 @@ func GetBearerToken(headers *http.Header) (string, error) {
 }
 
 func MakeRefreshToken() (string, error) {
+	return MakeToken()
+}
+
+// MakeToken returns a random 256-bit hex encoded token.
+func MakeToken() (string, error) {
 	randomData := make([]byte, 32)
 	rand.Read(randomData)
 	return hex.EncodeToString(randomData), nil
 }
 
+// HashToken returns the hex encoded SHA-256 of the token made by MakeToken.
+// The tokens are random enough for the unkeyed hash.
+func HashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// PersonalAccessTokenPrefix tells the personal access tokens from the JWTs in the Authorization header
+// and makes the leaked tokens easy to find with the secret scanners.
+const PersonalAccessTokenPrefix = "chirpy_pat_"
+
+func MakePersonalAccessToken() (string, error) {
+	token, err := MakeToken()
+	if err != nil {
+		return "", err
+	}
+	return PersonalAccessTokenPrefix + token, nil
+}
+
+func IsPersonalAccessToken(token string) bool {
+	return strings.HasPrefix(token, PersonalAccessTokenPrefix)
+}
+
+// HashRefreshToken returns the hex encoded HMAC-SHA256 of the refresh token.
+// Only the hashes are stored so the database doesn't leak usable refresh tokens.
+func HashRefreshToken(refreshToken, hashKey string) string {
+	mac := hmac.New(sha256.New, []byte(hashKey))
+	mac.Write([]byte(refreshToken))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// LegacyHashRefreshToken returns the hash the refresh tokens issued before HashRefreshToken were migrated to.
+func LegacyHashRefreshToken(refreshToken string) string {
+	return "sha256:" + HashToken(refreshToken)
+}
+
+// CSRFToken returns the token the web app sends in a header with the session cookies.
+// It's the HMAC of the session id, so it can't be guessed or planted for another session.
+// The HMAC key is derived from hashKey, so the refresh token hashes and the CSRF tokens don't share a key.
+func CSRFToken(sessionID, hashKey string) string {
+	mac := hmac.New(sha256.New, csrfKey(hashKey))
+	mac.Write([]byte(sessionID))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// csrfKey returns the subkey HMAC(hashKey, "csrf")
+func csrfKey(hashKey string) []byte {
+	mac := hmac.New(sha256.New, []byte(hashKey))
+	mac.Write([]byte("csrf"))
+	return mac.Sum(nil)
+}
+
+func ValidCSRFToken(csrfToken, sessionID, hashKey string) bool {
+	return hmac.Equal([]byte(csrfToken), []byte(CSRFToken(sessionID, hashKey)))
+}
+
 func GetApiKey(headers *http.Header) (string, error) {
 	authHeader, err := getAuthHeader(headers)
 	if err != nil {
@@ -7,72 +7,88 @@ import (
 	"testing"
 	"time"
 
-	"github.com/golang-jwt/jwt/v5"
 	"github.com/google/uuid"
 	"github.com/stretchr/testify/assert"
 )
 
-func TestSignUserJWT(t *testing.T) {
+func TestGetBearerToken(t *testing.T) {
 	userUUID, err := uuid.NewRandom()
 	if err != nil {
 		fmt.Fprintln(os.Stderr, err)
 		t.FailNow()
 	}
-
-	// Test: JWT signed
-	jwtSecret := "pirch"
-	tokenString, err := SignUserJWT(userUUID, jwtSecret, 5*time.Second)
-	assert.NoError(t, err)
-	tokenStruct, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) { return []byte(jwtSecret), nil })
-	assert.NoError(t, err)
-	assert.True(t, tokenStruct.Valid)
-}
-
-func TestValidateUserJWT(t *testing.T) {
-	userUUID, err := uuid.NewRandom()
+	tokenString, err := newTestLegacyKeyring(t, "pirch").SignUserJWT(userUUID, 5*time.Second)
 	if err != nil {
 		fmt.Fprintln(os.Stderr, err)
 		t.FailNow()
 	}
+	headers := make(http.Header)
+	headers.Add("Authorization", "Bearer "+tokenString)
+	jwtString, err := GetBearerToken(&headers)
+	assert.NoError(t, err)
+	assert.NotEmpty(t, jwtString)
+}
 
-	token, err := SignUserJWT(userUUID, "pirch", 5*time.Second)
+func TestMakeRefreshToken(t *testing.T) {
+	refresh_token, err := MakeRefreshToken()
+	assert.NoError(t, err)
+	assert.NotEmpty(t, refresh_token)
+}
+
+func TestHashRefreshToken(t *testing.T) {
+	refreshToken, err := MakeRefreshToken()
 	if err != nil {
 		fmt.Fprintln(os.Stderr, err)
 		t.FailNow()
 	}
 
-	// Test: valid jwtSecret
-	userUUIDFromClaims, err := ValidateUserJWT(token, "pirch")
-	assert.NoError(t, uuid.Validate(userUUIDFromClaims.String()))
-	assert.NoError(t, err)
+	// Test: the hash is deterministic for the key
+	tokenHash := HashRefreshToken(refreshToken, "pirch")
+	assert.Equal(t, tokenHash, HashRefreshToken(refreshToken, "pirch"))
+	assert.NotEqual(t, refreshToken, tokenHash)
+
+	// Test: the hash depends on the key
+	assert.NotEqual(t, tokenHash, HashRefreshToken(refreshToken, "chirp"))
 
-	// Test: valid jwtSecret has expired
-	time.Sleep(5 * time.Second)
-	_, err = ValidateUserJWT(token, "pirch")
-	assert.Error(t, err)
+	// Test: the legacy hash matches PostgreSQL's sha256() of the migration
+	assert.Equal(t,
+		"sha256:9f86d081884c7d659a2feaa0c55ad015a3bf4f1b2b0b822cd15d6c15b0f00a08",
+		LegacyHashRefreshToken("test"),
+	)
 }
 
-func TestGetBearerToken(t *testing.T) {
-	userUUID, err := uuid.NewRandom()
-	if err != nil {
-		fmt.Fprintln(os.Stderr, err)
-		t.FailNow()
-	}
-	jwtSecret := "pirch"
-	tokenString, err := SignUserJWT(userUUID, jwtSecret, 5*time.Second)
-	if err != nil {
-		fmt.Fprintln(os.Stderr, err)
-		t.FailNow()
-	}
-	headers := make(http.Header)
-	headers.Add("Authorization", "Bearer "+tokenString)
-	jwtString, err := GetBearerToken(&headers)
+func TestHashToken(t *testing.T) {
+	token, err := MakeToken()
 	assert.NoError(t, err)
-	assert.NotEmpty(t, jwtString)
+	assert.Len(t, token, 64)
+
+	assert.Equal(t, HashToken(token), HashToken(token))
+	assert.NotEqual(t, token, HashToken(token))
+	assert.Equal(t, "9f86d081884c7d659a2feaa0c55ad015a3bf4f1b2b0b822cd15d6c15b0f00a08", HashToken("test"))
 }
 
-func TestMakeRefreshToken(t *testing.T) {
-	refresh_token, err := MakeRefreshToken()
+func TestMakePersonalAccessToken(t *testing.T) {
+	token, err := MakePersonalAccessToken()
 	assert.NoError(t, err)
-	assert.NotEmpty(t, refresh_token)
+	assert.True(t, IsPersonalAccessToken(token))
+	assert.Len(t, token, len(PersonalAccessTokenPrefix)+64)
+
+	jwtString, err := newTestLegacyKeyring(t, "secret").SignUserJWT(uuid.New(), time.Hour)
+	assert.NoError(t, err)
+	assert.False(t, IsPersonalAccessToken(jwtString))
+}
+
+func TestCSRFToken(t *testing.T) {
+	sessionID := uuid.NewString()
+	csrfToken := CSRFToken(sessionID, "key")
+
+	assert.True(t, ValidCSRFToken(csrfToken, sessionID, "key"))
+	assert.False(t, ValidCSRFToken(csrfToken, uuid.NewString(), "key"))
+	assert.False(t, ValidCSRFToken(csrfToken, sessionID, "another key"))
+	assert.False(t, ValidCSRFToken("", sessionID, "key"))
+	assert.NotEqual(t, HashRefreshToken(sessionID, "key"), csrfToken)
+
+	// Test: the refresh token hashes' key isn't the CSRF tokens' key
+	assert.NotEqual(t, HashRefreshToken("csrf:"+sessionID, "key"), csrfToken)
+	assert.Equal(t, HashRefreshToken(sessionID, string(csrfKey("key"))), csrfToken)
 }
@@ -0,0 +1,281 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	jwt "github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+)
+
+// SigningKey is a private key identified by the "kid" header of the tokens it signs.
+type SigningKey struct {
+	ID         string
+	Method     jwt.SigningMethod
+	PrivateKey crypto.Signer
+}
+
+// Keyring signs access JWTs with its active key and validates them with any of its keys,
+// so the retired keys keep the tokens they signed valid until these tokens expire.
+type Keyring struct {
+	keys         map[string]SigningKey
+	activeKeyID  string
+	legacySecret []byte
+}
+
+func NewKeyring(activeKeyID string, keys ...SigningKey) (*Keyring, error) {
+	k := &Keyring{
+		keys:        make(map[string]SigningKey, len(keys)),
+		activeKeyID: activeKeyID,
+	}
+	for _, key := range keys {
+		if _, ok := k.keys[key.ID]; ok {
+			return nil, fmt.Errorf("error duplicate key id %q", key.ID)
+		}
+		k.keys[key.ID] = key
+	}
+
+	if activeKeyID == "" {
+		return k, nil
+	}
+	if _, ok := k.keys[activeKeyID]; !ok {
+		return nil, fmt.Errorf("error active key id %q is not in the keyring", activeKeyID)
+	}
+	return k, nil
+}
+
+// LoadKeyringDir loads every *.pem file in the dir as a signing key.
+// The key id is the file name without the extension.
+func LoadKeyringDir(dir, activeKeyID string) (*Keyring, error) {
+	paths, err := filepath.Glob(filepath.Join(dir, "*.pem"))
+	if err != nil {
+		return nil, err
+	}
+
+	keys := make([]SigningKey, 0, len(paths))
+	for _, path := range paths {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		key, err := ParseSigningKeyPEM(strings.TrimSuffix(filepath.Base(path), ".pem"), data)
+		if err != nil {
+			return nil, fmt.Errorf("error parsing %s: %w", path, err)
+		}
+		keys = append(keys, key)
+	}
+
+	return NewKeyring(activeKeyID, keys...)
+}
+
+// ParseSigningKeyPEM parses a PKCS #8 RSA or Ed25519 private key or a PKCS #1 RSA private key.
+func ParseSigningKeyPEM(keyID string, data []byte) (SigningKey, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return SigningKey{}, fmt.Errorf("error no PEM block found")
+	}
+
+	var privateKey any
+	var err error
+	switch block.Type {
+	case "RSA PRIVATE KEY":
+		privateKey, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "PRIVATE KEY":
+		privateKey, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	default:
+		return SigningKey{}, fmt.Errorf("error unsupported PEM block type %q", block.Type)
+	}
+	if err != nil {
+		return SigningKey{}, err
+	}
+
+	switch privateKey := privateKey.(type) {
+	case *rsa.PrivateKey:
+		return SigningKey{ID: keyID, Method: jwt.SigningMethodRS256, PrivateKey: privateKey}, nil
+	case ed25519.PrivateKey:
+		return SigningKey{ID: keyID, Method: jwt.SigningMethodEdDSA, PrivateKey: privateKey}, nil
+	default:
+		return SigningKey{}, fmt.Errorf("error unsupported private key type %T", privateKey)
+	}
+}
+
+// SetLegacySecret makes the keyring accept HS256 tokens signed with the secret.
+// Without an active key the keyring signs HS256 tokens with the secret too.
+func (k *Keyring) SetLegacySecret(secret string) {
+	if secret == "" {
+		k.legacySecret = nil
+		return
+	}
+	k.legacySecret = []byte(secret)
+}
+
+// UserClaims are the claims of users' access JWTs.
+type UserClaims struct {
+	jwt.RegisteredClaims
+	// SessionID is the refresh token family of the login the JWT is issued for
+	SessionID string `json:"sid,omitempty"`
+	// Scope limits the JWTs issued to the third-party OAuth clients and the personal access tokens.
+	// The first-party JWTs have no scope.
+	Scope    string `json:"scope,omitempty"`
+	ClientID string `json:"client_id,omitempty"`
+	// Role is the user's role when the JWT is signed
+	Role string `json:"role,omitempty"`
+	// TokenVersion is the user's token version when the JWT is signed. Bumping the user's version revokes the JWT
+	TokenVersion int32 `json:"token_version,omitempty"`
+}
+
+func NewUserClaims(userID uuid.UUID, expiresIn time.Duration) UserClaims {
+	return UserClaims{
+		RegisteredClaims: jwt.RegisteredClaims{
+			Issuer:    "chirpy",
+			IssuedAt:  jwt.NewNumericDate(time.Now().UTC()),
+			ExpiresAt: jwt.NewNumericDate(time.Now().UTC().Add(expiresIn)),
+			Subject:   userID.String(),
+			ID:        uuid.NewString(),
+		},
+	}
+}
+
+func (claims UserClaims) UserID() (uuid.UUID, error) {
+	return uuid.Parse(claims.Subject)
+}
+
+// FirstParty reports whether the JWT is issued to Chirpy's own clients
+// rather than an OAuth client or for a personal access token.
+func (claims UserClaims) FirstParty() bool {
+	return claims.Scope == ""
+}
+
+func (k *Keyring) SignUserJWT(userID uuid.UUID, expiresIn time.Duration) (string, error) {
+	return k.SignUserClaims(NewUserClaims(userID, expiresIn))
+}
+
+func (k *Keyring) SignUserClaims(claims UserClaims) (string, error) {
+	if k.activeKeyID == "" {
+		if k.legacySecret == nil {
+			return "", fmt.Errorf("error keyring has no active key")
+		}
+		return jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString(k.legacySecret)
+	}
+
+	key := k.keys[k.activeKeyID]
+	t := jwt.NewWithClaims(key.Method, claims)
+	t.Header["kid"] = key.ID
+	return t.SignedString(key.PrivateKey)
+}
+
+func (k *Keyring) ValidateUserJWT(tokenString string) (uuid.UUID, error) {
+	claims, err := k.ValidateUserClaims(tokenString)
+	if err != nil {
+		return uuid.UUID{}, err
+	}
+	return claims.UserID()
+}
+
+func (k *Keyring) ValidateUserClaims(tokenString string) (UserClaims, error) {
+	claims := UserClaims{}
+	_, err := jwt.ParseWithClaims(tokenString, &claims, k.verificationKey)
+	if err != nil {
+		return UserClaims{}, err
+	}
+	// the tokens with an audience are issued for other purposes than the API access
+	if len(claims.Audience) > 0 {
+		return UserClaims{}, fmt.Errorf("error token isn't an access token. Token's audience is %v", claims.Audience)
+	}
+	return claims, nil
+}
+
+const mfaChallengeAudience = "chirpy-mfa"
+
+// SignMFAChallenge signs the token the user exchanges for the access JWT with the second factor.
+// It is not an access JWT: ValidateUserClaims rejects it.
+func (k *Keyring) SignMFAChallenge(userID uuid.UUID, expiresIn time.Duration) (string, error) {
+	claims := NewUserClaims(userID, expiresIn)
+	claims.Audience = jwt.ClaimStrings{mfaChallengeAudience}
+	return k.SignUserClaims(claims)
+}
+
+func (k *Keyring) ValidateMFAChallenge(tokenString string) (uuid.UUID, error) {
+	claims := UserClaims{}
+	_, err := jwt.ParseWithClaims(tokenString, &claims, k.verificationKey, jwt.WithAudience(mfaChallengeAudience))
+	if err != nil {
+		return uuid.UUID{}, err
+	}
+	return claims.UserID()
+}
+
+func (k *Keyring) verificationKey(token *jwt.Token) (interface{}, error) {
+	if token.Method.Alg() == jwt.SigningMethodHS256.Alg() {
+		if k.legacySecret == nil {
+			return nil, fmt.Errorf("error HS256 tokens are not accepted")
+		}
+		return k.legacySecret, nil
+	}
+
+	keyID, ok := token.Header["kid"].(string)
+	if !ok {
+		return nil, fmt.Errorf("error token has no key id")
+	}
+	key, ok := k.keys[keyID]
+	if !ok {
+		return nil, fmt.Errorf("error unknown key id %q", keyID)
+	}
+	if token.Method.Alg() != key.Method.Alg() {
+		return nil, fmt.Errorf("error signing method must be %s. Token's method is %s", key.Method.Alg(), token.Method.Alg())
+	}
+	return key.PrivateKey.Public(), nil
+}
+
+// JWK is a public key in the JSON Web Key format (RFC 7517).
+type JWK struct {
+	KeyType   string `json:"kty"`
+	KeyID     string `json:"kid"`
+	Use       string `json:"use"`
+	Algorithm string `json:"alg"`
+	Curve     string `json:"crv,omitempty"`
+	X         string `json:"x,omitempty"`
+	N         string `json:"n,omitempty"`
+	E         string `json:"e,omitempty"`
+}
+
+type JWKS struct {
+	Keys []JWK `json:"keys"`
+}
+
+// JWKS returns the public keys of the keyring. The legacy secret is never published.
+func (k *Keyring) JWKS() JWKS {
+	jwks := JWKS{Keys: make([]JWK, 0, len(k.keys))}
+	for _, key := range k.keys {
+		jwk := JWK{
+			KeyID:     key.ID,
+			Use:       "sig",
+			Algorithm: key.Method.Alg(),
+		}
+		switch publicKey := key.PrivateKey.Public().(type) {
+		case *rsa.PublicKey:
+			jwk.KeyType = "RSA"
+			jwk.N = base64.RawURLEncoding.EncodeToString(publicKey.N.Bytes())
+			jwk.E = base64.RawURLEncoding.EncodeToString(big.NewInt(int64(publicKey.E)).Bytes())
+		case ed25519.PublicKey:
+			jwk.KeyType = "OKP"
+			jwk.Curve = "Ed25519"
+			jwk.X = base64.RawURLEncoding.EncodeToString(publicKey)
+		}
+		jwks.Keys = append(jwks.Keys, jwk)
+	}
+	sort.Slice(jwks.Keys, func(i, j int) bool {
+		return jwks.Keys[i].KeyID < jwks.Keys[j].KeyID
+	})
+	return jwks
+}
@@ -0,0 +1,200 @@
+package auth
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestSigningKeys(t *testing.T) (SigningKey, SigningKey) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		t.FailNow()
+	}
+	_, edKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		t.FailNow()
+	}
+	return SigningKey{ID: "rsa-1", Method: jwt.SigningMethodRS256, PrivateKey: rsaKey},
+		SigningKey{ID: "ed-1", Method: jwt.SigningMethodEdDSA, PrivateKey: edKey}
+}
+
+// newTestLegacyKeyring returns the keyring which signs the HS256 tokens with the legacy secret
+func newTestLegacyKeyring(t *testing.T, secret string) *Keyring {
+	k, err := NewKeyring("")
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		t.FailNow()
+	}
+	k.SetLegacySecret(secret)
+	return k
+}
+
+func TestKeyringSignUserJWT(t *testing.T) {
+	userUUID := uuid.New()
+	rsaKey, edKey := newTestSigningKeys(t)
+
+	// Test: the token is signed with the active key and carries its id
+	k, err := NewKeyring(edKey.ID, rsaKey, edKey)
+	assert.NoError(t, err)
+	tokenString, err := k.SignUserJWT(userUUID, 5*time.Second)
+	assert.NoError(t, err)
+	tokenStruct, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
+		return edKey.PrivateKey.Public(), nil
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, edKey.ID, tokenStruct.Header["kid"])
+	assert.Equal(t, jwt.SigningMethodEdDSA.Alg(), tokenStruct.Method.Alg())
+
+	// Test: the active key must be in the keyring
+	_, err = NewKeyring("missing", rsaKey)
+	assert.Error(t, err)
+}
+
+func TestKeyringValidateUserJWT(t *testing.T) {
+	userUUID := uuid.New()
+	rsaKey, edKey := newTestSigningKeys(t)
+
+	oldKeyring, err := NewKeyring(rsaKey.ID, rsaKey)
+	assert.NoError(t, err)
+	oldToken, err := oldKeyring.SignUserJWT(userUUID, time.Minute)
+	assert.NoError(t, err)
+
+	// Test: a token signed with a rotated out key is still valid
+	rotatedKeyring, err := NewKeyring(edKey.ID, rsaKey, edKey)
+	assert.NoError(t, err)
+	userUUIDFromClaims, err := rotatedKeyring.ValidateUserJWT(oldToken)
+	assert.NoError(t, err)
+	assert.Equal(t, userUUID, userUUIDFromClaims)
+
+	// Test: a token signed with a removed key is invalid
+	newKeyring, err := NewKeyring(edKey.ID, edKey)
+	assert.NoError(t, err)
+	_, err = newKeyring.ValidateUserJWT(oldToken)
+	assert.Error(t, err)
+
+	// Test: HS256 tokens are valid only with the legacy secret
+	legacyToken, err := newTestLegacyKeyring(t, "pirch").SignUserJWT(userUUID, time.Minute)
+	assert.NoError(t, err)
+	_, err = newKeyring.ValidateUserJWT(legacyToken)
+	assert.Error(t, err)
+	newKeyring.SetLegacySecret("pirch")
+	userUUIDFromClaims, err = newKeyring.ValidateUserJWT(legacyToken)
+	assert.NoError(t, err)
+	assert.Equal(t, userUUID, userUUIDFromClaims)
+}
+
+func TestKeyringLegacySecret(t *testing.T) {
+	userUUID := uuid.New()
+	k := newTestLegacyKeyring(t, "pirch")
+
+	// Test: the keyring without an active key signs HS256 tokens with the legacy secret
+	tokenString, err := k.SignUserJWT(userUUID, 5*time.Second)
+	assert.NoError(t, err)
+	tokenStruct, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) { return []byte("pirch"), nil })
+	assert.NoError(t, err)
+	assert.True(t, tokenStruct.Valid)
+	assert.Equal(t, jwt.SigningMethodHS256.Alg(), tokenStruct.Method.Alg())
+
+	// Test: valid legacy secret
+	userUUIDFromClaims, err := k.ValidateUserJWT(tokenString)
+	assert.NoError(t, err)
+	assert.Equal(t, userUUID, userUUIDFromClaims)
+
+	// Test: another legacy secret
+	_, err = newTestLegacyKeyring(t, "chirp").ValidateUserJWT(tokenString)
+	assert.Error(t, err)
+
+	// Test: valid legacy secret has expired
+	expiredToken, err := k.SignUserJWT(userUUID, -time.Second)
+	assert.NoError(t, err)
+	_, err = k.ValidateUserJWT(expiredToken)
+	assert.Error(t, err)
+}
+
+func TestLoadKeyringDir(t *testing.T) {
+	rsaKey, edKey := newTestSigningKeys(t)
+	dir := t.TempDir()
+
+	rsaData := pem.EncodeToMemory(&pem.Block{
+		Type:  "RSA PRIVATE KEY",
+		Bytes: x509.MarshalPKCS1PrivateKey(rsaKey.PrivateKey.(*rsa.PrivateKey)),
+	})
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, "2025-01.pem"), rsaData, 0o600))
+
+	edBytes, err := x509.MarshalPKCS8PrivateKey(edKey.PrivateKey)
+	assert.NoError(t, err)
+	edData := pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: edBytes})
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, "2025-02.pem"), edData, 0o600))
+
+	k, err := LoadKeyringDir(dir, "2025-02")
+	assert.NoError(t, err)
+
+	jwks := k.JWKS()
+	assert.Len(t, jwks.Keys, 2)
+	assert.Equal(t, "2025-01", jwks.Keys[0].KeyID)
+	assert.Equal(t, "RSA", jwks.Keys[0].KeyType)
+	assert.Equal(t, "RS256", jwks.Keys[0].Algorithm)
+	assert.NotEmpty(t, jwks.Keys[0].N)
+	assert.Equal(t, "AQAB", jwks.Keys[0].E)
+	assert.Equal(t, "2025-02", jwks.Keys[1].KeyID)
+	assert.Equal(t, "OKP", jwks.Keys[1].KeyType)
+	assert.Equal(t, "Ed25519", jwks.Keys[1].Curve)
+	assert.NotEmpty(t, jwks.Keys[1].X)
+}
+
+func TestKeyringValidateUserClaims(t *testing.T) {
+	userUUID := uuid.New()
+	sessionUUID := uuid.New()
+	_, edKey := newTestSigningKeys(t)
+	k, err := NewKeyring(edKey.ID, edKey)
+	assert.NoError(t, err)
+
+	// Test: the custom claims survive the round trip
+	claims := NewUserClaims(userUUID, time.Minute)
+	claims.SessionID = sessionUUID.String()
+	tokenString, err := k.SignUserClaims(claims)
+	assert.NoError(t, err)
+	validatedClaims, err := k.ValidateUserClaims(tokenString)
+	assert.NoError(t, err)
+	assert.Equal(t, sessionUUID.String(), validatedClaims.SessionID)
+	userUUIDFromClaims, err := validatedClaims.UserID()
+	assert.NoError(t, err)
+	assert.Equal(t, userUUID, userUUIDFromClaims)
+}
+
+func TestKeyringValidateMFAChallenge(t *testing.T) {
+	userUUID := uuid.New()
+	_, edKey := newTestSigningKeys(t)
+	k, err := NewKeyring(edKey.ID, edKey)
+	assert.NoError(t, err)
+
+	challenge, err := k.SignMFAChallenge(userUUID, time.Minute)
+	assert.NoError(t, err)
+
+	// Test: the challenge is valid as a challenge only
+	userUUIDFromClaims, err := k.ValidateMFAChallenge(challenge)
+	assert.NoError(t, err)
+	assert.Equal(t, userUUID, userUUIDFromClaims)
+	_, err = k.ValidateUserClaims(challenge)
+	assert.Error(t, err)
+
+	// Test: an access JWT isn't a challenge
+	accessToken, err := k.SignUserJWT(userUUID, time.Minute)
+	assert.NoError(t, err)
+	_, err = k.ValidateMFAChallenge(accessToken)
+	assert.Error(t, err)
+}
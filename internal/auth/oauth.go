@@ -0,0 +1,62 @@
+package auth
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// OAuth scopes of the access JWTs issued to the third-party clients.
+const (
+	ScopeChirpsRead  = "chirps:read"
+	ScopeChirpsWrite = "chirps:write"
+	ScopeProfile     = "profile"
+)
+
+// OAuthScopes describes the scopes to the users on the consent page.
+var OAuthScopes = map[string]string{
+	ScopeChirpsRead:  "Read chirps on your behalf",
+	ScopeChirpsWrite: "Post and delete your chirps",
+	ScopeProfile:     "Read your email and profile",
+}
+
+// ParseScope parses the space-delimited scope parameter (RFC 6749, section 3.3) into the sorted unique scopes.
+func ParseScope(scope string) ([]string, error) {
+	scopes := strings.Fields(scope)
+	for _, s := range scopes {
+		if _, ok := OAuthScopes[s]; !ok {
+			return nil, fmt.Errorf("error unknown scope %q", s)
+		}
+	}
+	slices.Sort(scopes)
+	return slices.Compact(scopes), nil
+}
+
+// HasScopes reports whether the space-delimited granted scope contains all the required scopes.
+func HasScopes(granted string, required ...string) bool {
+	grantedScopes := strings.Fields(granted)
+	for _, s := range required {
+		if !slices.Contains(grantedScopes, s) {
+			return false
+		}
+	}
+	return true
+}
+
+// VerifyPKCE checks the code verifier against the S256 code challenge (RFC 7636).
+func VerifyPKCE(codeVerifier, codeChallenge string) bool {
+	// RFC 7636, section 4.1: 43 to 128 characters
+	if len(codeVerifier) < 43 || len(codeVerifier) > 128 {
+		return false
+	}
+	return subtle.ConstantTimeCompare([]byte(PKCEChallenge(codeVerifier)), []byte(codeChallenge)) == 1
+}
+
+// PKCEChallenge returns the S256 code challenge of the code verifier.
+func PKCEChallenge(codeVerifier string) string {
+	sum := sha256.Sum256([]byte(codeVerifier))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
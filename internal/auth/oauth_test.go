@@ -0,0 +1,37 @@
+package auth
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseScope(t *testing.T) {
+	scopes, err := ParseScope("profile chirps:write  chirps:read profile")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"chirps:read", "chirps:write", "profile"}, scopes)
+
+	scopes, err = ParseScope("")
+	assert.NoError(t, err)
+	assert.Empty(t, scopes)
+
+	_, err = ParseScope("chirps:read admin")
+	assert.Error(t, err)
+}
+
+func TestHasScopes(t *testing.T) {
+	assert.True(t, HasScopes("chirps:read chirps:write", ScopeChirpsWrite))
+	assert.True(t, HasScopes("chirps:read", ScopeChirpsRead))
+	assert.False(t, HasScopes("chirps:read", ScopeChirpsRead, ScopeChirpsWrite))
+	assert.False(t, HasScopes("", ScopeProfile))
+}
+
+func TestVerifyPKCE(t *testing.T) {
+	// RFC 7636, appendix B
+	codeVerifier := "dBjftJeZ4CVP-mB92K27uhbUJU1p1r_wW1gFWFOEjXk"
+	codeChallenge := "E9Melhoa2OwvFrEMTJguCHaoeK1t8URWbuGJSstw-cM"
+
+	assert.True(t, VerifyPKCE(codeVerifier, codeChallenge))
+	assert.False(t, VerifyPKCE(codeVerifier+"x", codeChallenge))
+	assert.False(t, VerifyPKCE("short", codeChallenge))
+}
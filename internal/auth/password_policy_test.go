@@ -0,0 +1,81 @@
+package auth
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// SHA-1 of "password" is 5BAA61E4C9B93F3F0682250B6CF8331B7EE68FD8
+const breachedPasswordSuffix = "1E4C9B93F3F0682250B6CF8331B7EE68FD8"
+
+func TestPasswordPolicyValidate(t *testing.T) {
+	p := PasswordPolicy{MinLength: 8, MaxLength: 12}
+
+	// Test: a valid password
+	violations, err := p.Validate("correcthorse")
+	assert.NoError(t, err)
+	assert.Empty(t, violations)
+
+	// Test: the empty password
+	violations, err = p.Validate("")
+	assert.NoError(t, err)
+	assert.Len(t, violations, 1)
+	assert.Equal(t, PasswordRuleMinLength, violations[0].Rule)
+
+	// Test: the length is in characters, not bytes
+	violations, err = p.Validate("пароль-пароль")
+	assert.NoError(t, err)
+	assert.Len(t, violations, 1)
+	assert.Equal(t, PasswordRuleMaxLength, violations[0].Rule)
+	violations, err = p.Validate("пароль12")
+	assert.NoError(t, err)
+	assert.Empty(t, violations)
+}
+
+func TestHIBPRangeDir(t *testing.T) {
+	dir := t.TempDir()
+	rangeData := "003D68EB55068C33ACE09247EE4C639306B:3\r\n" + breachedPasswordSuffix + ":10434004\r\n"
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, "5BAA6.txt"), []byte(rangeData), 0o600))
+
+	breached, err := LoadBreachedPasswords(dir)
+	assert.NoError(t, err)
+
+	// Test: a breached password
+	contains, err := breached.Contains("password")
+	assert.NoError(t, err)
+	assert.True(t, contains)
+
+	// Test: a password without its range file
+	contains, err = breached.Contains("correct horse battery staple")
+	assert.NoError(t, err)
+	assert.False(t, contains)
+
+	// Test: the policy reports the breached password
+	p := PasswordPolicy{MinLength: 8, Breached: breached}
+	violations, err := p.Validate("password")
+	assert.NoError(t, err)
+	assert.Len(t, violations, 1)
+	assert.Equal(t, PasswordRuleBreached, violations[0].Rule)
+}
+
+func TestHIBPHashSet(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "pwned-passwords-sha1.txt")
+	hashesData := "5BAA6" + breachedPasswordSuffix + ":10434004\n7C4A8D09CA3762AF61E59520943DC26494F8941B:37359195\n"
+	assert.NoError(t, os.WriteFile(path, []byte(hashesData), 0o600))
+
+	breached, err := LoadBreachedPasswords(path)
+	assert.NoError(t, err)
+
+	contains, err := breached.Contains("password")
+	assert.NoError(t, err)
+	assert.True(t, contains)
+	contains, err = breached.Contains("123456")
+	assert.NoError(t, err)
+	assert.True(t, contains)
+	contains, err = breached.Contains("correct horse battery staple")
+	assert.NoError(t, err)
+	assert.False(t, contains)
+}
@@ -0,0 +1,67 @@
+package auth
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/crypto/bcrypt"
+)
+
+var testArgon2idParams = Argon2idParams{
+	Memory:      1024,
+	Iterations:  1,
+	Parallelism: 1,
+	SaltLength:  16,
+	KeyLength:   32,
+}
+
+func TestHashPasswordArgon2id(t *testing.T) {
+	hashedPassword, err := HashPasswordArgon2id("04234", testArgon2idParams)
+	assert.NoError(t, err)
+	assert.True(t, strings.HasPrefix(hashedPassword, "$argon2id$v=19$m=1024,t=1,p=1$"))
+
+	// Test: the right password
+	assert.NoError(t, CheckPasswordHash(hashedPassword, "04234"))
+
+	// Test: a wrong password
+	assert.Error(t, CheckPasswordHash(hashedPassword, "04235"))
+
+	// Test: the salt is random
+	otherHashedPassword, err := HashPasswordArgon2id("04234", testArgon2idParams)
+	assert.NoError(t, err)
+	assert.NotEqual(t, hashedPassword, otherHashedPassword)
+
+	// Test: the passwords longer than 72 bytes aren't truncated
+	longPassword := strings.Repeat("a", 72)
+	hashedPassword, err = HashPasswordArgon2id(longPassword+"1", testArgon2idParams)
+	assert.NoError(t, err)
+	assert.Error(t, CheckPasswordHash(hashedPassword, longPassword+"2"))
+}
+
+func TestCheckPasswordHashBcrypt(t *testing.T) {
+	hashedPasswordData, err := bcrypt.GenerateFromPassword([]byte("04234"), bcrypt.MinCost)
+	assert.NoError(t, err)
+
+	// Test: the legacy bcrypt hashes are still checked
+	assert.NoError(t, CheckPasswordHash(string(hashedPasswordData), "04234"))
+	assert.Error(t, CheckPasswordHash(string(hashedPasswordData), "04235"))
+}
+
+func TestPasswordNeedsRehash(t *testing.T) {
+	hashedPasswordData, err := bcrypt.GenerateFromPassword([]byte("04234"), bcrypt.MinCost)
+	assert.NoError(t, err)
+	hashedPassword, err := HashPasswordArgon2id("04234", testArgon2idParams)
+	assert.NoError(t, err)
+
+	// Test: bcrypt hashes are upgraded
+	assert.True(t, PasswordNeedsRehash(string(hashedPasswordData), testArgon2idParams))
+
+	// Test: the hashes with the current params are kept
+	assert.False(t, PasswordNeedsRehash(hashedPassword, testArgon2idParams))
+
+	// Test: the hashes with other params are upgraded
+	strongerParams := testArgon2idParams
+	strongerParams.Iterations = 2
+	assert.True(t, PasswordNeedsRehash(hashedPassword, strongerParams))
+}
@@ -0,0 +1,27 @@
+package auth
+
+import "slices"
+
+// Roles of the users. Every role has the permissions of the previous ones.
+const (
+	RoleUser      = "user"
+	RoleModerator = "moderator"
+	RoleAdmin     = "admin"
+)
+
+var roles = []string{RoleUser, RoleModerator, RoleAdmin}
+
+func ValidRole(role string) bool {
+	return slices.Contains(roles, role)
+}
+
+// RoleAtLeast reports whether the role has the permissions of the required role.
+// The empty role of the JWTs issued before the roles were introduced is the user role.
+func RoleAtLeast(role, requiredRole string) bool {
+	if role == "" {
+		role = RoleUser
+	}
+	roleIndex := slices.Index(roles, role)
+	requiredRoleIndex := slices.Index(roles, requiredRole)
+	return roleIndex >= 0 && requiredRoleIndex >= 0 && roleIndex >= requiredRoleIndex
+}
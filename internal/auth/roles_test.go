@@ -0,0 +1,29 @@
+package auth
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRoleAtLeast(t *testing.T) {
+	assert.True(t, RoleAtLeast(RoleAdmin, RoleAdmin))
+	assert.True(t, RoleAtLeast(RoleAdmin, RoleModerator))
+	assert.True(t, RoleAtLeast(RoleModerator, RoleUser))
+	assert.False(t, RoleAtLeast(RoleModerator, RoleAdmin))
+	assert.False(t, RoleAtLeast(RoleUser, RoleModerator))
+
+	// Test: the empty role is the user role
+	assert.True(t, RoleAtLeast("", RoleUser))
+	assert.False(t, RoleAtLeast("", RoleModerator))
+
+	// Test: unknown roles have no permissions
+	assert.False(t, RoleAtLeast("superuser", RoleUser))
+	assert.False(t, RoleAtLeast(RoleAdmin, "superuser"))
+}
+
+func TestValidRole(t *testing.T) {
+	assert.True(t, ValidRole(RoleModerator))
+	assert.False(t, ValidRole(""))
+	assert.False(t, ValidRole("Admin"))
+}
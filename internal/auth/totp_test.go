@@ -0,0 +1,80 @@
+package auth
+
+import (
+	"encoding/base32"
+	"fmt"
+	"net/url"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTOTPCode(t *testing.T) {
+	// Test: the SHA-1 test vectors of RFC 6238 truncated to 6 digits
+	secret := base32.StdEncoding.EncodeToString([]byte("12345678901234567890"))
+	vectors := map[int64]string{
+		59:          "287082",
+		1111111109:  "081804",
+		1111111111:  "050471",
+		1234567890:  "005924",
+		2000000000:  "279037",
+		20000000000: "353130",
+	}
+	for unixTime, expectedCode := range vectors {
+		code, err := TOTPCode(secret, time.Unix(unixTime, 0))
+		assert.NoError(t, err)
+		assert.Equal(t, expectedCode, code)
+	}
+}
+
+func TestValidateTOTP(t *testing.T) {
+	secret, err := GenerateTOTPSecret()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		t.FailNow()
+	}
+	now := time.Unix(1_750_000_000, 0)
+	code, err := TOTPCode(secret, now)
+	assert.NoError(t, err)
+
+	// Test: the code is valid within the clock skew
+	step, err := ValidateTOTP(secret, code, now.Add(totpPeriod*time.Second))
+	assert.NoError(t, err)
+	assert.Equal(t, now.Unix()/totpPeriod, step)
+
+	// Test: the code is invalid out of the clock skew
+	_, err = ValidateTOTP(secret, code, now.Add(3*totpPeriod*time.Second))
+	assert.Error(t, err)
+
+	// Test: a wrong code is invalid
+	_, err = ValidateTOTP(secret, "000000x", now)
+	assert.Error(t, err)
+}
+
+func TestTOTPProvisioningURI(t *testing.T) {
+	uri := TOTPProvisioningURI("JBSWY3DPEHPK3PXP", "Chirpy", "saul@bettercall.com")
+	parsedURI, err := url.Parse(uri)
+	assert.NoError(t, err)
+	assert.Equal(t, "otpauth", parsedURI.Scheme)
+	assert.Equal(t, "totp", parsedURI.Host)
+	assert.Equal(t, "/Chirpy:saul@bettercall.com", parsedURI.Path)
+	assert.Equal(t, "JBSWY3DPEHPK3PXP", parsedURI.Query().Get("secret"))
+	assert.Equal(t, "Chirpy", parsedURI.Query().Get("issuer"))
+}
+
+func TestGenerateRecoveryCodes(t *testing.T) {
+	codes, err := GenerateRecoveryCodes(10)
+	assert.NoError(t, err)
+	assert.Len(t, codes, 10)
+	assert.NotEqual(t, codes[0], codes[1])
+
+	// Test: the hash ignores the case and dashes
+	assert.Equal(t,
+		HashRecoveryCode(codes[0]),
+		HashRecoveryCode(strings.ToUpper(strings.ReplaceAll(codes[0], "-", ""))),
+	)
+	assert.NotEqual(t, HashRecoveryCode(codes[0]), HashRecoveryCode(codes[1]))
+}
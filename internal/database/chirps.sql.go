@@ -10,6 +10,7 @@ import (
 	"database/sql"
 
 	"github.com/google/uuid"
+	"github.com/lib/pq"
 )
 
 const createChirp = `-- name: CreateChirp :one
@@ -19,26 +20,43 @@ INSERT INTO
         created_at,
         updated_at,
         body,
-        user_id
+        user_id,
+        in_reply_to,
+        conversation_id,
+        quote_of
     )
 VALUES (
-        gen_random_uuid(),
+        $1,
         now(),
         now(),
-        $1,
-        $2
+        $2,
+        $3,
+        $4,
+        $5,
+        $6
     )
 RETURNING
-    id, created_at, updated_at, body, user_id, deleted_at
+    id, created_at, updated_at, body, user_id, deleted_at, edited_at, in_reply_to, conversation_id, reply_count, like_count, rechirp_of, quote_of, rechirp_count, quote_count
 `
 
 type CreateChirpParams struct {
-	Body   string
-	UserID uuid.UUID
+	ID             uuid.UUID
+	Body           string
+	UserID         uuid.UUID
+	InReplyTo      uuid.NullUUID
+	ConversationID uuid.UUID
+	QuoteOf        uuid.NullUUID
 }
 
 func (q *Queries) CreateChirp(ctx context.Context, arg CreateChirpParams) (Chirp, error) {
-	row := q.db.QueryRowContext(ctx, createChirp, arg.Body, arg.UserID)
+	row := q.db.QueryRowContext(ctx, createChirp,
+		arg.ID,
+		arg.Body,
+		arg.UserID,
+		arg.InReplyTo,
+		arg.ConversationID,
+		arg.QuoteOf,
+	)
 	var i Chirp
 	err := row.Scan(
 		&i.ID,
@@ -47,12 +65,188 @@ func (q *Queries) CreateChirp(ctx context.Context, arg CreateChirpParams) (Chirp
 		&i.Body,
 		&i.UserID,
 		&i.DeletedAt,
+		&i.EditedAt,
+		&i.InReplyTo,
+		&i.ConversationID,
+		&i.ReplyCount,
+		&i.LikeCount,
+		&i.RechirpOf,
+		&i.QuoteOf,
+		&i.RechirpCount,
+		&i.QuoteCount,
 	)
 	return i, err
 }
 
+const createRechirp = `-- name: CreateRechirp :one
+INSERT INTO
+    chirps (
+        id,
+        created_at,
+        updated_at,
+        body,
+        user_id,
+        conversation_id,
+        rechirp_of
+    )
+VALUES (
+        $1,
+        now(),
+        now(),
+        '',
+        $2,
+        $1,
+        $3
+    )
+ON CONFLICT (user_id, rechirp_of) WHERE rechirp_of IS NOT NULL
+AND deleted_at IS NULL DO NOTHING
+RETURNING
+    id, created_at, updated_at, body, user_id, deleted_at, edited_at, in_reply_to, conversation_id, reply_count, like_count, rechirp_of, quote_of, rechirp_count, quote_count
+`
+
+type CreateRechirpParams struct {
+	ID        uuid.UUID
+	UserID    uuid.UUID
+	RechirpOf uuid.NullUUID
+}
+
+// the rechirp starts its own conversation. sql.ErrNoRows if the user has already rechirped the chirp
+func (q *Queries) CreateRechirp(ctx context.Context, arg CreateRechirpParams) (Chirp, error) {
+	row := q.db.QueryRowContext(ctx, createRechirp, arg.ID, arg.UserID, arg.RechirpOf)
+	var i Chirp
+	err := row.Scan(
+		&i.ID,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+		&i.Body,
+		&i.UserID,
+		&i.DeletedAt,
+		&i.EditedAt,
+		&i.InReplyTo,
+		&i.ConversationID,
+		&i.ReplyCount,
+		&i.LikeCount,
+		&i.RechirpOf,
+		&i.QuoteOf,
+		&i.RechirpCount,
+		&i.QuoteCount,
+	)
+	return i, err
+}
+
+const deleteUserRechirp = `-- name: DeleteUserRechirp :execrows
+UPDATE chirps
+SET
+    deleted_at = now()
+WHERE
+    user_id = $1
+    AND rechirp_of = $2
+    AND deleted_at IS NULL
+`
+
+type DeleteUserRechirpParams struct {
+	UserID    uuid.UUID
+	RechirpOf uuid.NullUUID
+}
+
+func (q *Queries) DeleteUserRechirp(ctx context.Context, arg DeleteUserRechirpParams) (int64, error) {
+	result, err := q.db.ExecContext(ctx, deleteUserRechirp, arg.UserID, arg.RechirpOf)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+const editChirp = `-- name: EditChirp :one
+UPDATE chirps
+SET
+    body = $2,
+    updated_at = now(),
+    edited_at = now()
+WHERE
+    id = $1
+RETURNING
+    id, created_at, updated_at, body, user_id, deleted_at, edited_at, in_reply_to, conversation_id, reply_count, like_count, rechirp_of, quote_of, rechirp_count, quote_count
+`
+
+type EditChirpParams struct {
+	ID   uuid.UUID
+	Body string
+}
+
+func (q *Queries) EditChirp(ctx context.Context, arg EditChirpParams) (Chirp, error) {
+	row := q.db.QueryRowContext(ctx, editChirp, arg.ID, arg.Body)
+	var i Chirp
+	err := row.Scan(
+		&i.ID,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+		&i.Body,
+		&i.UserID,
+		&i.DeletedAt,
+		&i.EditedAt,
+		&i.InReplyTo,
+		&i.ConversationID,
+		&i.ReplyCount,
+		&i.LikeCount,
+		&i.RechirpOf,
+		&i.QuoteOf,
+		&i.RechirpCount,
+		&i.QuoteCount,
+	)
+	return i, err
+}
+
+const selectAllChirpsByUserID = `-- name: SelectAllChirpsByUserID :many
+SELECT id, created_at, updated_at, body, user_id, deleted_at, edited_at, in_reply_to, conversation_id, reply_count, like_count, rechirp_of, quote_of, rechirp_count, quote_count FROM chirps WHERE user_id = $1 ORDER BY created_at
+`
+
+func (q *Queries) SelectAllChirpsByUserID(ctx context.Context, userID uuid.UUID) ([]Chirp, error) {
+	rows, err := q.db.QueryContext(ctx, selectAllChirpsByUserID, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []Chirp
+	for rows.Next() {
+		var i Chirp
+		if err := rows.Scan(
+			&i.ID,
+			&i.CreatedAt,
+			&i.UpdatedAt,
+			&i.Body,
+			&i.UserID,
+			&i.DeletedAt,
+			&i.EditedAt,
+			&i.InReplyTo,
+			&i.ConversationID,
+			&i.ReplyCount,
+			&i.LikeCount,
+			&i.RechirpOf,
+			&i.QuoteOf,
+			&i.RechirpCount,
+			&i.QuoteCount,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
 const selectChirp = `-- name: SelectChirp :one
-SELECT id, created_at, updated_at, body, user_id, deleted_at FROM chirps WHERE id = $1
+SELECT chirps.id, chirps.created_at, chirps.updated_at, chirps.body, chirps.user_id, chirps.deleted_at, chirps.edited_at, chirps.in_reply_to, chirps.conversation_id, chirps.reply_count, chirps.like_count, chirps.rechirp_of, chirps.quote_of, chirps.rechirp_count, chirps.quote_count
+FROM chirps
+    JOIN users ON users.id = chirps.user_id
+WHERE
+    chirps.id = $1
+    AND users.deleted_at IS NULL
 `
 
 func (q *Queries) SelectChirp(ctx context.Context, id uuid.UUID) (Chirp, error) {
@@ -65,16 +259,300 @@ func (q *Queries) SelectChirp(ctx context.Context, id uuid.UUID) (Chirp, error)
 		&i.Body,
 		&i.UserID,
 		&i.DeletedAt,
+		&i.EditedAt,
+		&i.InReplyTo,
+		&i.ConversationID,
+		&i.ReplyCount,
+		&i.LikeCount,
+		&i.RechirpOf,
+		&i.QuoteOf,
+		&i.RechirpCount,
+		&i.QuoteCount,
+	)
+	return i, err
+}
+
+const selectChirpForUpdate = `-- name: SelectChirpForUpdate :one
+SELECT id, created_at, updated_at, body, user_id, deleted_at, edited_at, in_reply_to, conversation_id, reply_count, like_count, rechirp_of, quote_of, rechirp_count, quote_count FROM chirps WHERE id = $1 FOR UPDATE
+`
+
+// locks the chirp until the transaction ends
+func (q *Queries) SelectChirpForUpdate(ctx context.Context, id uuid.UUID) (Chirp, error) {
+	row := q.db.QueryRowContext(ctx, selectChirpForUpdate, id)
+	var i Chirp
+	err := row.Scan(
+		&i.ID,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+		&i.Body,
+		&i.UserID,
+		&i.DeletedAt,
+		&i.EditedAt,
+		&i.InReplyTo,
+		&i.ConversationID,
+		&i.ReplyCount,
+		&i.LikeCount,
+		&i.RechirpOf,
+		&i.QuoteOf,
+		&i.RechirpCount,
+		&i.QuoteCount,
 	)
 	return i, err
 }
 
+const selectChirpReplies = `-- name: SelectChirpReplies :many
+SELECT chirps.id, chirps.created_at, chirps.updated_at, chirps.body, chirps.user_id, chirps.deleted_at, chirps.edited_at, chirps.in_reply_to, chirps.conversation_id, chirps.reply_count, chirps.like_count, chirps.rechirp_of, chirps.quote_of, chirps.rechirp_count, chirps.quote_count
+FROM chirps
+    JOIN users ON users.id = chirps.user_id
+WHERE
+    chirps.in_reply_to = $1
+    AND chirps.deleted_at IS NULL
+    AND users.deleted_at IS NULL
+    AND (
+        $2::timestamptz IS NULL
+        OR (chirps.created_at, chirps.id) > (
+            $2,
+            $3::uuid
+        )
+    )
+ORDER BY chirps.created_at, chirps.id
+LIMIT $4
+`
+
+type SelectChirpRepliesParams struct {
+	InReplyTo       uuid.NullUUID
+	CursorCreatedAt sql.NullTime
+	CursorID        uuid.NullUUID
+	RowLimit        int32
+}
+
+// the page of the chirp's direct replies after the cursor, the oldest first
+func (q *Queries) SelectChirpReplies(ctx context.Context, arg SelectChirpRepliesParams) ([]Chirp, error) {
+	rows, err := q.db.QueryContext(ctx, selectChirpReplies,
+		arg.InReplyTo,
+		arg.CursorCreatedAt,
+		arg.CursorID,
+		arg.RowLimit,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []Chirp
+	for rows.Next() {
+		var i Chirp
+		if err := rows.Scan(
+			&i.ID,
+			&i.CreatedAt,
+			&i.UpdatedAt,
+			&i.Body,
+			&i.UserID,
+			&i.DeletedAt,
+			&i.EditedAt,
+			&i.InReplyTo,
+			&i.ConversationID,
+			&i.ReplyCount,
+			&i.LikeCount,
+			&i.RechirpOf,
+			&i.QuoteOf,
+			&i.RechirpCount,
+			&i.QuoteCount,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
 const selectChirps = `-- name: SelectChirps :many
-SELECT id, created_at, updated_at, body, user_id, deleted_at FROM chirps WHERE deleted_at IS NULL ORDER BY created_at
+SELECT chirps.id, chirps.created_at, chirps.updated_at, chirps.body, chirps.user_id, chirps.deleted_at, chirps.edited_at, chirps.in_reply_to, chirps.conversation_id, chirps.reply_count, chirps.like_count, chirps.rechirp_of, chirps.quote_of, chirps.rechirp_count, chirps.quote_count
+FROM chirps
+    JOIN users ON users.id = chirps.user_id
+WHERE
+    chirps.deleted_at IS NULL
+    AND users.deleted_at IS NULL
+    AND (
+        $1::uuid IS NULL
+        OR chirps.user_id = $1
+    )
+    AND (
+        $2::timestamptz IS NULL
+        OR (chirps.created_at, chirps.id) > (
+            $2,
+            $3::uuid
+        )
+    )
+ORDER BY chirps.created_at, chirps.id
+LIMIT $4
+`
+
+type SelectChirpsParams struct {
+	UserID          uuid.NullUUID
+	CursorCreatedAt sql.NullTime
+	CursorID        uuid.NullUUID
+	RowLimit        int32
+}
+
+// the page of the chirps after the cursor in the ascending order of (created_at, id)
+func (q *Queries) SelectChirps(ctx context.Context, arg SelectChirpsParams) ([]Chirp, error) {
+	rows, err := q.db.QueryContext(ctx, selectChirps,
+		arg.UserID,
+		arg.CursorCreatedAt,
+		arg.CursorID,
+		arg.RowLimit,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []Chirp
+	for rows.Next() {
+		var i Chirp
+		if err := rows.Scan(
+			&i.ID,
+			&i.CreatedAt,
+			&i.UpdatedAt,
+			&i.Body,
+			&i.UserID,
+			&i.DeletedAt,
+			&i.EditedAt,
+			&i.InReplyTo,
+			&i.ConversationID,
+			&i.ReplyCount,
+			&i.LikeCount,
+			&i.RechirpOf,
+			&i.QuoteOf,
+			&i.RechirpCount,
+			&i.QuoteCount,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const selectChirpsDesc = `-- name: SelectChirpsDesc :many
+SELECT chirps.id, chirps.created_at, chirps.updated_at, chirps.body, chirps.user_id, chirps.deleted_at, chirps.edited_at, chirps.in_reply_to, chirps.conversation_id, chirps.reply_count, chirps.like_count, chirps.rechirp_of, chirps.quote_of, chirps.rechirp_count, chirps.quote_count
+FROM chirps
+    JOIN users ON users.id = chirps.user_id
+WHERE
+    chirps.deleted_at IS NULL
+    AND users.deleted_at IS NULL
+    AND (
+        $1::uuid IS NULL
+        OR chirps.user_id = $1
+    )
+    AND (
+        $2::timestamptz IS NULL
+        OR (chirps.created_at, chirps.id) < (
+            $2,
+            $3::uuid
+        )
+    )
+ORDER BY chirps.created_at DESC, chirps.id DESC
+LIMIT $4
+`
+
+type SelectChirpsDescParams struct {
+	UserID          uuid.NullUUID
+	CursorCreatedAt sql.NullTime
+	CursorID        uuid.NullUUID
+	RowLimit        int32
+}
+
+// the page of the chirps after the cursor in the descending order of (created_at, id)
+func (q *Queries) SelectChirpsDesc(ctx context.Context, arg SelectChirpsDescParams) ([]Chirp, error) {
+	rows, err := q.db.QueryContext(ctx, selectChirpsDesc,
+		arg.UserID,
+		arg.CursorCreatedAt,
+		arg.CursorID,
+		arg.RowLimit,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []Chirp
+	for rows.Next() {
+		var i Chirp
+		if err := rows.Scan(
+			&i.ID,
+			&i.CreatedAt,
+			&i.UpdatedAt,
+			&i.Body,
+			&i.UserID,
+			&i.DeletedAt,
+			&i.EditedAt,
+			&i.InReplyTo,
+			&i.ConversationID,
+			&i.ReplyCount,
+			&i.LikeCount,
+			&i.RechirpOf,
+			&i.QuoteOf,
+			&i.RechirpCount,
+			&i.QuoteCount,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const selectConversationChirps = `-- name: SelectConversationChirps :many
+SELECT
+    chirps.id,
+    chirps.created_at,
+    chirps.updated_at,
+    chirps.body,
+    chirps.user_id,
+    COALESCE(chirps.deleted_at, users.deleted_at) AS deleted_at,
+    chirps.edited_at,
+    chirps.in_reply_to,
+    chirps.conversation_id,
+    chirps.reply_count,
+    chirps.like_count,
+    chirps.rechirp_of,
+    chirps.quote_of,
+    chirps.rechirp_count,
+    chirps.quote_count
+FROM chirps
+    JOIN users ON users.id = chirps.user_id
+WHERE
+    chirps.conversation_id = $1
+ORDER BY chirps.created_at, chirps.id
+LIMIT $2
 `
 
-func (q *Queries) SelectChirps(ctx context.Context) ([]Chirp, error) {
-	rows, err := q.db.QueryContext(ctx, selectChirps)
+type SelectConversationChirpsParams struct {
+	ConversationID uuid.UUID
+	RowLimit       int32
+}
+
+// the chirps of the conversation including the deleted ones.
+// The chirps of the deleted users are deleted with them.
+func (q *Queries) SelectConversationChirps(ctx context.Context, arg SelectConversationChirpsParams) ([]Chirp, error) {
+	rows, err := q.db.QueryContext(ctx, selectConversationChirps, arg.ConversationID, arg.RowLimit)
 	if err != nil {
 		return nil, err
 	}
@@ -89,6 +567,15 @@ func (q *Queries) SelectChirps(ctx context.Context) ([]Chirp, error) {
 			&i.Body,
 			&i.UserID,
 			&i.DeletedAt,
+			&i.EditedAt,
+			&i.InReplyTo,
+			&i.ConversationID,
+			&i.ReplyCount,
+			&i.LikeCount,
+			&i.RechirpOf,
+			&i.QuoteOf,
+			&i.RechirpCount,
+			&i.QuoteCount,
 		); err != nil {
 			return nil, err
 		}
@@ -103,17 +590,19 @@ func (q *Queries) SelectChirps(ctx context.Context) ([]Chirp, error) {
 	return items, nil
 }
 
-const selectChirpsByUserID = `-- name: SelectChirpsByUserID :many
-SELECT id, created_at, updated_at, body, user_id, deleted_at
+const selectVisibleChirpsByIDs = `-- name: SelectVisibleChirpsByIDs :many
+SELECT chirps.id, chirps.created_at, chirps.updated_at, chirps.body, chirps.user_id, chirps.deleted_at, chirps.edited_at, chirps.in_reply_to, chirps.conversation_id, chirps.reply_count, chirps.like_count, chirps.rechirp_of, chirps.quote_of, chirps.rechirp_count, chirps.quote_count
 FROM chirps
+    JOIN users ON users.id = chirps.user_id
 WHERE
-    deleted_at IS NULL
-    AND user_id = $1
-ORDER BY created_at
+    chirps.id = ANY($1::uuid[])
+    AND chirps.deleted_at IS NULL
+    AND users.deleted_at IS NULL
 `
 
-func (q *Queries) SelectChirpsByUserID(ctx context.Context, userID uuid.UUID) ([]Chirp, error) {
-	rows, err := q.db.QueryContext(ctx, selectChirpsByUserID, userID)
+// the chirps among the ids that aren't deleted and whose authors aren't deleted
+func (q *Queries) SelectVisibleChirpsByIDs(ctx context.Context, ids []uuid.UUID) ([]Chirp, error) {
+	rows, err := q.db.QueryContext(ctx, selectVisibleChirpsByIDs, pq.Array(ids))
 	if err != nil {
 		return nil, err
 	}
@@ -128,6 +617,15 @@ func (q *Queries) SelectChirpsByUserID(ctx context.Context, userID uuid.UUID) ([
 			&i.Body,
 			&i.UserID,
 			&i.DeletedAt,
+			&i.EditedAt,
+			&i.InReplyTo,
+			&i.ConversationID,
+			&i.ReplyCount,
+			&i.LikeCount,
+			&i.RechirpOf,
+			&i.QuoteOf,
+			&i.RechirpCount,
+			&i.QuoteCount,
 		); err != nil {
 			return nil, err
 		}
@@ -6,11 +6,37 @@ package database
 
 import (
 	"database/sql"
+	"encoding/json"
 	"time"
 
 	"github.com/google/uuid"
 )
 
+type AccountRestoreToken struct {
+	// SHA-256 of the account restore token
+	TokenHash string
+	CreatedAt time.Time
+	UserID    uuid.UUID
+	ExpiresAt time.Time
+	UsedAt    sql.NullTime
+}
+
+// Append-only log of the security events
+type AuditEvent struct {
+	ID        uuid.UUID
+	CreatedAt time.Time
+	EventType string
+	// The user who did it. NULL for the anonymous clients and Polka
+	ActorID uuid.NullUUID
+	// The user whose account it affected
+	UserID uuid.NullUUID
+	// The email the client has logged in with
+	Email     sql.NullString
+	IpAddress sql.NullString
+	UserAgent sql.NullString
+	Details   json.RawMessage
+}
+
 type Chirp struct {
 	ID        uuid.UUID
 	CreatedAt time.Time
@@ -18,15 +44,175 @@ type Chirp struct {
 	Body      string
 	UserID    uuid.UUID
 	DeletedAt sql.NullTime
+	// When the author last edited the body. NULL if it has never been edited
+	EditedAt sql.NullTime
+	// The chirp it replies to. NULL for the chirps that start the conversations
+	InReplyTo uuid.NullUUID
+	// The id of the chirp that started the conversation
+	ConversationID uuid.UUID
+	// The number of the direct replies that aren't deleted
+	ReplyCount int32
+	// The number of the likes
+	LikeCount int32
+	// The original chirp the rechirp shares. The rechirps have no body
+	RechirpOf uuid.NullUUID
+	// The chirp the quote chirp wraps with its body
+	QuoteOf uuid.NullUUID
+	// The number of the rechirps that aren't deleted
+	RechirpCount int32
+	// The number of the quote chirps that aren't deleted
+	QuoteCount int32
+}
+
+// The previous bodies of the edited chirps
+type ChirpRevision struct {
+	ID      uuid.UUID
+	ChirpID uuid.UUID
+	Body    string
+	// When the body was posted or edited in
+	CreatedAt time.Time
+	// When the edit replaced the body
+	ReplacedAt time.Time
+}
+
+type EmailVerificationToken struct {
+	// SHA-256 of the email verification token
+	TokenHash string
+	CreatedAt time.Time
+	UserID    uuid.UUID
+	// The email the token verifies. The token is void if the user changes the email
+	Email     string
+	ExpiresAt time.Time
+	UsedAt    sql.NullTime
+}
+
+// The users' likes of the chirps. A user likes a chirp once
+type Like struct {
+	UserID    uuid.UUID
+	ChirpID   uuid.UUID
+	CreatedAt time.Time
+}
+
+// Failed logins per email and per client IP shared by all the server instances
+type LoginAttempt struct {
+	// email:{email} or ip:{client IP}
+	Key           string
+	Failures      int32
+	LastFailureAt time.Time
+	BlockedUntil  sql.NullTime
+}
+
+type MagicLinkToken struct {
+	// SHA-256 of the sign-in link token
+	TokenHash string
+	CreatedAt time.Time
+	UserID    uuid.UUID
+	// The email the link is sent to. The link is void if the user changes the email
+	Email     string
+	ExpiresAt time.Time
+	UsedAt    sql.NullTime
+}
+
+type OauthAuthorizationCode struct {
+	// SHA-256 of the authorization code
+	CodeHash    string
+	CreatedAt   time.Time
+	ClientID    string
+	UserID      uuid.UUID
+	RedirectUri string
+	Scopes      []string
+	// PKCE S256 code challenge
+	CodeChallenge string
+	ExpiresAt     time.Time
+	UsedAt        sql.NullTime
+}
+
+type OauthClient struct {
+	ID        string
+	CreatedAt time.Time
+	UpdatedAt time.Time
+	// Developer who registered the client
+	UserID uuid.UUID
+	Name   string
+	// SHA-256 of the client secret. NULL for the public clients
+	SecretHash   sql.NullString
+	RedirectUris []string
+	// Scopes the client may request
+	Scopes []string
+}
+
+// Pending logins with the OpenID Connect provider
+type OidcLoginState struct {
+	// SHA-256 of the state param
+	StateHash    string
+	CreatedAt    time.Time
+	Nonce        string
+	CodeVerifier string
+	ExpiresAt    time.Time
+	UsedAt       sql.NullTime
+}
+
+type PasswordResetToken struct {
+	// SHA-256 of the password reset token
+	TokenHash string
+	CreatedAt time.Time
+	UserID    uuid.UUID
+	ExpiresAt time.Time
+	UsedAt    sql.NullTime
+}
+
+type PersonalAccessToken struct {
+	ID        uuid.UUID
+	CreatedAt time.Time
+	UserID    uuid.UUID
+	Name      string
+	// SHA-256 of the personal access token
+	TokenHash string
+	Scopes    []string
+	// NULL for the tokens that never expire
+	ExpiresAt  sql.NullTime
+	LastUsedAt sql.NullTime
+	RevokedAt  sql.NullTime
+	// The user's token version when the token is created. Bumping the user's version revokes the token
+	TokenVersion int32
+}
+
+type RecoveryCode struct {
+	ID        uuid.UUID
+	CreatedAt time.Time
+	UserID    uuid.UUID
+	CodeHash  string
+	UsedAt    sql.NullTime
 }
 
 type RefreshToken struct {
-	Token     string
+	// HMAC-SHA256 of the refresh token
+	TokenHash string
 	CreatedAt time.Time
 	UpdatedAt time.Time
 	UserID    uuid.UUID
 	ExpiresAt time.Time
 	RevokedAt sql.NullTime
+	// All the refresh tokens rotated from the same login
+	FamilyID uuid.UUID
+	// When the refresh token was exchanged for the next one
+	RotatedAt sql.NullTime
+	// When the user logged in the session
+	StartedAt  time.Time
+	LastUsedAt time.Time
+	UserAgent  sql.NullString
+	IpAddress  sql.NullString
+	// Optional name of the device the user has given at login
+	DeviceName sql.NullString
+}
+
+// The access JWTs revoked one by one. They are kept until they expire
+type RevokedAccessToken struct {
+	// The JWT ID of the revoked access JWT
+	Jti       string
+	UserID    uuid.UUID
+	RevokedAt time.Time
+	ExpiresAt time.Time
 }
 
 type User struct {
@@ -37,4 +223,31 @@ type User struct {
 	HashedPassword sql.NullString
 	// Chirpy premium subscription
 	IsChirpyRed bool
+	// Base32 TOTP secret. Pending confirmation until totp_enabled_at is set
+	TotpSecret    sql.NullString
+	TotpEnabledAt sql.NullTime
+	// The last TOTP time step used to log in. Used codes are rejected
+	TotpLastStep sql.NullInt64
+	// When the user confirmed the current email. NULL if unverified
+	EmailVerifiedAt sql.NullTime
+	// user, moderator or admin. Every role has the permissions of the previous ones
+	Role string
+	// Version of the access JWTs. Bumping it revokes all the user's access JWTs
+	TokenVersion int32
+	// When the user deleted the account. The account can be restored until it's purged after the grace period
+	DeletedAt sql.NullTime
+}
+
+// Users' accounts at the OpenID Connect providers
+type UserIdentity struct {
+	Issuer    string
+	Subject   string
+	CreatedAt time.Time
+	UserID    uuid.UUID
+}
+
+// Processed webhook events. The replayed event ids are rejected
+type WebhookEvent struct {
+	ID         string
+	ReceivedAt time.Time
 }
@@ -16,59 +16,255 @@ import (
 const insertRefreshToken = `-- name: InsertRefreshToken :exec
 INSERT INTO
     refresh_tokens (
-        token,
+        token_hash,
         created_at,
         updated_at,
         user_id,
-        expires_at
+        expires_at,
+        family_id,
+        started_at,
+        last_used_at,
+        user_agent,
+        ip_address,
+        device_name
+    )
+VALUES (
+        $1,
+        NOW(),
+        NOW(),
+        $2,
+        $3,
+        $4,
+        $5,
+        NOW(),
+        $6,
+        $7,
+        $8
     )
-VALUES ($1, NOW(), NOW(), $2, $3)
 `
 
 type InsertRefreshTokenParams struct {
-	Token     string
-	UserID    uuid.UUID
-	ExpiresAt time.Time
+	TokenHash  string
+	UserID     uuid.UUID
+	ExpiresAt  time.Time
+	FamilyID   uuid.UUID
+	StartedAt  time.Time
+	UserAgent  sql.NullString
+	IpAddress  sql.NullString
+	DeviceName sql.NullString
 }
 
 func (q *Queries) InsertRefreshToken(ctx context.Context, arg InsertRefreshTokenParams) error {
-	_, err := q.db.ExecContext(ctx, insertRefreshToken, arg.Token, arg.UserID, arg.ExpiresAt)
+	_, err := q.db.ExecContext(ctx, insertRefreshToken,
+		arg.TokenHash,
+		arg.UserID,
+		arg.ExpiresAt,
+		arg.FamilyID,
+		arg.StartedAt,
+		arg.UserAgent,
+		arg.IpAddress,
+		arg.DeviceName,
+	)
+	return err
+}
+
+const revokeRefreshTokenFamily = `-- name: RevokeRefreshTokenFamily :exec
+UPDATE refresh_tokens
+SET
+    updated_at = now(),
+    revoked_at = now()
+WHERE
+    family_id = $1
+    AND revoked_at IS NULL
+`
+
+func (q *Queries) RevokeRefreshTokenFamily(ctx context.Context, familyID uuid.UUID) error {
+	_, err := q.db.ExecContext(ctx, revokeRefreshTokenFamily, familyID)
+	return err
+}
+
+const revokeUserRefreshTokens = `-- name: RevokeUserRefreshTokens :exec
+UPDATE refresh_tokens
+SET
+    updated_at = now(),
+    revoked_at = now()
+WHERE
+    user_id = $1
+    AND revoked_at IS NULL
+`
+
+func (q *Queries) RevokeUserRefreshTokens(ctx context.Context, userID uuid.UUID) error {
+	_, err := q.db.ExecContext(ctx, revokeUserRefreshTokens, userID)
+	return err
+}
+
+const revokeUserSession = `-- name: RevokeUserSession :execrows
+UPDATE refresh_tokens
+SET
+    updated_at = now(),
+    revoked_at = now()
+WHERE
+    family_id = $1
+    AND user_id = $2
+    AND revoked_at IS NULL
+`
+
+type RevokeUserSessionParams struct {
+	FamilyID uuid.UUID
+	UserID   uuid.UUID
+}
+
+func (q *Queries) RevokeUserSession(ctx context.Context, arg RevokeUserSessionParams) (int64, error) {
+	result, err := q.db.ExecContext(ctx, revokeUserSession, arg.FamilyID, arg.UserID)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+const revokeUserSessionsExcept = `-- name: RevokeUserSessionsExcept :exec
+UPDATE refresh_tokens
+SET
+    updated_at = now(),
+    revoked_at = now()
+WHERE
+    user_id = $1
+    AND family_id <> $2
+    AND revoked_at IS NULL
+`
+
+type RevokeUserSessionsExceptParams struct {
+	UserID   uuid.UUID
+	FamilyID uuid.UUID
+}
+
+func (q *Queries) RevokeUserSessionsExcept(ctx context.Context, arg RevokeUserSessionsExceptParams) error {
+	_, err := q.db.ExecContext(ctx, revokeUserSessionsExcept, arg.UserID, arg.FamilyID)
 	return err
 }
 
 const selectRefreshToken = `-- name: SelectRefreshToken :one
-SELECT token, created_at, updated_at, user_id, expires_at, revoked_at FROM refresh_tokens WHERE token = $1
+SELECT token_hash, created_at, updated_at, user_id, expires_at, revoked_at, family_id, rotated_at, started_at, last_used_at, user_agent, ip_address, device_name FROM refresh_tokens WHERE token_hash = $1
 `
 
-func (q *Queries) SelectRefreshToken(ctx context.Context, token string) (RefreshToken, error) {
-	row := q.db.QueryRowContext(ctx, selectRefreshToken, token)
+func (q *Queries) SelectRefreshToken(ctx context.Context, tokenHash string) (RefreshToken, error) {
+	row := q.db.QueryRowContext(ctx, selectRefreshToken, tokenHash)
 	var i RefreshToken
 	err := row.Scan(
-		&i.Token,
+		&i.TokenHash,
 		&i.CreatedAt,
 		&i.UpdatedAt,
 		&i.UserID,
 		&i.ExpiresAt,
 		&i.RevokedAt,
+		&i.FamilyID,
+		&i.RotatedAt,
+		&i.StartedAt,
+		&i.LastUsedAt,
+		&i.UserAgent,
+		&i.IpAddress,
+		&i.DeviceName,
 	)
 	return i, err
 }
 
-const updateRefreshToken = `-- name: UpdateRefreshToken :exec
+const selectSessionActive = `-- name: SelectSessionActive :one
+SELECT EXISTS (
+        SELECT 1
+        FROM refresh_tokens
+        WHERE
+            family_id = $1
+            AND revoked_at IS NULL
+            AND expires_at > now()
+    )
+`
+
+func (q *Queries) SelectSessionActive(ctx context.Context, familyID uuid.UUID) (bool, error) {
+	row := q.db.QueryRowContext(ctx, selectSessionActive, familyID)
+	var exists bool
+	err := row.Scan(&exists)
+	return exists, err
+}
+
+const selectUserSessions = `-- name: SelectUserSessions :many
+SELECT
+    family_id,
+    started_at,
+    last_used_at,
+    expires_at,
+    user_agent,
+    ip_address,
+    device_name
+FROM refresh_tokens
+WHERE
+    user_id = $1
+    AND revoked_at IS NULL
+    AND expires_at > now()
+ORDER BY last_used_at DESC
+`
+
+type SelectUserSessionsRow struct {
+	FamilyID   uuid.UUID
+	StartedAt  time.Time
+	LastUsedAt time.Time
+	ExpiresAt  time.Time
+	UserAgent  sql.NullString
+	IpAddress  sql.NullString
+	DeviceName sql.NullString
+}
+
+func (q *Queries) SelectUserSessions(ctx context.Context, userID uuid.UUID) ([]SelectUserSessionsRow, error) {
+	rows, err := q.db.QueryContext(ctx, selectUserSessions, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []SelectUserSessionsRow
+	for rows.Next() {
+		var i SelectUserSessionsRow
+		if err := rows.Scan(
+			&i.FamilyID,
+			&i.StartedAt,
+			&i.LastUsedAt,
+			&i.ExpiresAt,
+			&i.UserAgent,
+			&i.IpAddress,
+			&i.DeviceName,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const updateRefreshToken = `-- name: UpdateRefreshToken :execrows
 UPDATE refresh_tokens
 SET
     updated_at = now(),
-    revoked_at = $2
+    revoked_at = $2,
+    rotated_at = $3
 WHERE
-    token = $1
+    token_hash = $1
+    AND revoked_at IS NULL
 `
 
 type UpdateRefreshTokenParams struct {
-	Token     string
+	TokenHash string
 	RevokedAt sql.NullTime
+	RotatedAt sql.NullTime
 }
 
-func (q *Queries) UpdateRefreshToken(ctx context.Context, arg UpdateRefreshTokenParams) error {
-	_, err := q.db.ExecContext(ctx, updateRefreshToken, arg.Token, arg.RevokedAt)
-	return err
+func (q *Queries) UpdateRefreshToken(ctx context.Context, arg UpdateRefreshTokenParams) (int64, error) {
+	result, err := q.db.ExecContext(ctx, updateRefreshToken, arg.TokenHash, arg.RevokedAt, arg.RotatedAt)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
 }
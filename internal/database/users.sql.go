@@ -13,6 +13,62 @@ import (
 	"github.com/google/uuid"
 )
 
+const bumpUserTokenVersion = `-- name: BumpUserTokenVersion :one
+UPDATE users
+SET
+    updated_at = now(),
+    token_version = token_version + 1
+WHERE
+    id = $1
+RETURNING
+    token_version
+`
+
+func (q *Queries) BumpUserTokenVersion(ctx context.Context, id uuid.UUID) (int32, error) {
+	row := q.db.QueryRowContext(ctx, bumpUserTokenVersion, id)
+	var token_version int32
+	err := row.Scan(&token_version)
+	return token_version, err
+}
+
+const deleteUser = `-- name: DeleteUser :execrows
+UPDATE users
+SET
+    updated_at = now(),
+    deleted_at = now()
+WHERE
+    id = $1
+    AND deleted_at IS NULL
+`
+
+func (q *Queries) DeleteUser(ctx context.Context, id uuid.UUID) (int64, error) {
+	result, err := q.db.ExecContext(ctx, deleteUser, id)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+const enableUserTOTP = `-- name: EnableUserTOTP :exec
+UPDATE users
+SET
+    updated_at = now(),
+    totp_enabled_at = now(),
+    totp_last_step = $2
+WHERE
+    id = $1
+`
+
+type EnableUserTOTPParams struct {
+	ID           uuid.UUID
+	TotpLastStep sql.NullInt64
+}
+
+func (q *Queries) EnableUserTOTP(ctx context.Context, arg EnableUserTOTPParams) error {
+	_, err := q.db.ExecContext(ctx, enableUserTOTP, arg.ID, arg.TotpLastStep)
+	return err
+}
+
 const insertUser = `-- name: InsertUser :one
 INSERT INTO
     users (
@@ -60,6 +116,38 @@ func (q *Queries) InsertUser(ctx context.Context, arg InsertUserParams) (InsertU
 	return i, err
 }
 
+const purgeDeletedUsers = `-- name: PurgeDeletedUsers :execrows
+DELETE FROM users WHERE deleted_at < $1
+`
+
+func (q *Queries) PurgeDeletedUsers(ctx context.Context, deletedAt sql.NullTime) (int64, error) {
+	result, err := q.db.ExecContext(ctx, purgeDeletedUsers, deletedAt)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+const rehashUserPassword = `-- name: RehashUserPassword :exec
+UPDATE users
+SET
+    hashed_password = $1
+WHERE
+    id = $2
+    AND hashed_password = $3
+`
+
+type RehashUserPasswordParams struct {
+	NewHashedPassword sql.NullString
+	ID                uuid.UUID
+	OldHashedPassword sql.NullString
+}
+
+func (q *Queries) RehashUserPassword(ctx context.Context, arg RehashUserPasswordParams) error {
+	_, err := q.db.ExecContext(ctx, rehashUserPassword, arg.NewHashedPassword, arg.ID, arg.OldHashedPassword)
+	return err
+}
+
 const resetUsers = `-- name: ResetUsers :exec
 DELETE FROM users
 `
@@ -69,8 +157,26 @@ func (q *Queries) ResetUsers(ctx context.Context) error {
 	return err
 }
 
+const restoreUser = `-- name: RestoreUser :execrows
+UPDATE users
+SET
+    updated_at = now(),
+    deleted_at = NULL
+WHERE
+    id = $1
+    AND deleted_at IS NOT NULL
+`
+
+func (q *Queries) RestoreUser(ctx context.Context, id uuid.UUID) (int64, error) {
+	result, err := q.db.ExecContext(ctx, restoreUser, id)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
 const selectUserByEmail = `-- name: SelectUserByEmail :one
-SELECT id, created_at, updated_at, email, hashed_password, is_chirpy_red FROM users WHERE email = $1
+SELECT id, created_at, updated_at, email, hashed_password, is_chirpy_red, totp_secret, totp_enabled_at, totp_last_step, email_verified_at, role, token_version, deleted_at FROM users WHERE email = $1
 `
 
 func (q *Queries) SelectUserByEmail(ctx context.Context, email string) (User, error) {
@@ -83,11 +189,54 @@ func (q *Queries) SelectUserByEmail(ctx context.Context, email string) (User, er
 		&i.Email,
 		&i.HashedPassword,
 		&i.IsChirpyRed,
+		&i.TotpSecret,
+		&i.TotpEnabledAt,
+		&i.TotpLastStep,
+		&i.EmailVerifiedAt,
+		&i.Role,
+		&i.TokenVersion,
+		&i.DeletedAt,
+	)
+	return i, err
+}
+
+const selectUserByID = `-- name: SelectUserByID :one
+SELECT id, created_at, updated_at, email, hashed_password, is_chirpy_red, totp_secret, totp_enabled_at, totp_last_step, email_verified_at, role, token_version, deleted_at FROM users WHERE id = $1
+`
+
+func (q *Queries) SelectUserByID(ctx context.Context, id uuid.UUID) (User, error) {
+	row := q.db.QueryRowContext(ctx, selectUserByID, id)
+	var i User
+	err := row.Scan(
+		&i.ID,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+		&i.Email,
+		&i.HashedPassword,
+		&i.IsChirpyRed,
+		&i.TotpSecret,
+		&i.TotpEnabledAt,
+		&i.TotpLastStep,
+		&i.EmailVerifiedAt,
+		&i.Role,
+		&i.TokenVersion,
+		&i.DeletedAt,
 	)
 	return i, err
 }
 
-const setUserIsChirpyRed = `-- name: SetUserIsChirpyRed :exec
+const selectUserTokenVersion = `-- name: SelectUserTokenVersion :one
+SELECT token_version FROM users WHERE id = $1
+`
+
+func (q *Queries) SelectUserTokenVersion(ctx context.Context, id uuid.UUID) (int32, error) {
+	row := q.db.QueryRowContext(ctx, selectUserTokenVersion, id)
+	var token_version int32
+	err := row.Scan(&token_version)
+	return token_version, err
+}
+
+const setUserIsChirpyRed = `-- name: SetUserIsChirpyRed :execrows
 UPDATE users SET is_chirpy_red = $2 WHERE id = $1
 `
 
@@ -96,15 +245,62 @@ type SetUserIsChirpyRedParams struct {
 	IsChirpyRed bool
 }
 
-func (q *Queries) SetUserIsChirpyRed(ctx context.Context, arg SetUserIsChirpyRedParams) error {
-	_, err := q.db.ExecContext(ctx, setUserIsChirpyRed, arg.ID, arg.IsChirpyRed)
-	return err
+func (q *Queries) SetUserIsChirpyRed(ctx context.Context, arg SetUserIsChirpyRedParams) (int64, error) {
+	result, err := q.db.ExecContext(ctx, setUserIsChirpyRed, arg.ID, arg.IsChirpyRed)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+const setUserRole = `-- name: SetUserRole :execrows
+UPDATE users SET updated_at = now(), role = $2 WHERE id = $1
+`
+
+type SetUserRoleParams struct {
+	ID   uuid.UUID
+	Role string
+}
+
+func (q *Queries) SetUserRole(ctx context.Context, arg SetUserRoleParams) (int64, error) {
+	result, err := q.db.ExecContext(ctx, setUserRole, arg.ID, arg.Role)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+const setUserTOTPSecret = `-- name: SetUserTOTPSecret :execrows
+UPDATE users
+SET
+    updated_at = now(),
+    totp_secret = $2
+WHERE
+    id = $1
+    AND totp_enabled_at IS NULL
+`
+
+type SetUserTOTPSecretParams struct {
+	ID         uuid.UUID
+	TotpSecret sql.NullString
+}
+
+func (q *Queries) SetUserTOTPSecret(ctx context.Context, arg SetUserTOTPSecretParams) (int64, error) {
+	result, err := q.db.ExecContext(ctx, setUserTOTPSecret, arg.ID, arg.TotpSecret)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
 }
 
 const updateUser = `-- name: UpdateUser :exec
 UPDATE users
 SET
     updated_at = now(),
+    email_verified_at = CASE
+        WHEN email = $2 THEN email_verified_at
+        ELSE NULL
+    END,
     email = $2,
     hashed_password = $3
 WHERE
@@ -121,3 +317,70 @@ func (q *Queries) UpdateUser(ctx context.Context, arg UpdateUserParams) error {
 	_, err := q.db.ExecContext(ctx, updateUser, arg.ID, arg.Email, arg.HashedPassword)
 	return err
 }
+
+const updateUserPassword = `-- name: UpdateUserPassword :exec
+UPDATE users
+SET
+    updated_at = now(),
+    hashed_password = $2
+WHERE
+    id = $1
+`
+
+type UpdateUserPasswordParams struct {
+	ID             uuid.UUID
+	HashedPassword sql.NullString
+}
+
+func (q *Queries) UpdateUserPassword(ctx context.Context, arg UpdateUserPasswordParams) error {
+	_, err := q.db.ExecContext(ctx, updateUserPassword, arg.ID, arg.HashedPassword)
+	return err
+}
+
+const updateUserTOTPLastStep = `-- name: UpdateUserTOTPLastStep :execrows
+UPDATE users
+SET
+    totp_last_step = $2
+WHERE
+    id = $1
+    AND (
+        totp_last_step IS NULL
+        OR totp_last_step < $2
+    )
+`
+
+type UpdateUserTOTPLastStepParams struct {
+	ID           uuid.UUID
+	TotpLastStep sql.NullInt64
+}
+
+func (q *Queries) UpdateUserTOTPLastStep(ctx context.Context, arg UpdateUserTOTPLastStepParams) (int64, error) {
+	result, err := q.db.ExecContext(ctx, updateUserTOTPLastStep, arg.ID, arg.TotpLastStep)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+const verifyUserEmail = `-- name: VerifyUserEmail :execrows
+UPDATE users
+SET
+    updated_at = now(),
+    email_verified_at = now()
+WHERE
+    id = $1
+    AND email = $2
+`
+
+type VerifyUserEmailParams struct {
+	ID    uuid.UUID
+	Email string
+}
+
+func (q *Queries) VerifyUserEmail(ctx context.Context, arg VerifyUserEmailParams) (int64, error) {
+	result, err := q.db.ExecContext(ctx, verifyUserEmail, arg.ID, arg.Email)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
@@ -0,0 +1,210 @@
+package lockout
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/oleshko-g/chirpy/internal/database"
+)
+
+// Attempt is the state of the failed attempts of a key: an email or a client IP.
+type Attempt struct {
+	Failures      int
+	LastFailureAt time.Time
+	BlockedUntil  time.Time
+}
+
+// Store keeps the attempts. MemoryStore serves a single server instance,
+// PostgresStore shares the attempts between the instances behind a load balancer.
+type Store interface {
+	// Get returns the zero Attempt for the keys without failures.
+	Get(ctx context.Context, key string) (Attempt, error)
+	// RecordFailure counts the failure and returns the number of failures.
+	// The count starts over if the last failure happened before resetBefore.
+	RecordFailure(ctx context.Context, key string, failedAt, resetBefore time.Time) (int, error)
+	SetBlockedUntil(ctx context.Context, key string, blockedUntil time.Time) error
+	Delete(ctx context.Context, key string) error
+}
+
+// Policy sets the delays between the attempts after the failures.
+type Policy struct {
+	// FreeFailures is the number of failures without the delay
+	FreeFailures int
+	// BaseDelay doubles with every failure after the free ones up to MaxDelay
+	BaseDelay time.Duration
+	MaxDelay  time.Duration
+	// LockoutFailures is the number of failures that locks the key out for LockoutDuration
+	LockoutFailures int
+	LockoutDuration time.Duration
+	// ResetAfter is the period without failures that forgets the previous ones
+	ResetAfter time.Duration
+}
+
+// Delay returns how long the key is blocked after the failures.
+func (p Policy) Delay(failures int) time.Duration {
+	if p.LockoutFailures > 0 && failures >= p.LockoutFailures {
+		return p.LockoutDuration
+	}
+	if failures <= p.FreeFailures {
+		return 0
+	}
+
+	delay := p.BaseDelay
+	for i := p.FreeFailures + 1; i < failures; i++ {
+		delay *= 2
+		if delay >= p.MaxDelay {
+			return p.MaxDelay
+		}
+	}
+	return delay
+}
+
+type Limiter struct {
+	store  Store
+	policy Policy
+	now    func() time.Time
+}
+
+func NewLimiter(store Store, policy Policy) *Limiter {
+	return &Limiter{store: store, policy: policy, now: time.Now}
+}
+
+// RetryAfter returns how long the key is blocked. Zero means the attempt is allowed.
+func (l *Limiter) RetryAfter(ctx context.Context, key string) (time.Duration, error) {
+	attempt, err := l.store.Get(ctx, key)
+	if err != nil {
+		return 0, err
+	}
+
+	retryAfter := attempt.BlockedUntil.Sub(l.now())
+	if retryAfter < 0 {
+		return 0, nil
+	}
+	return retryAfter, nil
+}
+
+// Fail records the failed attempt and returns how long the key is blocked after it.
+func (l *Limiter) Fail(ctx context.Context, key string) (time.Duration, error) {
+	now := l.now().UTC()
+	failures, err := l.store.RecordFailure(ctx, key, now, now.Add(-l.policy.ResetAfter))
+	if err != nil {
+		return 0, err
+	}
+
+	delay := l.policy.Delay(failures)
+	if delay == 0 {
+		return 0, nil
+	}
+	return delay, l.store.SetBlockedUntil(ctx, key, now.Add(delay))
+}
+
+// Reset forgets the failures of the key. It unlocks the locked out key.
+func (l *Limiter) Reset(ctx context.Context, key string) error {
+	return l.store.Delete(ctx, key)
+}
+
+type MemoryStore struct {
+	mu       sync.Mutex
+	attempts map[string]Attempt
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{attempts: make(map[string]Attempt)}
+}
+
+func (s *MemoryStore) Get(_ context.Context, key string) (Attempt, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.attempts[key], nil
+}
+
+func (s *MemoryStore) RecordFailure(_ context.Context, key string, failedAt, resetBefore time.Time) (int, error) {
+	const pruneSize = 10000
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if len(s.attempts) >= pruneSize {
+		for k, attempt := range s.attempts {
+			if attempt.LastFailureAt.Before(resetBefore) && attempt.BlockedUntil.Before(failedAt) {
+				delete(s.attempts, k)
+			}
+		}
+	}
+
+	attempt := s.attempts[key]
+	if attempt.LastFailureAt.Before(resetBefore) {
+		attempt.Failures = 0
+	}
+	attempt.Failures++
+	attempt.LastFailureAt = failedAt
+	s.attempts[key] = attempt
+	return attempt.Failures, nil
+}
+
+func (s *MemoryStore) SetBlockedUntil(_ context.Context, key string, blockedUntil time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	attempt, ok := s.attempts[key]
+	if !ok {
+		return nil
+	}
+	attempt.BlockedUntil = blockedUntil
+	s.attempts[key] = attempt
+	return nil
+}
+
+func (s *MemoryStore) Delete(_ context.Context, key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.attempts, key)
+	return nil
+}
+
+type PostgresStore struct {
+	queries *database.Queries
+}
+
+func NewPostgresStore(queries *database.Queries) *PostgresStore {
+	return &PostgresStore{queries: queries}
+}
+
+func (s *PostgresStore) Get(ctx context.Context, key string) (Attempt, error) {
+	selectedAttempt, err := s.queries.SelectLoginAttempt(ctx, key)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return Attempt{}, nil
+		}
+		return Attempt{}, err
+	}
+
+	return Attempt{
+		Failures:      int(selectedAttempt.Failures),
+		LastFailureAt: selectedAttempt.LastFailureAt,
+		BlockedUntil:  selectedAttempt.BlockedUntil.Time,
+	}, nil
+}
+
+func (s *PostgresStore) RecordFailure(ctx context.Context, key string, failedAt, resetBefore time.Time) (int, error) {
+	failures, err := s.queries.RecordLoginFailure(ctx, database.RecordLoginFailureParams{
+		Key:         key,
+		FailedAt:    failedAt,
+		ResetBefore: resetBefore,
+	})
+	return int(failures), err
+}
+
+func (s *PostgresStore) SetBlockedUntil(ctx context.Context, key string, blockedUntil time.Time) error {
+	return s.queries.SetLoginAttemptBlockedUntil(ctx, database.SetLoginAttemptBlockedUntilParams{
+		Key:          key,
+		BlockedUntil: sql.NullTime{Time: blockedUntil, Valid: true},
+	})
+}
+
+func (s *PostgresStore) Delete(ctx context.Context, key string) error {
+	return s.queries.DeleteLoginAttempt(ctx, key)
+}
@@ -0,0 +1,88 @@
+package lockout
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var testPolicy = Policy{
+	FreeFailures:    3,
+	BaseDelay:       time.Second,
+	MaxDelay:        8 * time.Second,
+	LockoutFailures: 10,
+	LockoutDuration: 15 * time.Minute,
+	ResetAfter:      time.Hour,
+}
+
+func TestPolicyDelay(t *testing.T) {
+	expectedDelays := map[int]time.Duration{
+		1:  0,
+		3:  0,
+		4:  time.Second,
+		5:  2 * time.Second,
+		6:  4 * time.Second,
+		7:  8 * time.Second,
+		9:  8 * time.Second,
+		10: 15 * time.Minute,
+		20: 15 * time.Minute,
+	}
+	for failures, expectedDelay := range expectedDelays {
+		assert.Equal(t, expectedDelay, testPolicy.Delay(failures), "failures: %d", failures)
+	}
+}
+
+func TestLimiter(t *testing.T) {
+	ctx := context.Background()
+	now := time.Date(2025, 7, 10, 0, 0, 0, 0, time.UTC)
+	l := NewLimiter(NewMemoryStore(), testPolicy)
+	l.now = func() time.Time { return now }
+
+	// Test: the free failures don't block
+	for range testPolicy.FreeFailures {
+		delay, err := l.Fail(ctx, "email:saul@bettercall.com")
+		assert.NoError(t, err)
+		assert.Zero(t, delay)
+	}
+	retryAfter, err := l.RetryAfter(ctx, "email:saul@bettercall.com")
+	assert.NoError(t, err)
+	assert.Zero(t, retryAfter)
+
+	// Test: the next failure blocks
+	delay, err := l.Fail(ctx, "email:saul@bettercall.com")
+	assert.NoError(t, err)
+	assert.Equal(t, time.Second, delay)
+	retryAfter, err = l.RetryAfter(ctx, "email:saul@bettercall.com")
+	assert.NoError(t, err)
+	assert.Equal(t, time.Second, retryAfter)
+
+	// Test: the other keys aren't blocked
+	retryAfter, err = l.RetryAfter(ctx, "email:lane@example.com")
+	assert.NoError(t, err)
+	assert.Zero(t, retryAfter)
+
+	// Test: the block passes
+	now = now.Add(2 * time.Second)
+	retryAfter, err = l.RetryAfter(ctx, "email:saul@bettercall.com")
+	assert.NoError(t, err)
+	assert.Zero(t, retryAfter)
+
+	// Test: the failures are forgotten after ResetAfter
+	now = now.Add(testPolicy.ResetAfter + time.Second)
+	delay, err = l.Fail(ctx, "email:saul@bettercall.com")
+	assert.NoError(t, err)
+	assert.Zero(t, delay)
+
+	// Test: the lockout and the unlock
+	for range testPolicy.LockoutFailures {
+		delay, err = l.Fail(ctx, "ip:203.0.113.7")
+		assert.NoError(t, err)
+	}
+	assert.Equal(t, testPolicy.LockoutDuration, delay)
+	assert.NoError(t, l.Reset(ctx, "ip:203.0.113.7"))
+	retryAfter, err = l.RetryAfter(ctx, "ip:203.0.113.7")
+	assert.NoError(t, err)
+	assert.Zero(t, retryAfter)
+}
@@ -0,0 +1,55 @@
+package mailer
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMessageFormat(t *testing.T) {
+	message := Message{
+		To:      "saul@bettercall.com",
+		Subject: "Reset your Chirpy password",
+		Body:    "Hello,\nreset it.",
+	}
+
+	data, err := message.Format("chirpy@example.com", time.Date(2025, 7, 10, 0, 0, 0, 0, time.UTC))
+	assert.NoError(t, err)
+	assert.Contains(t, string(data), "To: saul@bettercall.com\r\n")
+	assert.Contains(t, string(data), "Subject: Reset your Chirpy password\r\n")
+	assert.Contains(t, string(data), "\r\n\r\nHello,\r\nreset it.")
+
+	// Test: header injection
+	message.Subject = "Hi\r\nBcc: lane@example.com"
+	_, err = message.Format("chirpy@example.com", time.Now())
+	assert.Error(t, err)
+}
+
+func TestFileMailer(t *testing.T) {
+	dir := t.TempDir()
+	m := &FileMailer{Dir: dir, From: "chirpy@example.com"}
+
+	assert.NoError(t, m.Send(context.Background(), Message{To: "saul@bettercall.com", Subject: "1", Body: "first"}))
+	assert.NoError(t, m.Send(context.Background(), Message{To: "saul@bettercall.com", Subject: "2", Body: "second"}))
+
+	paths, err := filepath.Glob(filepath.Join(dir, "*.eml"))
+	assert.NoError(t, err)
+	assert.Len(t, paths, 2)
+	data, err := os.ReadFile(paths[0])
+	assert.NoError(t, err)
+	assert.Contains(t, string(data), "From: chirpy@example.com\r\n")
+}
+
+func TestLogMailer(t *testing.T) {
+	var b bytes.Buffer
+	m := &LogMailer{W: &b, From: "chirpy@example.com"}
+
+	assert.NoError(t, m.Send(context.Background(), Message{To: "saul@bettercall.com", Subject: "Hi", Body: "Hello"}))
+	assert.Contains(t, b.String(), "To: saul@bettercall.com\r\n")
+	assert.Contains(t, b.String(), "Hello")
+}
@@ -0,0 +1,271 @@
+package oidc
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/stretchr/testify/assert"
+)
+
+const (
+	testClientID     = "chirpy"
+	testClientSecret = "chirpy-secret"
+	testRedirectURL  = "https://chirpy.example.com/login/oidc/callback"
+)
+
+// fakeIdP is the stand-in OpenID Connect provider: discovery, the token endpoint with PKCE and the JWKS.
+type fakeIdP struct {
+	server *httptest.Server
+	keyID  string
+	key    *rsa.PrivateKey
+
+	mu    sync.Mutex
+	codes map[string]fakeAuthorization
+}
+
+type fakeAuthorization struct {
+	nonce         string
+	codeChallenge string
+	subject       string
+	email         string
+}
+
+func newFakeIdP(t *testing.T) *fakeIdP {
+	idp := &fakeIdP{codes: map[string]fakeAuthorization{}}
+	idp.rotateKey(t)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(Metadata{
+			Issuer:                idp.server.URL,
+			AuthorizationEndpoint: idp.server.URL + "/authorize",
+			TokenEndpoint:         idp.server.URL + "/token",
+			JWKSURI:               idp.server.URL + "/jwks",
+		})
+	})
+	mux.HandleFunc("GET /jwks", func(w http.ResponseWriter, r *http.Request) {
+		idp.mu.Lock()
+		defer idp.mu.Unlock()
+		json.NewEncoder(w).Encode(map[string]any{
+			"keys": []jwk{{
+				KeyType: "RSA",
+				KeyID:   idp.keyID,
+				Use:     "sig",
+				N:       base64.RawURLEncoding.EncodeToString(idp.key.N.Bytes()),
+				E:       base64.RawURLEncoding.EncodeToString(big.NewInt(int64(idp.key.E)).Bytes()),
+			}},
+		})
+	})
+	mux.HandleFunc("POST /token", idp.token)
+	idp.server = httptest.NewServer(mux)
+	t.Cleanup(idp.server.Close)
+	return idp
+}
+
+func (idp *fakeIdP) rotateKey(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		t.FailNow()
+	}
+	idp.mu.Lock()
+	defer idp.mu.Unlock()
+	idp.key = key
+	idp.keyID = fmt.Sprintf("key-%d", time.Now().UnixNano())
+}
+
+// authorize stands in for the user logging in at the authorization URL and returns the code.
+func (idp *fakeIdP) authorize(t *testing.T, authURL, subject, email string) string {
+	parsedURL, err := url.Parse(authURL)
+	assert.NoError(t, err)
+	query := parsedURL.Query()
+	assert.Equal(t, testClientID, query.Get("client_id"))
+	assert.Equal(t, testRedirectURL, query.Get("redirect_uri"))
+	assert.Equal(t, "code", query.Get("response_type"))
+	assert.Equal(t, "S256", query.Get("code_challenge_method"))
+	assert.Equal(t, "openid email profile", query.Get("scope"))
+
+	code := fmt.Sprintf("code-%d", time.Now().UnixNano())
+	idp.mu.Lock()
+	defer idp.mu.Unlock()
+	idp.codes[code] = fakeAuthorization{
+		nonce:         query.Get("nonce"),
+		codeChallenge: query.Get("code_challenge"),
+		subject:       subject,
+		email:         email,
+	}
+	return code
+}
+
+func (idp *fakeIdP) token(w http.ResponseWriter, r *http.Request) {
+	clientID, clientSecret, ok := r.BasicAuth()
+	if !ok || clientID != testClientID || clientSecret != testClientSecret {
+		w.WriteHeader(http.StatusUnauthorized)
+		json.NewEncoder(w).Encode(map[string]string{"error": "invalid_client"})
+		return
+	}
+
+	idp.mu.Lock()
+	authorization, ok := idp.codes[r.PostFormValue("code")]
+	delete(idp.codes, r.PostFormValue("code"))
+	idp.mu.Unlock()
+
+	sum := sha256.Sum256([]byte(r.PostFormValue("code_verifier")))
+	if !ok || r.PostFormValue("redirect_uri") != testRedirectURL ||
+		base64.RawURLEncoding.EncodeToString(sum[:]) != authorization.codeChallenge {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{"error": "invalid_grant"})
+		return
+	}
+
+	claims := IDTokenClaims{
+		RegisteredClaims: jwt.RegisteredClaims{
+			Issuer:    idp.server.URL,
+			Subject:   authorization.subject,
+			Audience:  jwt.ClaimStrings{testClientID},
+			IssuedAt:  jwt.NewNumericDate(time.Now()),
+			ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Minute)),
+		},
+		Nonce:         authorization.nonce,
+		Email:         authorization.email,
+		EmailVerified: true,
+	}
+	json.NewEncoder(w).Encode(map[string]string{
+		"access_token": "opaque",
+		"token_type":   "Bearer",
+		"id_token":     idp.sign(claims),
+	})
+}
+
+func (idp *fakeIdP) sign(claims IDTokenClaims) string {
+	idp.mu.Lock()
+	defer idp.mu.Unlock()
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+	token.Header["kid"] = idp.keyID
+	signed, _ := token.SignedString(idp.key)
+	return signed
+}
+
+func newTestProvider(idp *fakeIdP) *Provider {
+	return NewProvider(Config{
+		Issuer:       idp.server.URL,
+		ClientID:     testClientID,
+		ClientSecret: testClientSecret,
+		RedirectURL:  testRedirectURL,
+	}, idp.server.Client())
+}
+
+func pkceChallenge(codeVerifier string) string {
+	sum := sha256.Sum256([]byte(codeVerifier))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+func TestProviderLogin(t *testing.T) {
+	idp := newFakeIdP(t)
+	p := newTestProvider(idp)
+	ctx := context.Background()
+	codeVerifier := "dBjftJeZ4CVP-mB92K27uhbUJU1p1r_wW1gFWFOEjXk"
+
+	authURL, err := p.AuthCodeURL(ctx, "state", "nonce", pkceChallenge(codeVerifier))
+	assert.NoError(t, err)
+
+	// Test: the code exchanges for the verified ID token
+	code := idp.authorize(t, authURL, "staff-42", "saul@bettercall.com")
+	claims, err := p.Exchange(ctx, code, codeVerifier, "nonce")
+	assert.NoError(t, err)
+	assert.Equal(t, "staff-42", claims.Subject)
+	assert.Equal(t, "saul@bettercall.com", claims.Email)
+	assert.True(t, claims.EmailVerified)
+
+	// Test: the code is used once
+	_, err = p.Exchange(ctx, code, codeVerifier, "nonce")
+	assert.Error(t, err)
+
+	// Test: a wrong code verifier is rejected by the provider
+	code = idp.authorize(t, authURL, "staff-42", "saul@bettercall.com")
+	_, err = p.Exchange(ctx, code, codeVerifier+"x", "nonce")
+	assert.Error(t, err)
+
+	// Test: the ID token of another login's nonce is rejected
+	code = idp.authorize(t, authURL, "staff-42", "saul@bettercall.com")
+	_, err = p.Exchange(ctx, code, codeVerifier, "another nonce")
+	assert.Error(t, err)
+}
+
+func TestVerifyIDToken(t *testing.T) {
+	idp := newFakeIdP(t)
+	p := newTestProvider(idp)
+	ctx := context.Background()
+	validClaims := func() IDTokenClaims {
+		return IDTokenClaims{
+			RegisteredClaims: jwt.RegisteredClaims{
+				Issuer:    idp.server.URL,
+				Subject:   "staff-42",
+				Audience:  jwt.ClaimStrings{testClientID},
+				ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Minute)),
+			},
+			Nonce: "nonce",
+		}
+	}
+
+	_, err := p.VerifyIDToken(ctx, idp.sign(validClaims()), "nonce")
+	assert.NoError(t, err)
+
+	// Test: the ID token for another client
+	claims := validClaims()
+	claims.Audience = jwt.ClaimStrings{"another-client"}
+	_, err = p.VerifyIDToken(ctx, idp.sign(claims), "nonce")
+	assert.Error(t, err)
+
+	// Test: the ID token of another issuer
+	claims = validClaims()
+	claims.Issuer = "https://evil.example.com"
+	_, err = p.VerifyIDToken(ctx, idp.sign(claims), "nonce")
+	assert.Error(t, err)
+
+	// Test: the expired ID token
+	claims = validClaims()
+	claims.ExpiresAt = jwt.NewNumericDate(time.Now().Add(-time.Hour))
+	_, err = p.VerifyIDToken(ctx, idp.sign(claims), "nonce")
+	assert.Error(t, err)
+
+	// Test: the ID token signed with an unpublished key
+	forgedKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+	forgedToken := jwt.NewWithClaims(jwt.SigningMethodRS256, validClaims())
+	forgedToken.Header["kid"] = idp.keyID
+	forged, _ := forgedToken.SignedString(forgedKey)
+	_, err = p.VerifyIDToken(ctx, forged, "nonce")
+	assert.Error(t, err)
+
+	// Test: the keys are refetched when the provider rotates its key
+	idp.rotateKey(t)
+	p.keysFetchedAt = time.Now().Add(-keysRefreshInterval)
+	_, err = p.VerifyIDToken(ctx, idp.sign(validClaims()), "nonce")
+	assert.NoError(t, err)
+}
+
+func TestProviderDiscoveryIssuerMismatch(t *testing.T) {
+	idp := newFakeIdP(t)
+	p := NewProvider(Config{
+		Issuer:      idp.server.URL + "/",
+		ClientID:    testClientID,
+		RedirectURL: testRedirectURL,
+	}, idp.server.Client())
+
+	_, err := p.Metadata(context.Background())
+	assert.Error(t, err)
+}
@@ -0,0 +1,54 @@
+package pagination
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"strconv"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+var ErrInvalidCursor = errors.New("error invalid pagination cursor")
+
+var ErrInvalidLimit = errors.New("error invalid pagination limit")
+
+// Cursor is the position in a list sorted by the creation time and the id.
+// The id breaks the ties between the items created at the same time.
+type Cursor struct {
+	CreatedAt time.Time `json:"created_at"`
+	ID        uuid.UUID `json:"id"`
+}
+
+// Encode returns the opaque URL-safe form of the cursor.
+// The clients pass it back as is and don't depend on what's inside.
+func (c Cursor) Encode() string {
+	data, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+// DecodeCursor parses the cursor returned by Encode.
+func DecodeCursor(encoded string) (Cursor, error) {
+	data, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil {
+		return Cursor{}, ErrInvalidCursor
+	}
+	var c Cursor
+	if err := json.Unmarshal(data, &c); err != nil || c.CreatedAt.IsZero() || c.ID == uuid.Nil {
+		return Cursor{}, ErrInvalidCursor
+	}
+	return c, nil
+}
+
+// ParseLimit parses the page size. The empty one is defaultLimit. It must be from 1 to maxLimit.
+func ParseLimit(limit string, defaultLimit, maxLimit int) (int, error) {
+	if limit == "" {
+		return defaultLimit, nil
+	}
+	parsedLimit, err := strconv.Atoi(limit)
+	if err != nil || parsedLimit < 1 || parsedLimit > maxLimit {
+		return 0, ErrInvalidLimit
+	}
+	return parsedLimit, nil
+}
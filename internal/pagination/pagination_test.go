@@ -0,0 +1,43 @@
+package pagination
+
+import (
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCursor(t *testing.T) {
+	cursor := Cursor{
+		CreatedAt: time.Date(2026, 10, 17, 12, 30, 0, 123456000, time.UTC),
+		ID:        uuid.New(),
+	}
+
+	// Test: the cursor survives the round trip with the microseconds of the database
+	decoded, err := DecodeCursor(cursor.Encode())
+	assert.NoError(t, err)
+	assert.True(t, cursor.CreatedAt.Equal(decoded.CreatedAt))
+	assert.Equal(t, cursor.ID, decoded.ID)
+
+	// Test: the tampered cursors are rejected
+	for _, encoded := range []string{"", "not a cursor", Cursor{}.Encode(), cursor.Encode()[1:]} {
+		_, err = DecodeCursor(encoded)
+		assert.ErrorIs(t, err, ErrInvalidCursor, encoded)
+	}
+}
+
+func TestParseLimit(t *testing.T) {
+	limit, err := ParseLimit("", 20, 100)
+	assert.NoError(t, err)
+	assert.Equal(t, 20, limit)
+
+	limit, err = ParseLimit("100", 20, 100)
+	assert.NoError(t, err)
+	assert.Equal(t, 100, limit)
+
+	for _, invalidLimit := range []string{"0", "-1", "101", "ten"} {
+		_, err = ParseLimit(invalidLimit, 20, 100)
+		assert.ErrorIs(t, err, ErrInvalidLimit, invalidLimit)
+	}
+}
@@ -0,0 +1,216 @@
+package revocation
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/oleshko-g/chirpy/internal/database"
+)
+
+// Store keeps the revocation state of the access JWTs: the users' token versions, their sessions
+// and the JWTs revoked one by one.
+type Store interface {
+	// UserTokenVersion returns the version the user's access JWTs must carry.
+	UserTokenVersion(ctx context.Context, userID uuid.UUID) (int32, error)
+	// SessionActive reports whether the session still has a refresh token that isn't revoked or expired.
+	SessionActive(ctx context.Context, sessionID uuid.UUID) (bool, error)
+	// TokenRevoked reports whether the access JWT with the JWT ID has been revoked.
+	TokenRevoked(ctx context.Context, tokenID string) (bool, error)
+}
+
+var ErrRevoked = errors.New("error access token has been revoked")
+
+// Checker checks the access JWTs against the Store and caches the results for TTL
+// so the requests don't hit the database every time.
+// The revocations through the same Checker take effect immediately: call Forget after them.
+// The revocations through the other server instances take effect within TTL.
+type Checker struct {
+	store Store
+	ttl   time.Duration
+	now   func() time.Time
+
+	mu    sync.Mutex
+	users map[uuid.UUID]*cachedUser
+}
+
+type cachedUser struct {
+	tokenVersion int32
+	fetchedAt    time.Time
+	sessions     map[uuid.UUID]cachedSession
+	tokens       map[string]cachedToken
+}
+
+type cachedSession struct {
+	active    bool
+	fetchedAt time.Time
+}
+
+type cachedToken struct {
+	revoked   bool
+	fetchedAt time.Time
+}
+
+func NewChecker(store Store, ttl time.Duration) *Checker {
+	return &Checker{
+		store: store,
+		ttl:   ttl,
+		now:   time.Now,
+		users: make(map[uuid.UUID]*cachedUser),
+	}
+}
+
+// Check returns ErrRevoked if the user's token version has moved past the JWT's one,
+// the JWT's session has ended or the JWT itself has been revoked.
+// uuid.Nil is for the JWTs without a session and "" for the JWTs without a JWT ID.
+func (c *Checker) Check(ctx context.Context, userID uuid.UUID, tokenVersion int32, sessionID uuid.UUID, tokenID string) error {
+	userTokenVersion, err := c.userTokenVersion(ctx, userID)
+	if err != nil {
+		return err
+	}
+	if tokenVersion != userTokenVersion {
+		return ErrRevoked
+	}
+
+	if sessionID != uuid.Nil {
+		active, err := c.sessionActive(ctx, userID, sessionID)
+		if err != nil {
+			return err
+		}
+		if !active {
+			return ErrRevoked
+		}
+	}
+
+	if tokenID != "" {
+		revoked, err := c.tokenRevoked(ctx, userID, tokenID)
+		if err != nil {
+			return err
+		}
+		if revoked {
+			return ErrRevoked
+		}
+	}
+	return nil
+}
+
+// Forget drops the cached state of the user and the user's sessions. Call it after revoking them.
+func (c *Checker) Forget(userID uuid.UUID) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.users, userID)
+}
+
+func (c *Checker) userTokenVersion(ctx context.Context, userID uuid.UUID) (int32, error) {
+	now := c.now()
+	c.mu.Lock()
+	user, ok := c.users[userID]
+	if ok && now.Sub(user.fetchedAt) < c.ttl {
+		tokenVersion := user.tokenVersion
+		c.mu.Unlock()
+		return tokenVersion, nil
+	}
+	c.mu.Unlock()
+
+	tokenVersion, err := c.store.UserTokenVersion(ctx, userID)
+	if err != nil {
+		return 0, err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.prune(now)
+	c.users[userID] = &cachedUser{
+		tokenVersion: tokenVersion,
+		fetchedAt:    now,
+		sessions:     make(map[uuid.UUID]cachedSession),
+		tokens:       make(map[string]cachedToken),
+	}
+	return tokenVersion, nil
+}
+
+func (c *Checker) sessionActive(ctx context.Context, userID, sessionID uuid.UUID) (bool, error) {
+	now := c.now()
+	c.mu.Lock()
+	if user, ok := c.users[userID]; ok {
+		if session, ok := user.sessions[sessionID]; ok && now.Sub(session.fetchedAt) < c.ttl {
+			c.mu.Unlock()
+			return session.active, nil
+		}
+	}
+	c.mu.Unlock()
+
+	active, err := c.store.SessionActive(ctx, sessionID)
+	if err != nil {
+		return false, err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	// the user is forgotten while the session is fetched if it has just been revoked
+	if user, ok := c.users[userID]; ok {
+		user.sessions[sessionID] = cachedSession{active: active, fetchedAt: now}
+	}
+	return active, nil
+}
+
+func (c *Checker) tokenRevoked(ctx context.Context, userID uuid.UUID, tokenID string) (bool, error) {
+	now := c.now()
+	c.mu.Lock()
+	if user, ok := c.users[userID]; ok {
+		if token, ok := user.tokens[tokenID]; ok && now.Sub(token.fetchedAt) < c.ttl {
+			c.mu.Unlock()
+			return token.revoked, nil
+		}
+	}
+	c.mu.Unlock()
+
+	revoked, err := c.store.TokenRevoked(ctx, tokenID)
+	if err != nil {
+		return false, err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	// the user is forgotten while the token is fetched if it has just been revoked
+	if user, ok := c.users[userID]; ok {
+		user.tokens[tokenID] = cachedToken{revoked: revoked, fetchedAt: now}
+	}
+	return revoked, nil
+}
+
+// prune drops the expired users when the cache grows. It must be called with c.mu locked.
+func (c *Checker) prune(now time.Time) {
+	const pruneSize = 10000
+
+	if len(c.users) < pruneSize {
+		return
+	}
+	for userID, user := range c.users {
+		if now.Sub(user.fetchedAt) >= c.ttl {
+			delete(c.users, userID)
+		}
+	}
+}
+
+type PostgresStore struct {
+	queries *database.Queries
+}
+
+func NewPostgresStore(queries *database.Queries) *PostgresStore {
+	return &PostgresStore{queries: queries}
+}
+
+func (s *PostgresStore) UserTokenVersion(ctx context.Context, userID uuid.UUID) (int32, error) {
+	return s.queries.SelectUserTokenVersion(ctx, userID)
+}
+
+func (s *PostgresStore) SessionActive(ctx context.Context, sessionID uuid.UUID) (bool, error) {
+	return s.queries.SelectSessionActive(ctx, sessionID)
+}
+
+func (s *PostgresStore) TokenRevoked(ctx context.Context, tokenID string) (bool, error) {
+	return s.queries.SelectAccessTokenRevoked(ctx, tokenID)
+}
@@ -0,0 +1,97 @@
+package revocation
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+)
+
+type fakeStore struct {
+	tokenVersions  map[uuid.UUID]int32
+	activeSessions map[uuid.UUID]bool
+	revokedTokens  map[string]bool
+	queries        int
+}
+
+func (s *fakeStore) UserTokenVersion(_ context.Context, userID uuid.UUID) (int32, error) {
+	s.queries++
+	return s.tokenVersions[userID], nil
+}
+
+func (s *fakeStore) SessionActive(_ context.Context, sessionID uuid.UUID) (bool, error) {
+	s.queries++
+	return s.activeSessions[sessionID], nil
+}
+
+func (s *fakeStore) TokenRevoked(_ context.Context, tokenID string) (bool, error) {
+	s.queries++
+	return s.revokedTokens[tokenID], nil
+}
+
+func TestChecker(t *testing.T) {
+	ctx := context.Background()
+	userID := uuid.New()
+	sessionID := uuid.New()
+	store := &fakeStore{
+		tokenVersions:  map[uuid.UUID]int32{userID: 1},
+		activeSessions: map[uuid.UUID]bool{sessionID: true},
+	}
+	now := time.Unix(1_750_000_000, 0)
+	checker := NewChecker(store, 10*time.Second)
+	checker.now = func() time.Time { return now }
+
+	// Test: the JWT of the current version and an active session
+	assert.NoError(t, checker.Check(ctx, userID, 1, sessionID, ""))
+	assert.Equal(t, 2, store.queries)
+
+	// Test: the state is cached
+	assert.NoError(t, checker.Check(ctx, userID, 1, sessionID, ""))
+	assert.Equal(t, 2, store.queries)
+
+	// Test: the JWT of the previous version
+	assert.ErrorIs(t, checker.Check(ctx, userID, 0, sessionID, ""), ErrRevoked)
+
+	// Test: the JWT without a session
+	assert.NoError(t, checker.Check(ctx, userID, 1, uuid.Nil, ""))
+
+	// Test: the revocation through another instance takes effect after the TTL
+	store.tokenVersions[userID] = 2
+	assert.NoError(t, checker.Check(ctx, userID, 1, sessionID, ""))
+	now = now.Add(10 * time.Second)
+	assert.ErrorIs(t, checker.Check(ctx, userID, 1, sessionID, ""), ErrRevoked)
+	assert.NoError(t, checker.Check(ctx, userID, 2, sessionID, ""))
+
+	// Test: the forgotten user's revocation takes effect immediately
+	store.activeSessions[sessionID] = false
+	assert.NoError(t, checker.Check(ctx, userID, 2, sessionID, ""))
+	checker.Forget(userID)
+	assert.ErrorIs(t, checker.Check(ctx, userID, 2, sessionID, ""), ErrRevoked)
+}
+
+func TestCheckerTokenID(t *testing.T) {
+	ctx := context.Background()
+	userID := uuid.New()
+	leakedTokenID := uuid.NewString()
+	store := &fakeStore{
+		tokenVersions: map[uuid.UUID]int32{userID: 1},
+		revokedTokens: map[string]bool{},
+	}
+	checker := NewChecker(store, 10*time.Second)
+
+	// Test: the JWT that isn't revoked
+	assert.NoError(t, checker.Check(ctx, userID, 1, uuid.Nil, leakedTokenID))
+
+	// Test: the revoked JWT is rejected once the user is forgotten, the user's other JWTs aren't
+	store.revokedTokens[leakedTokenID] = true
+	checker.Forget(userID)
+	assert.ErrorIs(t, checker.Check(ctx, userID, 1, uuid.Nil, leakedTokenID), ErrRevoked)
+	assert.NoError(t, checker.Check(ctx, userID, 1, uuid.Nil, uuid.NewString()))
+
+	// Test: the JWT without a JWT ID isn't looked up
+	queries := store.queries
+	assert.NoError(t, checker.Check(ctx, userID, 1, uuid.Nil, ""))
+	assert.Equal(t, queries, store.queries)
+}
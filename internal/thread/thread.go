@@ -0,0 +1,64 @@
+package thread
+
+import (
+	"github.com/google/uuid"
+	"github.com/oleshko-g/chirpy/internal/database"
+)
+
+// Node is the chirp with its replies, the oldest first.
+type Node struct {
+	Chirp   database.Chirp
+	Replies []*Node
+}
+
+// Build finds the chirp among the chirps of its conversation and returns its ancestors from the conversation's start
+// and the tree of its replies. The deleted chirps are kept as the ancestors and as the replies' parents
+// so the thread keeps its shape. The deleted chirps without the replies that aren't deleted are left out.
+// ok is false if the chirp isn't among the chirps.
+func Build(chirps []database.Chirp, chirpID uuid.UUID) (ancestors []database.Chirp, node *Node, ok bool) {
+	nodes := make(map[uuid.UUID]*Node, len(chirps))
+	for _, chirp := range chirps {
+		nodes[chirp.ID] = &Node{Chirp: chirp}
+	}
+	node, ok = nodes[chirpID]
+	if !ok {
+		return nil, nil, false
+	}
+
+	// the chirps are sorted by the creation time so the replies are appended the oldest first
+	for _, chirp := range chirps {
+		if !chirp.InReplyTo.Valid {
+			continue
+		}
+		if parent, ok := nodes[chirp.InReplyTo.UUID]; ok {
+			parent.Replies = append(parent.Replies, nodes[chirp.ID])
+		}
+	}
+	prune(node)
+
+	for parentID := node.Chirp.InReplyTo; parentID.Valid; {
+		parent, ok := nodes[parentID.UUID]
+		if !ok {
+			break
+		}
+		ancestors = append(ancestors, parent.Chirp)
+		parentID = parent.Chirp.InReplyTo
+	}
+	for i, j := 0, len(ancestors)-1; i < j; i, j = i+1, j-1 {
+		ancestors[i], ancestors[j] = ancestors[j], ancestors[i]
+	}
+	return ancestors, node, true
+}
+
+// prune drops the node's deleted replies that have no replies left after they are pruned.
+// It reports whether the node has a chirp that isn't deleted in its tree.
+func prune(node *Node) bool {
+	replies := node.Replies[:0]
+	for _, reply := range node.Replies {
+		if prune(reply) {
+			replies = append(replies, reply)
+		}
+	}
+	node.Replies = replies
+	return !node.Chirp.DeletedAt.Valid || len(node.Replies) > 0
+}
@@ -0,0 +1,66 @@
+package thread
+
+import (
+	"database/sql"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/oleshko-g/chirpy/internal/database"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBuild(t *testing.T) {
+	createdAt := time.Unix(1_750_000_000, 0)
+	var chirps []database.Chirp
+	newChirp := func(parent *database.Chirp, deleted bool) database.Chirp {
+		createdAt = createdAt.Add(time.Minute)
+		chirp := database.Chirp{ID: uuid.New(), CreatedAt: createdAt}
+		chirp.ConversationID = chirp.ID
+		if parent != nil {
+			chirp.InReplyTo = uuid.NullUUID{UUID: parent.ID, Valid: true}
+			chirp.ConversationID = parent.ConversationID
+		}
+		if deleted {
+			chirp.DeletedAt = sql.NullTime{Time: createdAt, Valid: true}
+		}
+		chirps = append(chirps, chirp)
+		return chirp
+	}
+
+	// root
+	// └── deletedParent (deleted)
+	//     ├── reply
+	//     │   ├── firstNested
+	//     │   └── deletedLeaf (deleted)
+	//     └── secondReply
+	root := newChirp(nil, false)
+	deletedParent := newChirp(&root, true)
+	reply := newChirp(&deletedParent, false)
+	firstNested := newChirp(&reply, false)
+	secondReply := newChirp(&deletedParent, false)
+	newChirp(&reply, true)
+
+	// Test: the ancestors from the conversation's start and the replies the oldest first
+	ancestors, node, ok := Build(chirps, reply.ID)
+	assert.True(t, ok)
+	assert.Equal(t, []database.Chirp{root, deletedParent}, ancestors)
+	assert.Equal(t, reply, node.Chirp)
+	if assert.Len(t, node.Replies, 1) {
+		assert.Equal(t, firstNested, node.Replies[0].Chirp)
+	}
+
+	// Test: the deleted chirp with the replies keeps the thread's shape
+	ancestors, node, ok = Build(chirps, root.ID)
+	assert.True(t, ok)
+	assert.Empty(t, ancestors)
+	if assert.Len(t, node.Replies, 1) {
+		assert.Equal(t, deletedParent, node.Replies[0].Chirp)
+		assert.Len(t, node.Replies[0].Replies, 2)
+		assert.Equal(t, secondReply, node.Replies[0].Replies[1].Chirp)
+	}
+
+	// Test: the chirp of another conversation
+	_, _, ok = Build(chirps, uuid.New())
+	assert.False(t, ok)
+}
@@ -0,0 +1,94 @@
+package webhook
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// signatureVersion prefixes the signatures of the scheme: v1 is HMAC-SHA256 of "{timestamp}.{raw body}"
+const signatureVersion = "v1="
+
+var (
+	ErrNoSignature        = errors.New("error webhook has no signature or timestamp")
+	ErrTimestampTolerance = errors.New("error webhook timestamp is outside the tolerance window")
+	ErrSignatureMismatch  = errors.New("error webhook signature doesn't match")
+)
+
+// Verifier verifies the signed webhooks. It holds up to two secrets while the sender rotates them:
+// the webhooks signed with either are accepted.
+type Verifier struct {
+	secrets   []string
+	tolerance time.Duration
+	now       func() time.Time
+}
+
+// NewVerifier returns the Verifier of the non-empty secrets. The webhooks whose timestamps
+// differ from the current time by more than tolerance are rejected so the captured ones can't be replayed later.
+func NewVerifier(tolerance time.Duration, secrets ...string) *Verifier {
+	v := &Verifier{
+		tolerance: tolerance,
+		now:       time.Now,
+	}
+	for _, secret := range secrets {
+		if secret != "" {
+			v.secrets = append(v.secrets, secret)
+		}
+	}
+	return v
+}
+
+// Enabled reports whether the Verifier has a secret.
+func (v *Verifier) Enabled() bool {
+	return len(v.secrets) > 0
+}
+
+// Verify checks the signature header of the raw body and the timestamp header in Unix seconds.
+// The signature header may have several comma-separated signatures, e.g. one per the sender's secret.
+func (v *Verifier) Verify(body []byte, timestamp, signatureHeader string) error {
+	if timestamp == "" || signatureHeader == "" {
+		return ErrNoSignature
+	}
+	unixSeconds, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return ErrNoSignature
+	}
+	sentAt := time.Unix(unixSeconds, 0)
+	if age := v.now().Sub(sentAt); age > v.tolerance || age < -v.tolerance {
+		return ErrTimestampTolerance
+	}
+
+	for _, signature := range strings.Split(signatureHeader, ",") {
+		signature, ok := strings.CutPrefix(strings.TrimSpace(signature), signatureVersion)
+		if !ok {
+			continue
+		}
+		mac, err := hex.DecodeString(signature)
+		if err != nil {
+			continue
+		}
+		for _, secret := range v.secrets {
+			if hmac.Equal(mac, sign(secret, timestamp, body)) {
+				return nil
+			}
+		}
+	}
+	return ErrSignatureMismatch
+}
+
+// Sign returns the signature header value of the body sent at the time.
+func Sign(secret string, sentAt time.Time, body []byte) string {
+	return signatureVersion + hex.EncodeToString(sign(secret, strconv.FormatInt(sentAt.Unix(), 10), body))
+}
+
+func sign(secret, timestamp string, body []byte) []byte {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return mac.Sum(nil)
+}
@@ -0,0 +1,50 @@
+package webhook
+
+import (
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestVerifier(t *testing.T) {
+	now := time.Unix(1_750_000_000, 0)
+	timestamp := strconv.FormatInt(now.Unix(), 10)
+	body := []byte(`{"id":"evt_1","event":"user.upgraded","data":{"user_id":"3311741c-680c-4546-99f3-fc9efac2036c"}}`)
+	v := NewVerifier(5*time.Minute, "current", "previous")
+	v.now = func() time.Time { return now }
+
+	assert.True(t, v.Enabled())
+	assert.False(t, NewVerifier(5*time.Minute, "", "").Enabled())
+
+	// Test: the webhooks signed with either secret during the rotation
+	assert.NoError(t, v.Verify(body, timestamp, Sign("current", now, body)))
+	assert.NoError(t, v.Verify(body, timestamp, Sign("previous", now, body)))
+	assert.NoError(t, v.Verify(body, timestamp, Sign("unknown", now, body)+", "+Sign("current", now, body)))
+
+	// Test: the webhook signed with another secret
+	assert.ErrorIs(t, v.Verify(body, timestamp, Sign("unknown", now, body)), ErrSignatureMismatch)
+
+	// Test: the tampered body
+	tampered := []byte(strings.Replace(string(body), "3311741c", "00000000", 1))
+	assert.ErrorIs(t, v.Verify(tampered, timestamp, Sign("current", now, body)), ErrSignatureMismatch)
+
+	// Test: the signature of another timestamp
+	later := now.Add(time.Minute)
+	assert.ErrorIs(t, v.Verify(body, strconv.FormatInt(later.Unix(), 10), Sign("current", now, body)), ErrSignatureMismatch)
+
+	// Test: the webhook outside the tolerance window
+	v.now = func() time.Time { return now.Add(6 * time.Minute) }
+	assert.ErrorIs(t, v.Verify(body, timestamp, Sign("current", now, body)), ErrTimestampTolerance)
+	v.now = func() time.Time { return now.Add(-6 * time.Minute) }
+	assert.ErrorIs(t, v.Verify(body, timestamp, Sign("current", now, body)), ErrTimestampTolerance)
+	v.now = func() time.Time { return now }
+
+	// Test: the missing or malformed headers
+	assert.ErrorIs(t, v.Verify(body, "", Sign("current", now, body)), ErrNoSignature)
+	assert.ErrorIs(t, v.Verify(body, timestamp, ""), ErrNoSignature)
+	assert.ErrorIs(t, v.Verify(body, "yesterday", Sign("current", now, body)), ErrNoSignature)
+	assert.ErrorIs(t, v.Verify(body, timestamp, "v1=not-hex"), ErrSignatureMismatch)
+}
@@ -1,31 +1,152 @@
 package main
 
 import (
+	"context"
 	"database/sql"
+	"errors"
 	"fmt"
 	"log"
 	"net/http"
 	"os"
+	"strconv"
 	"sync/atomic"
+	"time"
 
 	"github.com/joho/godotenv"
 	_ "github.com/lib/pq"
+	"github.com/oleshko-g/chirpy/internal/auth"
 	"github.com/oleshko-g/chirpy/internal/database"
+	"github.com/oleshko-g/chirpy/internal/lockout"
+	"github.com/oleshko-g/chirpy/internal/mailer"
+	"github.com/oleshko-g/chirpy/internal/oidc"
+	"github.com/oleshko-g/chirpy/internal/revocation"
+	"github.com/oleshko-g/chirpy/internal/webhook"
 )
 
 const port string = "8080"
 const rootPath = "./public"
 
+// emailLoginPolicy slows down guessing the password of an account
+var emailLoginPolicy = lockout.Policy{
+	FreeFailures:    5,
+	BaseDelay:       time.Duration(1) * time.Second,
+	MaxDelay:        time.Duration(5) * time.Minute,
+	LockoutFailures: 20,
+	LockoutDuration: time.Duration(30) * time.Minute,
+	ResetAfter:      time.Duration(1) * time.Hour,
+}
+
+// ipLoginPolicy slows down guessing the passwords of many accounts from a client.
+// It's looser than emailLoginPolicy because many users may share an IP.
+var ipLoginPolicy = lockout.Policy{
+	FreeFailures:    20,
+	BaseDelay:       time.Duration(1) * time.Second,
+	MaxDelay:        time.Duration(1) * time.Minute,
+	LockoutFailures: 100,
+	LockoutDuration: time.Duration(1) * time.Hour,
+	ResetAfter:      time.Duration(1) * time.Hour,
+}
+
+// ipPasswordResetPolicy slows down the password reset requests from a client after the free ones.
+// Every request counts, not only the failures
+var ipPasswordResetPolicy = lockout.Policy{
+	FreeFailures: 10,
+	BaseDelay:    time.Duration(1) * time.Minute,
+	MaxDelay:     time.Duration(1) * time.Hour,
+	ResetAfter:   time.Duration(1) * time.Hour,
+}
+
+// accessTokenLifetime is how long the access JWTs and the web app's access token cookie last
+const accessTokenLifetime = time.Duration(1) * time.Hour
+
+// accessTokenRevocationTTL is how long the other server instances may accept a revoked access JWT
+const accessTokenRevocationTTL = time.Duration(10) * time.Second
+
+// accountDeletionGracePeriod is how long the deleted accounts can be restored before they are purged
+const accountDeletionGracePeriod = time.Duration(30*24) * time.Hour
+
+// purgeInterval is how often the deleted accounts past the grace period, the expired webhook events
+// and the expired revoked access JWTs are purged
+const purgeInterval = time.Duration(1) * time.Hour
+
+// defaultChirpEditWindow is how long after posting the chirps can be edited unless CHIRP_EDIT_WINDOW is set
+const defaultChirpEditWindow = time.Duration(15) * time.Minute
+
+// polkaWebhookTolerance is how far the signed Polka webhook's timestamp may be from the current time
+const polkaWebhookTolerance = time.Duration(5) * time.Minute
+
 var c *apiConfig = &apiConfig{
 	fileserverHits: atomic.Int32{},
 }
 
-func init() {
+// loadConfig sets up the server's configuration from the environment and connects to the database.
+// It exits if the configuration is invalid. It isn't an init function so the tests can set up c themselves.
+func loadConfig() {
 	godotenv.Load()
 
 	c.platform = os.Getenv("PLATFORM")
-	c.jwtSecret = os.Getenv("JWT_SECRET")
+	c.requireVerifiedEmail = os.Getenv("REQUIRE_VERIFIED_EMAIL") == "true"
+	jwtKeyring, errLoadKeyring := loadJWTKeyring()
+	if errLoadKeyring != nil {
+		fmt.Fprintln(os.Stderr, errLoadKeyring)
+		os.Exit(1)
+	}
+	c.jwtKeyring = jwtKeyring
 	c.polkaApiKey = os.Getenv("POLKA_API_KEY")
+	c.polkaWebhookVerifier = webhook.NewVerifier(polkaWebhookTolerance,
+		os.Getenv("POLKA_WEBHOOK_SECRET"),
+		os.Getenv("POLKA_WEBHOOK_PREVIOUS_SECRET"),
+	)
+	c.trustProxyHeaders = os.Getenv("TRUST_PROXY_HEADERS") == "true"
+
+	c.chirpEditWindow = defaultChirpEditWindow
+	if value := os.Getenv("CHIRP_EDIT_WINDOW"); value != "" {
+		chirpEditWindow, errParseDuration := time.ParseDuration(value)
+		if errParseDuration != nil || chirpEditWindow < 0 {
+			fmt.Fprintf(os.Stderr, "error CHIRP_EDIT_WINDOW must be a non-negative duration: %q\n", value)
+			os.Exit(1)
+		}
+		c.chirpEditWindow = chirpEditWindow
+	}
+
+	c.baseURL = os.Getenv("BASE_URL")
+	if c.baseURL == "" {
+		c.baseURL = "http://localhost:" + port
+	}
+
+	m, errNewMailer := newMailer()
+	if errNewMailer != nil {
+		fmt.Fprintln(os.Stderr, errNewMailer)
+		os.Exit(1)
+	}
+	c.mailer = m
+
+	passwordParams, errPasswordParams := loadPasswordParams()
+	if errPasswordParams != nil {
+		fmt.Fprintln(os.Stderr, errPasswordParams)
+		os.Exit(1)
+	}
+	c.passwordParams = passwordParams
+
+	passwordPolicy, errPasswordPolicy := loadPasswordPolicy()
+	if errPasswordPolicy != nil {
+		fmt.Fprintln(os.Stderr, errPasswordPolicy)
+		os.Exit(1)
+	}
+	c.passwordPolicy = passwordPolicy
+
+	oidcProvider, errOIDCProvider := newOIDCProvider()
+	if errOIDCProvider != nil {
+		fmt.Fprintln(os.Stderr, errOIDCProvider)
+		os.Exit(1)
+	}
+	c.oidcProvider = oidcProvider
+
+	c.refreshTokenHashKey = os.Getenv("REFRESH_TOKEN_HASH_KEY")
+	if c.refreshTokenHashKey == "" {
+		fmt.Fprintln(os.Stderr, "error REFRESH_TOKEN_HASH_KEY is not set")
+		os.Exit(1)
+	}
 
 	dbConn, errDBConn := openPostgresDB(os.Getenv("DB_URL"))
 	if errDBConn != nil {
@@ -33,7 +154,23 @@ func init() {
 		os.Exit(1)
 	}
 
+	c.db = dbConn
 	c.dbQueries = database.New(dbConn)
+
+	var loginAttemptsStore lockout.Store
+	switch os.Getenv("LOGIN_ATTEMPTS_STORE") {
+	case "postgres":
+		loginAttemptsStore = lockout.NewPostgresStore(c.dbQueries)
+	case "", "memory":
+		loginAttemptsStore = lockout.NewMemoryStore()
+	default:
+		fmt.Fprintf(os.Stderr, "error unknown LOGIN_ATTEMPTS_STORE %q\n", os.Getenv("LOGIN_ATTEMPTS_STORE"))
+		os.Exit(1)
+	}
+	c.emailLoginLimiter = lockout.NewLimiter(loginAttemptsStore, emailLoginPolicy)
+	c.ipLoginLimiter = lockout.NewLimiter(loginAttemptsStore, ipLoginPolicy)
+	c.ipPasswordResetLimiter = lockout.NewLimiter(loginAttemptsStore, ipPasswordResetPolicy)
+	c.accessTokenRevocation = revocation.NewChecker(revocation.NewPostgresStore(c.dbQueries), accessTokenRevocationTTL)
 }
 
 func newServeMux() *http.ServeMux {
@@ -48,23 +185,199 @@ func newServeMux() *http.ServeMux {
 		),
 	)
 
+	mux.HandleFunc("GET /.well-known/jwks.json", jwksHandler)
 	mux.HandleFunc("GET /api/healthz", healthzHandler)
 	mux.HandleFunc("POST /api/users", createUser)
 	mux.HandleFunc("PUT /api/users", authenticateUserMiddleware(putUserHandler))
+	mux.HandleFunc("GET /api/users/me", authenticateUserMiddleware(getCurrentUserHandler, auth.ScopeProfile))
+	mux.HandleFunc("DELETE /api/users/me", authenticateUserMiddleware(deleteCurrentUserHandler))
+	mux.HandleFunc("GET /api/users/me/export", authenticateUserMiddleware(exportUserDataHandler))
+	mux.HandleFunc("GET /api/users/me/security-events", authenticateUserMiddleware(getSecurityEventsHandler))
+	mux.HandleFunc("GET /api/users/{user_id}/likes", getUserLikes)
+	mux.HandleFunc("POST /api/users/restore", restoreUserHandler)
+	mux.HandleFunc("POST /api/users/verify", verifyEmailHandler)
+	mux.HandleFunc("POST /api/users/verify/resend", authenticateUserMiddleware(resendVerificationEmailHandler))
 	mux.HandleFunc("POST /api/login", loginUser)
-	mux.HandleFunc("GET /admin/metrics", c.showFileSrvHits)
-	mux.HandleFunc("POST /admin/reset", c.resetServer)
+	mux.HandleFunc("POST /api/login/mfa", loginMFAHandler)
+	mux.HandleFunc("POST /api/login/magic", startMagicLinkLoginHandler)
+	mux.HandleFunc("POST /api/login/magic/callback", magicLinkCallbackHandler)
+	mux.HandleFunc("POST /api/login/oidc", startOIDCLoginHandler)
+	mux.HandleFunc("POST /api/login/oidc/callback", oidcCallbackHandler)
+	mux.HandleFunc("POST /api/password/forgot", forgotPasswordHandler)
+	mux.HandleFunc("POST /api/password/reset", resetPasswordHandler)
+	mux.HandleFunc("POST /api/users/totp", authenticateUserMiddleware(enrollTOTPHandler))
+	mux.HandleFunc("POST /api/users/totp/confirm", authenticateUserMiddleware(confirmTOTPHandler))
+	mux.HandleFunc("GET /admin/metrics", requireRoleMiddleware(auth.RoleAdmin, c.showFileSrvHits))
+	mux.HandleFunc("POST /admin/reset", requireRoleMiddleware(auth.RoleAdmin, c.resetServer))
+	mux.HandleFunc("POST /admin/login/unlock", requireRoleMiddleware(auth.RoleAdmin, unlockLoginHandler))
+	mux.HandleFunc("PUT /admin/users/{user_id}/role", requireRoleMiddleware(auth.RoleAdmin, setUserRoleHandler))
+	mux.HandleFunc("GET /admin/audit-events", requireRoleMiddleware(auth.RoleAdmin, getAuditEventsHandler))
 	mux.HandleFunc("POST /api/chirps", createChirp)
-	mux.HandleFunc("DELETE /api/chirps/{chirp_id}", authenticateUserMiddleware(deleteChirp))
+	mux.HandleFunc("DELETE /api/chirps/{chirp_id}", authenticateUserMiddleware(deleteChirp, auth.ScopeChirpsWrite))
 	mux.HandleFunc("GET /api/chirps", getChirps)
 	mux.HandleFunc("GET /api/chirps/{chirp_id}", getChirp)
+	mux.HandleFunc("PATCH /api/chirps/{chirp_id}", authenticateUserMiddleware(editChirpHandler, auth.ScopeChirpsWrite))
+	mux.HandleFunc("GET /api/chirps/{chirp_id}/history", getChirpHistory)
+	mux.HandleFunc("GET /api/chirps/{chirp_id}/replies", getChirpReplies)
+	mux.HandleFunc("GET /api/chirps/{chirp_id}/thread", getChirpThread)
+	mux.HandleFunc("POST /api/chirps/{chirp_id}/like", authenticateUserMiddleware(likeChirpHandler, auth.ScopeChirpsWrite))
+	mux.HandleFunc("DELETE /api/chirps/{chirp_id}/like", authenticateUserMiddleware(unlikeChirpHandler, auth.ScopeChirpsWrite))
+	mux.HandleFunc("POST /api/chirps/{chirp_id}/rechirp", authenticateUserMiddleware(rechirpHandler, auth.ScopeChirpsWrite))
+	mux.HandleFunc("DELETE /api/chirps/{chirp_id}/rechirp", authenticateUserMiddleware(unrechirpHandler, auth.ScopeChirpsWrite))
 	mux.HandleFunc("POST /api/refresh", refreshAccessTokenHandler)
 	mux.HandleFunc("POST /api/revoke", UpdateRefreshToken)
 	mux.HandleFunc("POST /api/polka/webhooks", setUserIsChirpyRed)
+	mux.HandleFunc("GET /api/sessions", authenticateUserMiddleware(getSessionsHandler))
+	mux.HandleFunc("DELETE /api/sessions", authenticateUserMiddleware(deleteOtherSessionsHandler))
+	mux.HandleFunc("DELETE /api/sessions/{session_id}", authenticateUserMiddleware(deleteSessionHandler))
+	mux.HandleFunc("POST /api/logout/all", authenticateUserMiddleware(logoutEverywhereHandler))
+	mux.HandleFunc("POST /api/access-tokens/revoke", authenticateUserMiddleware(revokeAccessTokenHandler))
+	mux.HandleFunc("POST /api/oauth/clients", authenticateUserMiddleware(createOAuthClientHandler))
+	mux.HandleFunc("POST /api/tokens", authenticateUserMiddleware(createPersonalAccessTokenHandler))
+	mux.HandleFunc("GET /api/tokens", authenticateUserMiddleware(getPersonalAccessTokensHandler))
+	mux.HandleFunc("DELETE /api/tokens/{token_id}", authenticateUserMiddleware(deletePersonalAccessTokenHandler))
+	mux.HandleFunc("GET /oauth/authorize", oauthAuthorizeHandler)
+	mux.HandleFunc("POST /oauth/authorize", oauthConsentHandler)
+	mux.HandleFunc("POST /oauth/token", oauthTokenHandler)
 
 	return mux
 }
 
+// loadJWTKeyring loads the signing keys from JWT_KEYS_DIR and signs with JWT_ACTIVE_KID.
+// JWT_SECRET is kept to validate HS256 tokens issued before the keys were set up.
+func loadJWTKeyring() (*auth.Keyring, error) {
+	var (
+		keyring *auth.Keyring
+		err     error
+	)
+	if keysDir := os.Getenv("JWT_KEYS_DIR"); keysDir != "" {
+		keyring, err = auth.LoadKeyringDir(keysDir, os.Getenv("JWT_ACTIVE_KID"))
+	} else {
+		keyring, err = auth.NewKeyring("")
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	keyring.SetLegacySecret(os.Getenv("JWT_SECRET"))
+	return keyring, nil
+}
+
+// loadPasswordParams overrides the default Argon2id params with
+// PASSWORD_ARGON2_MEMORY_KIB, PASSWORD_ARGON2_ITERATIONS and PASSWORD_ARGON2_PARALLELISM.
+// The users' hashes are upgraded to the new params when they log in.
+func loadPasswordParams() (auth.Argon2idParams, error) {
+	params := auth.DefaultArgon2idParams
+
+	for _, v := range []struct {
+		name string
+		bits int
+		set  func(n uint64)
+	}{
+		{"PASSWORD_ARGON2_MEMORY_KIB", 32, func(n uint64) { params.Memory = uint32(n) }},
+		{"PASSWORD_ARGON2_ITERATIONS", 32, func(n uint64) { params.Iterations = uint32(n) }},
+		{"PASSWORD_ARGON2_PARALLELISM", 8, func(n uint64) { params.Parallelism = uint8(n) }},
+	} {
+		value := os.Getenv(v.name)
+		if value == "" {
+			continue
+		}
+		n, err := strconv.ParseUint(value, 10, v.bits)
+		if err != nil || n == 0 {
+			return auth.Argon2idParams{}, fmt.Errorf("error %s must be a positive integer: %q", v.name, value)
+		}
+		v.set(n)
+	}
+
+	return params, nil
+}
+
+// loadPasswordPolicy sets the password length limits from PASSWORD_MIN_LENGTH and PASSWORD_MAX_LENGTH
+// and loads the breached passwords from BREACHED_PASSWORDS_PATH.
+func loadPasswordPolicy() (auth.PasswordPolicy, error) {
+	policy := auth.PasswordPolicy{
+		MinLength: 8,
+		MaxLength: 256,
+	}
+
+	for _, v := range []struct {
+		name   string
+		length *int
+	}{
+		{"PASSWORD_MIN_LENGTH", &policy.MinLength},
+		{"PASSWORD_MAX_LENGTH", &policy.MaxLength},
+	} {
+		value := os.Getenv(v.name)
+		if value == "" {
+			continue
+		}
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 1 {
+			return auth.PasswordPolicy{}, fmt.Errorf("error %s must be a positive integer: %q", v.name, value)
+		}
+		*v.length = n
+	}
+
+	if path := os.Getenv("BREACHED_PASSWORDS_PATH"); path != "" {
+		breached, err := auth.LoadBreachedPasswords(path)
+		if err != nil {
+			return auth.PasswordPolicy{}, err
+		}
+		policy.Breached = breached
+	}
+
+	return policy, nil
+}
+
+// newOIDCProvider sets up the login with the OpenID Connect provider of OIDC_ISSUER.
+// The provider's endpoints are discovered at the first login so the server starts while the provider is down.
+func newOIDCProvider() (*oidc.Provider, error) {
+	issuer := os.Getenv("OIDC_ISSUER")
+	if issuer == "" {
+		return nil, nil
+	}
+
+	config := oidc.Config{
+		Issuer:       issuer,
+		ClientID:     os.Getenv("OIDC_CLIENT_ID"),
+		ClientSecret: os.Getenv("OIDC_CLIENT_SECRET"),
+		RedirectURL:  os.Getenv("OIDC_REDIRECT_URL"),
+	}
+	if config.ClientID == "" || config.RedirectURL == "" {
+		return nil, fmt.Errorf("error OIDC_CLIENT_ID and OIDC_REDIRECT_URL must be set with OIDC_ISSUER")
+	}
+	return oidc.NewProvider(config, nil), nil
+}
+
+// newMailer returns the mailer set by MAILER:
+// "smtp" sends through SMTP_ADDR, "file" writes to MAIL_OUTBOX_DIR and the default prints to stdout.
+func newMailer() (mailer.Mailer, error) {
+	from := os.Getenv("MAIL_FROM")
+	if from == "" {
+		from = "Chirpy <no-reply@localhost>"
+	}
+
+	switch os.Getenv("MAILER") {
+	case "smtp":
+		return mailer.NewSMTPMailer(
+			os.Getenv("SMTP_ADDR"),
+			from,
+			os.Getenv("SMTP_USERNAME"),
+			os.Getenv("SMTP_PASSWORD"),
+		)
+	case "file":
+		outboxDir := os.Getenv("MAIL_OUTBOX_DIR")
+		if outboxDir == "" {
+			outboxDir = "./outbox"
+		}
+		return &mailer.FileMailer{Dir: outboxDir, From: from}, nil
+	case "", "log":
+		return &mailer.LogMailer{W: os.Stdout, From: from}, nil
+	default:
+		return nil, fmt.Errorf("error unknown MAILER %q", os.Getenv("MAILER"))
+	}
+}
+
 func openPostgresDB(dbURL string) (*sql.DB, error) {
 	db, err := sql.Open("postgres", dbURL)
 	if err != nil {
@@ -77,13 +390,102 @@ func openPostgresDB(dbURL string) (*sql.DB, error) {
 	return db, nil
 }
 
+// grantRole grants the role to the user with the email. Run it to bootstrap the first admin:
+//
+//	chirpy grant-role admin saul@bettercall.com
+func grantRole(role, email string) error {
+	if !auth.ValidRole(role) {
+		return fmt.Errorf("error unknown role %q", role)
+	}
+
+	ctx := context.Background()
+	selectedUser, errSelectUserByEmail := c.dbQueries.SelectUserByEmail(ctx, email)
+	if errSelectUserByEmail != nil {
+		if errors.Is(errSelectUserByEmail, sql.ErrNoRows) {
+			return fmt.Errorf("error user %q doesn't exist", email)
+		}
+		return errSelectUserByEmail
+	}
+
+	_, errSetUserRole := c.dbQueries.SetUserRole(ctx, database.SetUserRoleParams{
+		ID:   selectedUser.ID,
+		Role: role,
+	})
+	if errSetUserRole != nil {
+		return errSetUserRole
+	}
+	// the user's JWTs with the previous role are revoked
+	_, errBumpUserTokenVersion := c.dbQueries.BumpUserTokenVersion(ctx, selectedUser.ID)
+	if errBumpUserTokenVersion != nil {
+		return errBumpUserTokenVersion
+	}
+	return c.dbQueries.InsertAuditEvent(ctx, auditEvent{
+		eventType: auditAdminRoleChanged,
+		userID:    selectedUser.ID,
+		details:   map[string]string{"role": role, "via": "cli"},
+	}.insertParams())
+}
+
+// purge hard-deletes the accounts deleted before the grace period with all their data,
+// forgets the webhook events that can't be replayed anymore and the revoked access JWTs that have expired
+// every purgeInterval until the context is done.
+func purge(ctx context.Context) {
+	ticker := time.NewTicker(purgeInterval)
+	defer ticker.Stop()
+	for {
+		purgedRows, err := c.dbQueries.PurgeDeletedUsers(ctx, sql.NullTime{
+			Time:  time.Now().UTC().Add(-accountDeletionGracePeriod),
+			Valid: true,
+		})
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "error purging the deleted users: %s\n", err)
+		} else if purgedRows > 0 {
+			fmt.Printf("Purged %d deleted users\n", purgedRows)
+		}
+
+		// the event's replay is rejected by its timestamp after twice the tolerance
+		_, err = c.dbQueries.DeleteWebhookEventsBefore(ctx, time.Now().UTC().Add(-2*polkaWebhookTolerance))
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "error purging the webhook events: %s\n", err)
+		}
+
+		// the expired access JWTs are rejected without the denylist
+		_, err = c.dbQueries.DeleteRevokedAccessTokensBefore(ctx, time.Now().UTC())
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "error purging the revoked access tokens: %s\n", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
 func main() {
+	loadConfig()
+
+	if len(os.Args) > 1 {
+		if len(os.Args) != 4 || os.Args[1] != "grant-role" {
+			fmt.Fprintln(os.Stderr, "usage: chirpy grant-role {user|moderator|admin} {email}")
+			os.Exit(2)
+		}
+		if err := grantRole(os.Args[2], os.Args[3]); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		fmt.Printf("%s is granted the %s role. The role takes effect when the user refreshes the access JWT\n", os.Args[3], os.Args[2])
+		return
+	}
 
 	server := &http.Server{
 		Handler: newServeMux(),
 		Addr:    ":" + port,
 	}
 
+	go purge(context.Background())
+
 	fmt.Printf("Serving on the port %s\n", server.Addr)
 	log.Fatal(server.ListenAndServe())
 }
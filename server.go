@@ -1,45 +1,406 @@
 package main
 
 import (
+	"context"
+	"crypto/subtle"
 	"database/sql"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"html/template"
+	"io"
+	"math"
+	"mime"
+	"net"
 	"net/http"
+	"net/mail"
+	"net/url"
 	"os"
-	"sort"
+	"slices"
 	"strconv"
 	"strings"
 	"sync/atomic"
 	"time"
 
+	"github.com/golang-jwt/jwt/v5"
 	"github.com/google/uuid"
+	"github.com/lib/pq"
 	"github.com/oleshko-g/chirpy/internal/auth"
 	"github.com/oleshko-g/chirpy/internal/database"
+	"github.com/oleshko-g/chirpy/internal/lockout"
+	"github.com/oleshko-g/chirpy/internal/mailer"
+	"github.com/oleshko-g/chirpy/internal/oidc"
+	"github.com/oleshko-g/chirpy/internal/pagination"
+	"github.com/oleshko-g/chirpy/internal/revocation"
+	"github.com/oleshko-g/chirpy/internal/thread"
+	"github.com/oleshko-g/chirpy/internal/webhook"
 )
 
 type apiConfig struct {
 	fileserverHits atomic.Int32
+	db             *sql.DB
 	dbQueries      *database.Queries
 	platform       string
-	jwtSecret      string
-	polkaApiKey    string
+	jwtKeyring     *auth.Keyring
+	// refreshTokenHashKey is the HMAC key of the stored refresh token hashes
+	refreshTokenHashKey string
+	// polkaApiKey authenticates the legacy unsigned Polka webhooks. They are rejected if it's empty
+	polkaApiKey          string
+	polkaWebhookVerifier *webhook.Verifier
+	mailer               mailer.Mailer
+	// baseURL is the public URL of the server for the links in the emails
+	baseURL string
+	// requireVerifiedEmail forbids the users to post chirps until they verify their emails
+	requireVerifiedEmail bool
+	emailLoginLimiter    *lockout.Limiter
+	ipLoginLimiter       *lockout.Limiter
+	// ipPasswordResetLimiter counts every password reset request from a client's IP
+	ipPasswordResetLimiter *lockout.Limiter
+	// trustProxyHeaders takes the client's IP from X-Forwarded-For
+	trustProxyHeaders bool
+	passwordParams    auth.Argon2idParams
+	passwordPolicy    auth.PasswordPolicy
+	// oidcProvider is nil unless the login with the OpenID Connect provider is set up
+	oidcProvider          *oidc.Provider
+	accessTokenRevocation *revocation.Checker
+	// chirpEditWindow is how long after posting the authors can edit their chirps
+	chirpEditWindow time.Duration
 }
 
-func authenticateUserMiddleware(handlerWithUser func(w http.ResponseWriter, r *http.Request, userID uuid.UUID)) (handler func(w http.ResponseWriter, r *http.Request)) {
+// totpIssuer names Chirpy in the users' authenticator apps
+const totpIssuer = "Chirpy"
+
+type contextKey string
+
+const userClaimsContextKey contextKey = "userClaims"
+
+// authenticateUserMiddleware accepts the JWTs of the OAuth clients and the personal access tokens
+// only if they are granted all the scopes. Without the scopes the handler is only for the first-party JWTs.
+// The web app's requests authenticated with the session cookies must pass the CSRF check.
+func authenticateUserMiddleware(handlerWithUser func(w http.ResponseWriter, r *http.Request, userID uuid.UUID), scopes ...string) (handler func(w http.ResponseWriter, r *http.Request)) {
 	return func(w http.ResponseWriter, r *http.Request) {
-		b, errGetBearerToken := auth.GetBearerToken(&r.Header)
-		if errGetBearerToken != nil {
+		b, fromCookie, errRequestToken := requestToken(r, accessTokenCookieName)
+		if errRequestToken != nil {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		userClaims, errValidateAccessToken := validateAccessToken(r.Context(), b)
+		if errValidateAccessToken != nil {
 			w.WriteHeader(http.StatusUnauthorized)
+			return
 		}
-		userID, errValidateUserJWT := auth.ValidateUserJWT(b, c.jwtSecret)
-		if errValidateUserJWT != nil {
+		userID, errUserID := userClaims.UserID()
+		if errUserID != nil {
 			w.WriteHeader(http.StatusUnauthorized)
 			return
 		}
+		if fromCookie && !csrfProtected(r, userClaims.SessionID) {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		if insufficientScope(w, userClaims, scopes...) {
+			return
+		}
+
+		ctx := context.WithValue(r.Context(), userClaimsContextKey, userClaims)
+		handlerWithUser(w, r.WithContext(ctx), userID)
+	}
+}
+
+// validateAccessToken validates the access JWT or the personal access token.
+// The personal access token is turned into the claims of a JWT with its scope and its id as the JWT ID.
+func validateAccessToken(ctx context.Context, token string) (auth.UserClaims, error) {
+	if !auth.IsPersonalAccessToken(token) {
+		return validateUserJWT(ctx, token)
+	}
+
+	personalAccessToken, errUsePersonalAccessToken := c.dbQueries.UsePersonalAccessToken(ctx, auth.HashToken(token))
+	if errUsePersonalAccessToken != nil {
+		return auth.UserClaims{}, errUsePersonalAccessToken
+	}
+	return personalAccessTokenClaims(ctx, personalAccessToken)
+}
+
+// personalAccessTokenClaims checks the personal access token hasn't been revoked with the user's token version
+// like the access JWTs: logging out everywhere, the password change or reset and the account deletion revoke it.
+func personalAccessTokenClaims(ctx context.Context, personalAccessToken database.PersonalAccessToken) (auth.UserClaims, error) {
+	err := c.accessTokenRevocation.Check(ctx, personalAccessToken.UserID, personalAccessToken.TokenVersion, uuid.Nil, "")
+	if err != nil {
+		return auth.UserClaims{}, err
+	}
+
+	userClaims := auth.UserClaims{Scope: strings.Join(personalAccessToken.Scopes, " ")}
+	userClaims.Subject = personalAccessToken.UserID.String()
+	userClaims.ID = personalAccessToken.ID.String()
+	userClaims.TokenVersion = personalAccessToken.TokenVersion
+	return userClaims, nil
+}
+
+// validateUserJWT validates the access JWT and checks it hasn't been revoked.
+func validateUserJWT(ctx context.Context, token string) (auth.UserClaims, error) {
+	userClaims, err := c.jwtKeyring.ValidateUserClaims(token)
+	if err != nil {
+		return auth.UserClaims{}, err
+	}
+	userID, err := userClaims.UserID()
+	if err != nil {
+		return auth.UserClaims{}, err
+	}
+	// the access JWTs issued before the sessions have no session id
+	sessionID, _ := uuid.Parse(userClaims.SessionID)
+
+	if err := c.accessTokenRevocation.Check(ctx, userID, userClaims.TokenVersion, sessionID, userClaims.ID); err != nil {
+		return auth.UserClaims{}, err
+	}
+	return userClaims, nil
+}
+
+// revokeUserAccessTokens bumps the user's token version so all the user's access JWTs are revoked.
+func revokeUserAccessTokens(ctx context.Context, queries *database.Queries, userID uuid.UUID) (int32, error) {
+	tokenVersion, err := queries.BumpUserTokenVersion(ctx, userID)
+	if err != nil {
+		return 0, err
+	}
+	c.accessTokenRevocation.Forget(userID)
+	return tokenVersion, nil
+}
+
+// insufficientScope responds with 403 Forbidden if the JWT of an OAuth client or the personal access token
+// isn't granted the scopes.
+func insufficientScope(w http.ResponseWriter, userClaims auth.UserClaims, scopes ...string) bool {
+	if userClaims.FirstParty() {
+		return false
+	}
+	if len(scopes) > 0 && auth.HasScopes(userClaims.Scope, scopes...) {
+		return false
+	}
+	// RFC 6750, section 3.1
+	w.Header().Set("www-authenticate", fmt.Sprintf(`Bearer error="insufficient_scope", scope="%s"`, strings.Join(scopes, " ")))
+	w.WriteHeader(http.StatusForbidden)
+	return true
+}
+
+// userClaimsFromContext returns the claims of the access JWT authenticated by authenticateUserMiddleware.
+func userClaimsFromContext(ctx context.Context) auth.UserClaims {
+	userClaims, _ := ctx.Value(userClaimsContextKey).(auth.UserClaims)
+	return userClaims
+}
+
+// The cookies of the web app's sessions. The API clients send the tokens in the Authorization header instead.
+const (
+	accessTokenCookieName  = "chirpy_access_token"
+	refreshTokenCookieName = "chirpy_refresh_token"
+	// csrfTokenCookieName is readable by the web app's scripts which send it back in csrfTokenHeader
+	csrfTokenCookieName = "chirpy_csrf_token"
+	csrfTokenHeader     = "x-csrf-token"
+)
+
+// setAccessTokenCookie sets the access JWT's cookie with the JWT's lifetime.
+func setAccessTokenCookie(w http.ResponseWriter, accessToken string) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     accessTokenCookieName,
+		Value:    accessToken,
+		Path:     "/",
+		MaxAge:   int(accessTokenLifetime.Seconds()),
+		Secure:   true,
+		HttpOnly: true,
+		SameSite: http.SameSiteStrictMode,
+	})
+}
+
+// setSessionCookies sets the cookies of the web app's session. The refresh token's cookie is only sent
+// to /api/refresh and /api/revoke. The CSRF token is bound to the session.
+func setSessionCookies(w http.ResponseWriter, accessToken, refreshToken string, sessionID uuid.UUID) string {
+	const sessionMaxAge = 60 * 24 * 60 * 60
+
+	setAccessTokenCookie(w, accessToken)
+	http.SetCookie(w, &http.Cookie{
+		Name:     refreshTokenCookieName,
+		Value:    refreshToken,
+		Path:     "/api",
+		MaxAge:   sessionMaxAge,
+		Secure:   true,
+		HttpOnly: true,
+		SameSite: http.SameSiteStrictMode,
+	})
+	csrfToken := auth.CSRFToken(sessionID.String(), c.refreshTokenHashKey)
+	http.SetCookie(w, &http.Cookie{
+		Name:     csrfTokenCookieName,
+		Value:    csrfToken,
+		Path:     "/",
+		MaxAge:   sessionMaxAge,
+		Secure:   true,
+		SameSite: http.SameSiteStrictMode,
+	})
+	return csrfToken
+}
+
+func clearSessionCookies(w http.ResponseWriter) {
+	for name, path := range map[string]string{
+		accessTokenCookieName:  "/",
+		refreshTokenCookieName: "/api",
+		csrfTokenCookieName:    "/",
+	} {
+		http.SetCookie(w, &http.Cookie{
+			Name:     name,
+			Path:     path,
+			MaxAge:   -1,
+			Secure:   true,
+			HttpOnly: name != csrfTokenCookieName,
+			SameSite: http.SameSiteStrictMode,
+		})
+	}
+}
+
+// requestToken returns the bearer token of the Authorization header.
+// Without the header it falls back to the token of the web app's cookie and reports it's from the cookie.
+func requestToken(r *http.Request, cookieName string) (token string, fromCookie bool, err error) {
+	if r.Header.Get("authorization") != "" {
+		token, err := auth.GetBearerToken(&r.Header)
+		return token, false, err
+	}
+	cookie, err := r.Cookie(cookieName)
+	if err != nil {
+		return "", false, err
+	}
+	return cookie.Value, true, nil
+}
+
+// csrfProtected reports whether the request authenticated with the session cookies is safe to serve.
+// The state-changing requests must send the session's CSRF token in csrfTokenHeader (synchronizer token).
+func csrfProtected(r *http.Request, sessionID string) bool {
+	switch r.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+		return true
+	}
+	return sessionID != "" && auth.ValidCSRFToken(r.Header.Get(csrfTokenHeader), sessionID, c.refreshTokenHashKey)
+}
+
+// jsonRequest reports whether the request's body is JSON. The cross-site HTML forms can't send JSON
+// without a CORS preflight, so the logins setting the cookies require it.
+func jsonRequest(r *http.Request) bool {
+	mediaType, _, err := mime.ParseMediaType(r.Header.Get("content-type"))
+	return err == nil && mediaType == "application/json"
+}
+
+// clientIP returns the IP address of the client without the port.
+// Behind a load balancer it's the last address of X-Forwarded-For the load balancer has appended.
+func clientIP(r *http.Request) string {
+	if c.trustProxyHeaders {
+		forwardedFor := r.Header.Values("x-forwarded-for")
+		if len(forwardedFor) > 0 {
+			addresses := strings.Split(forwardedFor[len(forwardedFor)-1], ",")
+			if address := strings.TrimSpace(addresses[len(addresses)-1]); address != "" {
+				return address
+			}
+		}
+	}
 
-		handlerWithUser(w, r, userID)
+	host, _, errSplitHostPort := net.SplitHostPort(r.RemoteAddr)
+	if errSplitHostPort != nil {
+		return r.RemoteAddr
 	}
+	return host
+}
+
+func nullString(s string) sql.NullString {
+	return sql.NullString{String: s, Valid: s != ""}
+}
+
+func nullUUID(id uuid.UUID) uuid.NullUUID {
+	return uuid.NullUUID{UUID: id, Valid: id != uuid.Nil}
+}
+
+// formatNullTime formats the time in RFC3339 for the responses. NULL is null.
+func formatNullTime(t sql.NullTime) *string {
+	if !t.Valid {
+		return nil
+	}
+	formatted := t.Time.Format(time.RFC3339)
+	return &formatted
+}
+
+// The types of the audit events
+const (
+	auditLoginSucceeded             = "login.succeeded"
+	auditLoginFailed                = "login.failed"
+	auditTokenRefreshed             = "token.refreshed"
+	auditTokenReuseDetected         = "token.reuse_detected"
+	auditTokenRevoked               = "token.revoked"
+	auditAccessTokenRevoked         = "access_token.revoked"
+	auditSessionRevoked             = "session.revoked"
+	auditOtherSessionsRevoked       = "session.others_revoked"
+	auditLogoutEverywhere           = "logout.everywhere"
+	auditPasswordChanged            = "password.changed"
+	auditPasswordReset              = "password.reset"
+	auditEmailChanged               = "email.changed"
+	auditMFAEnabled                 = "mfa.enabled"
+	auditPersonalAccessTokenCreated = "personal_access_token.created"
+	auditPersonalAccessTokenRevoked = "personal_access_token.revoked"
+	auditOAuthAuthorized            = "oauth.authorized"
+	auditChirpyRedUpgraded          = "chirpy_red.upgraded"
+	auditAccountDeleted             = "account.deleted"
+	auditAccountRestored            = "account.restored"
+	auditAdminRoleChanged           = "admin.role_changed"
+	auditAdminLoginUnlocked         = "admin.login_unlocked"
+)
+
+type auditEvent struct {
+	eventType string
+	// actorID is taken from the authenticated access token if it isn't set
+	actorID uuid.UUID
+	userID  uuid.UUID
+	email   string
+	details map[string]string
+}
+
+func (event auditEvent) insertParams() database.InsertAuditEventParams {
+	details, _ := json.Marshal(event.details)
+	if event.details == nil {
+		details = []byte("{}")
+	}
+	return database.InsertAuditEventParams{
+		EventType: event.eventType,
+		ActorID:   nullUUID(event.actorID),
+		UserID:    nullUUID(event.userID),
+		Email:     nullString(event.email),
+		Details:   details,
+	}
+}
+
+// recordAuditEvent appends the event with the client's IP and user agent to the audit log.
+// The failure to record it is logged and doesn't fail the request.
+func recordAuditEvent(r *http.Request, event auditEvent) {
+	if event.actorID == uuid.Nil {
+		event.actorID, _ = userClaimsFromContext(r.Context()).UserID()
+	}
+	params := event.insertParams()
+	params.IpAddress = nullString(clientIP(r))
+	params.UserAgent = nullString(r.UserAgent())
+	if err := c.dbQueries.InsertAuditEvent(r.Context(), params); err != nil {
+		fmt.Fprintf(os.Stderr, "error recording the audit event %s: %s\n", event.eventType, err)
+	}
+}
+
+// signSessionJWT signs the user's access JWT for the session of the refresh token family.
+func signSessionJWT(user database.User, familyID uuid.UUID) (string, error) {
+	userClaims := auth.NewUserClaims(user.ID, accessTokenLifetime)
+	userClaims.SessionID = familyID.String()
+	userClaims.Role = user.Role
+	userClaims.TokenVersion = user.TokenVersion
+	return c.jwtKeyring.SignUserClaims(userClaims)
+}
+
+// requireRoleMiddleware lets only the users with at least the role to the handler.
+// The role is taken from the first-party JWT. The role changes revoke the user's JWTs.
+func requireRoleMiddleware(role string, handler func(w http.ResponseWriter, r *http.Request)) func(w http.ResponseWriter, r *http.Request) {
+	return authenticateUserMiddleware(func(w http.ResponseWriter, r *http.Request, _ uuid.UUID) {
+		if !auth.RoleAtLeast(userClaimsFromContext(r.Context()).Role, role) {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		handler(w, r)
+	})
 }
 
 func (c *apiConfig) incFileSrvHits(h http.Handler) http.Handler {
@@ -86,6 +447,16 @@ func (c *apiConfig) showFileSrvHits(w http.ResponseWriter, _ *http.Request) {
 	w.Write(responseData)
 }
 
+func jwksHandler(w http.ResponseWriter, _ *http.Request) {
+	w.Header().Set("content-type", "application/json")
+	w.Header().Set("cache-control", "public, max-age=300")
+	errEncode := json.NewEncoder(w).Encode(c.jwtKeyring.JWKS())
+	if errEncode != nil {
+		fmt.Fprintf(os.Stderr, "%s", errEncode)
+		return
+	}
+}
+
 func healthzHandler(w http.ResponseWriter, _ *http.Request) {
 	w.Header().Add("content-type", "text/plain; charset=utf-8")
 	w.WriteHeader(http.StatusOK)
@@ -120,11 +491,282 @@ func validateChirp(chirpBody string) error {
 	return nil
 }
 
+// chirpInvalid responds with 400 Bad Request and the reason the chirp's body is invalid.
+func chirpInvalid(w http.ResponseWriter, chirpBody string) bool {
+	errValidateChirp := validateChirp(chirpBody)
+	if errValidateChirp == nil {
+		return false
+	}
+
+	w.Header().Set("content-type", "application/json")
+	w.WriteHeader(http.StatusBadRequest)
+	errEncode := json.NewEncoder(w).Encode(struct {
+		Error string `json:"error"`
+	}{Error: errValidateChirp.Error()})
+	if errEncode != nil {
+		fmt.Fprintf(os.Stderr, "%s", errEncode)
+	}
+	return true
+}
+
+type chirpResponse struct {
+	ID        uuid.UUID `json:"id"`
+	CreatedAt string    `json:"created_at"`
+	UpdatedAt string    `json:"updated_at"`
+	// EditedAt is null if the chirp has never been edited
+	EditedAt *string   `json:"edited_at"`
+	Body     string    `json:"body"`
+	UserID   uuid.UUID `json:"user_id"`
+	// InReplyTo is null if the chirp starts the conversation
+	InReplyTo      *uuid.UUID `json:"in_reply_to"`
+	ConversationID uuid.UUID  `json:"conversation_id"`
+	ReplyCount     int32      `json:"reply_count"`
+	LikeCount      int32      `json:"like_count"`
+	// LikedByMe is only set for the authenticated users
+	LikedByMe *bool `json:"liked_by_me,omitempty"`
+	// RechirpOf is the original chirp of the rechirp, QuoteOf is the chirp the quote chirp wraps
+	RechirpOf    *uuid.UUID `json:"rechirp_of"`
+	QuoteOf      *uuid.UUID `json:"quote_of"`
+	RechirpCount int32      `json:"rechirp_count"`
+	QuoteCount   int32      `json:"quote_count"`
+	// ReferencedChirp is the embedded chirp of RechirpOf or QuoteOf. It's null if that chirp is deleted
+	ReferencedChirp *chirpResponse `json:"referenced_chirp"`
+}
+
+func newChirpResponse(chirp database.Chirp) chirpResponse {
+	response := chirpResponse{
+		ID:             chirp.ID,
+		CreatedAt:      chirp.CreatedAt.Format(time.RFC3339),
+		UpdatedAt:      chirp.UpdatedAt.Format(time.RFC3339),
+		EditedAt:       formatNullTime(chirp.EditedAt),
+		Body:           chirp.Body,
+		UserID:         chirp.UserID,
+		ConversationID: chirp.ConversationID,
+		ReplyCount:     chirp.ReplyCount,
+		LikeCount:      chirp.LikeCount,
+		RechirpCount:   chirp.RechirpCount,
+		QuoteCount:     chirp.QuoteCount,
+	}
+	if chirp.InReplyTo.Valid {
+		response.InReplyTo = &chirp.InReplyTo.UUID
+	}
+	if chirp.RechirpOf.Valid {
+		response.RechirpOf = &chirp.RechirpOf.UUID
+	}
+	if chirp.QuoteOf.Valid {
+		response.QuoteOf = &chirp.QuoteOf.UUID
+	}
+	return response
+}
+
+// referencedChirpID returns the id of the chirp the rechirp or the quote chirp shares.
+func (response chirpResponse) referencedChirpID() (uuid.UUID, bool) {
+	switch {
+	case response.RechirpOf != nil:
+		return *response.RechirpOf, true
+	case response.QuoteOf != nil:
+		return *response.QuoteOf, true
+	}
+	return uuid.Nil, false
+}
+
+// selectOriginalChirp selects the chirp or, if it's a rechirp, its original.
+// It returns errChirpNotFound if either of them doesn't exist or is deleted.
+func selectOriginalChirp(ctx context.Context, chirpID uuid.UUID) (database.Chirp, error) {
+	selectedChirp, err := c.dbQueries.SelectChirp(ctx, chirpID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return database.Chirp{}, errChirpNotFound
+		}
+		return database.Chirp{}, err
+	}
+	if selectedChirp.DeletedAt.Valid {
+		return database.Chirp{}, errChirpNotFound
+	}
+	// the rechirps share the originals only, so it's one level deep
+	if selectedChirp.RechirpOf.Valid {
+		return selectOriginalChirp(ctx, selectedChirp.RechirpOf.UUID)
+	}
+	return selectedChirp, nil
+}
+
+// selectReferencedChirp selects the chirp the new chirp replies to or quotes. A rechirp is replaced with its original.
+// It responds with 400 Bad Request and the message if the chirp doesn't exist or is deleted.
+func selectReferencedChirp(w http.ResponseWriter, ctx context.Context, chirpID uuid.UUID, message string) (database.Chirp, bool) {
+	selectedChirp, errSelectChirp := selectOriginalChirp(ctx, chirpID)
+	if errSelectChirp != nil && !errors.Is(errSelectChirp, errChirpNotFound) {
+		w.WriteHeader(http.StatusInternalServerError)
+		return database.Chirp{}, false
+	}
+	if errSelectChirp != nil {
+		w.Header().Set("content-type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		errEncode := json.NewEncoder(w).Encode(struct {
+			Error string `json:"error"`
+		}{Error: message})
+		if errEncode != nil {
+			fmt.Fprintf(os.Stderr, "%s", errEncode)
+		}
+		return database.Chirp{}, false
+	}
+	return selectedChirp, true
+}
+
+// viewerID returns the id of the user the request to the public endpoint is authenticated as.
+// The request without a valid access token is anonymous. The OAuth clients and personal access tokens need the chirps:read scope.
+func viewerID(r *http.Request) uuid.UUID {
+	token, _, errRequestToken := requestToken(r, accessTokenCookieName)
+	if errRequestToken != nil || token == "" {
+		return uuid.Nil
+	}
+	userClaims, errValidateAccessToken := validateAccessToken(r.Context(), token)
+	if errValidateAccessToken != nil {
+		return uuid.Nil
+	}
+	if !userClaims.FirstParty() && !auth.HasScopes(userClaims.Scope, auth.ScopeChirpsRead) {
+		return uuid.Nil
+	}
+	userID, _ := userClaims.UserID()
+	return userID
+}
+
+// completeChirpResponses embeds the chirps' referenced chirps and sets whether the viewer likes all of them.
+func completeChirpResponses(ctx context.Context, viewerID uuid.UUID, chirps ...*chirpResponse) error {
+	referencedChirps, err := embedReferencedChirps(ctx, chirps...)
+	if err != nil {
+		return err
+	}
+	return setLikedByMe(ctx, viewerID, slices.Concat(chirps, referencedChirps)...)
+}
+
+// embedReferencedChirps embeds the chirps the rechirps and the quote chirps share and returns them.
+// The deleted chirps and the chirps of the deleted users stay null. The embedded chirps don't embed theirs.
+func embedReferencedChirps(ctx context.Context, chirps ...*chirpResponse) ([]*chirpResponse, error) {
+	var referencedChirpIDs []uuid.UUID
+	for _, chirp := range chirps {
+		if referencedChirpID, ok := chirp.referencedChirpID(); ok {
+			referencedChirpIDs = append(referencedChirpIDs, referencedChirpID)
+		}
+	}
+	if len(referencedChirpIDs) == 0 {
+		return nil, nil
+	}
+
+	selectedChirps, err := c.dbQueries.SelectVisibleChirpsByIDs(ctx, referencedChirpIDs)
+	if err != nil {
+		return nil, err
+	}
+	referencedChirps := make(map[uuid.UUID]database.Chirp, len(selectedChirps))
+	for _, selectedChirp := range selectedChirps {
+		referencedChirps[selectedChirp.ID] = selectedChirp
+	}
+
+	var embeddedChirps []*chirpResponse
+	for _, chirp := range chirps {
+		referencedChirpID, _ := chirp.referencedChirpID()
+		if referencedChirp, ok := referencedChirps[referencedChirpID]; ok {
+			embeddedChirp := newChirpResponse(referencedChirp)
+			chirp.ReferencedChirp = &embeddedChirp
+			embeddedChirps = append(embeddedChirps, &embeddedChirp)
+		}
+	}
+	return embeddedChirps, nil
+}
+
+// setLikedByMe sets whether the viewer likes the chirps. The anonymous viewer's uuid.Nil leaves them unset.
+func setLikedByMe(ctx context.Context, viewerID uuid.UUID, chirps ...*chirpResponse) error {
+	if viewerID == uuid.Nil || len(chirps) == 0 {
+		return nil
+	}
+
+	chirpIDs := make([]uuid.UUID, len(chirps))
+	for i, chirp := range chirps {
+		chirpIDs[i] = chirp.ID
+	}
+	likedChirpIDs, err := c.dbQueries.SelectLikedChirpIDs(ctx, database.SelectLikedChirpIDsParams{
+		UserID:   viewerID,
+		ChirpIds: chirpIDs,
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, chirp := range chirps {
+		likedByMe := slices.Contains(likedChirpIDs, chirp.ID)
+		chirp.LikedByMe = &likedByMe
+	}
+	return nil
+}
+
+// passwordInvalid responds with 400 Bad Request and the rules of the password policy the password breaks.
+func passwordInvalid(w http.ResponseWriter, password string) bool {
+	violations, errValidate := c.passwordPolicy.Validate(password)
+	if errValidate != nil {
+		fmt.Fprintf(os.Stderr, "%s", errValidate)
+		w.WriteHeader(http.StatusInternalServerError)
+		return true
+	}
+	if len(violations) == 0 {
+		return false
+	}
+
+	w.Header().Set("content-type", "application/json")
+	w.WriteHeader(http.StatusBadRequest)
+	errEncode := json.NewEncoder(w).Encode(struct {
+		Error      string                   `json:"error"`
+		Violations []auth.PasswordViolation `json:"violations"`
+	}{
+		Error:      "password doesn't meet the password policy",
+		Violations: violations,
+	})
+	if errEncode != nil {
+		fmt.Fprintf(os.Stderr, "%s", errEncode)
+	}
+	return true
+}
+
+// emailInvalid responds with 400 Bad Request if the email isn't a bare address like lane@example.com.
+func emailInvalid(w http.ResponseWriter, email string) bool {
+	address, errParseAddress := mail.ParseAddress(email)
+	if errParseAddress == nil && address.Address == email {
+		return false
+	}
+
+	w.Header().Set("content-type", "application/json")
+	w.WriteHeader(http.StatusBadRequest)
+	errEncode := json.NewEncoder(w).Encode(struct {
+		Error string `json:"error"`
+	}{Error: "email is invalid"})
+	if errEncode != nil {
+		fmt.Fprintf(os.Stderr, "%s", errEncode)
+	}
+	return true
+}
+
+// uniqueViolation reports whether the query has violated a unique constraint, e.g. the email is taken.
+func uniqueViolation(err error) bool {
+	var pqError *pq.Error
+	return errors.As(err, &pqError) && pqError.Code == "23505"
+}
+
+// respondWithEmailTaken responds with 409 Conflict when another user has the email.
+func respondWithEmailTaken(w http.ResponseWriter) {
+	w.Header().Set("content-type", "application/json")
+	w.WriteHeader(http.StatusConflict)
+	errEncode := json.NewEncoder(w).Encode(struct {
+		Error string `json:"error"`
+	}{Error: "another user has the email"})
+	if errEncode != nil {
+		fmt.Fprintf(os.Stderr, "%s", errEncode)
+	}
+}
+
 func createUser(w http.ResponseWriter, req *http.Request) {
 
 	var reqBody struct {
-		Email    string `json:"email"`
-		Password string `json:"password"`
+		Email        string `json:"email"`
+		Password     string `json:"password"`
+		Passwordless bool   `json:"passwordless"`
 	}
 
 	decoder := json.NewDecoder(req.Body)
@@ -133,34 +775,67 @@ func createUser(w http.ResponseWriter, req *http.Request) {
 		w.WriteHeader(http.StatusBadRequest)
 		return
 	}
+	if emailInvalid(w, reqBody.Email) {
+		return
+	}
 
-	hashedPassword, errHashPassword := auth.HashPassword(reqBody.Password)
-	if errHashPassword != nil {
-		w.WriteHeader(http.StatusInternalServerError)
+	// the passwordless users opt in explicitly and log in with the sign-in links.
+	// The others' missing password is rejected by the password policy.
+	var hashedPassword string
+	if reqBody.Passwordless && reqBody.Password != "" {
+		w.Header().Set("content-type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		errEncode := json.NewEncoder(w).Encode(struct {
+			Error string `json:"error"`
+		}{Error: "passwordless user can't have a password"})
+		if errEncode != nil {
+			fmt.Fprintf(os.Stderr, "%s", errEncode)
+		}
 		return
 	}
+	if !reqBody.Passwordless {
+		if passwordInvalid(w, reqBody.Password) {
+			return
+		}
+
+		var errHashPassword error
+		hashedPassword, errHashPassword = auth.HashPasswordArgon2id(reqBody.Password, c.passwordParams)
+		if errHashPassword != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+	}
 
 	createdUser, errCreateUser := c.dbQueries.InsertUser(req.Context(), database.InsertUserParams{
-		Email: reqBody.Email,
-		HashedPassword: sql.NullString{
-			String: hashedPassword,
-			Valid:  true,
-		},
+		Email:          reqBody.Email,
+		HashedPassword: nullString(hashedPassword),
 	})
 
 	if errCreateUser != nil {
+		if uniqueViolation(errCreateUser) {
+			respondWithEmailTaken(w)
+			return
+		}
+		fmt.Fprintf(os.Stderr, "%s", errCreateUser)
 		w.WriteHeader(http.StatusInternalServerError)
 		return
 	}
 
+	errSendVerificationEmail := sendVerificationEmail(req.Context(), createdUser.ID, createdUser.Email)
+	if errSendVerificationEmail != nil {
+		// the user can ask for another email at POST /api/users/verify/resend
+		fmt.Fprintf(os.Stderr, "%s", errSendVerificationEmail)
+	}
+
 	w.Header().Set("content-type", "application/json")
 	w.WriteHeader(http.StatusCreated)
 	json.NewEncoder(w).Encode(struct {
-		ID          uuid.UUID `json:"id"`
-		CreatedAt   string    `json:"created_at"`
-		UpdatedAt   string    `json:"updated_at"`
-		Email       string    `json:"email"`
-		IsChirpyRed bool      `json:"is_chirpy_red"`
+		ID            uuid.UUID `json:"id"`
+		CreatedAt     string    `json:"created_at"`
+		UpdatedAt     string    `json:"updated_at"`
+		Email         string    `json:"email"`
+		EmailVerified bool      `json:"email_verified"`
+		IsChirpyRed   bool      `json:"is_chirpy_red"`
 	}{
 		ID:        createdUser.ID,
 		CreatedAt: createdUser.CreatedAt.Format(time.RFC3339),
@@ -172,8 +847,10 @@ func createUser(w http.ResponseWriter, req *http.Request) {
 func loginUser(w http.ResponseWriter, r *http.Request) {
 
 	var reqBody struct {
-		Email    string `json:"email"`
-		Password string `json:"password"`
+		Email      string `json:"email"`
+		Password   string `json:"password"`
+		DeviceName string `json:"device_name"`
+		UseCookies bool   `json:"use_cookies"`
 	}
 
 	errDecode := json.NewDecoder(r.Body).Decode(&reqBody)
@@ -183,14 +860,15 @@ func loginUser(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	fmt.Printf("Loggin in the user with the credentials:\n%+v", reqBody)
-	fmt.Println()
+	if loginBlocked(w, r, reqBody.Email) {
+		return
+	}
 
 	selectedUser, errSelectUserByEmail := c.dbQueries.SelectUserByEmail(r.Context(), reqBody.Email)
 
 	if errSelectUserByEmail != nil {
 		if errors.Is(errSelectUserByEmail, sql.ErrNoRows) {
-			w.WriteHeader(http.StatusUnauthorized)
+			failLogin(w, r, reqBody.Email)
 			return
 		}
 
@@ -198,402 +876,3832 @@ func loginUser(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// the users without a password log in with the sign-in links or the OpenID Connect provider
+	if !selectedUser.HashedPassword.Valid {
+		failLogin(w, r, reqBody.Email)
+		return
+	}
 	if errCheckPasswordHash := auth.CheckPasswordHash(selectedUser.HashedPassword.String, reqBody.Password); errCheckPasswordHash != nil {
-		w.WriteHeader(http.StatusUnauthorized)
+		failLogin(w, r, reqBody.Email)
 		return
 	}
 
-	selectedUserJWT, errSignUserJWT := auth.SignUserJWT(
-		selectedUser.ID,
-		c.jwtSecret,
-		time.Duration(1)*time.Hour,
-	)
-	if errSignUserJWT != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		return
+	if auth.PasswordNeedsRehash(selectedUser.HashedPassword.String, c.passwordParams) {
+		rehashPassword(r.Context(), selectedUser, reqBody.Password)
 	}
-	refreshToken, _ := auth.MakeRefreshToken()
-	errInsertRefreshToken := c.dbQueries.InsertRefreshToken(r.Context(),
-		database.InsertRefreshTokenParams{
-			Token:     refreshToken,
-			UserID:    selectedUser.ID,
-			ExpiresAt: time.Now().UTC().AddDate(0, 0, 60),
-		},
-	)
-	if errInsertRefreshToken != nil {
-		w.WriteHeader(http.StatusInternalServerError)
+	if accountDeleted(w, selectedUser) {
 		return
 	}
 
-	errEncode := json.NewEncoder(w).Encode(
-		struct {
-			ID           uuid.UUID `json:"id"`
-			CreatedAt    string    `json:"created_at"`
-			UpdatedAt    string    `json:"updated_at"`
-			Email        string    `json:"email"`
-			IsChirpyRed  bool      `json:"is_chirpy_red"`
-			Token        string    `json:"token"`
-			RefreshToken string    `json:"refresh_token"`
-		}{
-			ID:           selectedUser.ID,
-			CreatedAt:    selectedUser.CreatedAt.Format(time.RFC3339),
-			UpdatedAt:    selectedUser.UpdatedAt.Format(time.RFC3339),
-			Email:        selectedUser.Email,
-			IsChirpyRed:  selectedUser.IsChirpyRed,
-			Token:        selectedUserJWT,
-			RefreshToken: refreshToken,
-		},
-	)
-	if errEncode != nil {
-		fmt.Fprintf(os.Stderr, "%s", errEncode)
+	if selectedUser.TotpEnabledAt.Valid {
+		respondWithMFAChallenge(w, selectedUser.ID)
 		return
 	}
+
+	respondWithLogin(w, r, selectedUser, reqBody.DeviceName, reqBody.UseCookies)
 }
 
-func setUserIsChirpyRed(w http.ResponseWriter, r *http.Request) {
+// rehashPassword upgrades the user's hashed password to the current scheme and params.
+// A failed upgrade doesn't fail the login: it's retried at the next one.
+func rehashPassword(ctx context.Context, selectedUser database.User, password string) {
+	hashedPassword, errHashPassword := auth.HashPasswordArgon2id(password, c.passwordParams)
+	if errHashPassword != nil {
+		fmt.Fprintf(os.Stderr, "%s", errHashPassword)
+		return
+	}
+
+	// the hash is replaced only if the password hasn't been changed concurrently
+	errRehashUserPassword := c.dbQueries.RehashUserPassword(ctx, database.RehashUserPasswordParams{
+		NewHashedPassword: nullString(hashedPassword),
+		ID:                selectedUser.ID,
+		OldHashedPassword: selectedUser.HashedPassword,
+	})
+	if errRehashUserPassword != nil {
+		fmt.Fprintf(os.Stderr, "%s", errRehashUserPassword)
+	}
+}
+
+func loginEmailKey(email string) string {
+	return "email:" + strings.ToLower(strings.TrimSpace(email))
+}
+
+func loginIPKey(ip string) string {
+	return "ip:" + ip
+}
+
+// loginBlocked responds with 429 Too Many Requests if the logins with the email or from the client's IP
+// are blocked after the failures.
+func loginBlocked(w http.ResponseWriter, r *http.Request, email string) bool {
+	emailRetryAfter, errEmailRetryAfter := c.emailLoginLimiter.RetryAfter(r.Context(), loginEmailKey(email))
+	if errEmailRetryAfter != nil {
+		fmt.Fprintf(os.Stderr, "%s", errEmailRetryAfter)
+		w.WriteHeader(http.StatusInternalServerError)
+		return true
+	}
+	ipRetryAfter, errIPRetryAfter := c.ipLoginLimiter.RetryAfter(r.Context(), loginIPKey(clientIP(r)))
+	if errIPRetryAfter != nil {
+		fmt.Fprintf(os.Stderr, "%s", errIPRetryAfter)
+		w.WriteHeader(http.StatusInternalServerError)
+		return true
+	}
+
+	retryAfter := max(emailRetryAfter, ipRetryAfter)
+	if retryAfter == 0 {
+		return false
+	}
+	w.Header().Set("retry-after", strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))))
+	w.WriteHeader(http.StatusTooManyRequests)
+	return true
+}
+
+// failLogin records the failed login with the email and from the client's IP
+// and responds with 401 Unauthorized.
+func failLogin(w http.ResponseWriter, r *http.Request, email string) {
+	recordFailedLogin(r, email)
+	w.WriteHeader(http.StatusUnauthorized)
+}
+
+func recordFailedLogin(r *http.Request, email string) {
+	if _, err := c.emailLoginLimiter.Fail(r.Context(), loginEmailKey(email)); err != nil {
+		fmt.Fprintf(os.Stderr, "%s", err)
+	}
+	if _, err := c.ipLoginLimiter.Fail(r.Context(), loginIPKey(clientIP(r))); err != nil {
+		fmt.Fprintf(os.Stderr, "%s", err)
+	}
+
+	// the failure is in the security events of the user whose email it is
+	var userID uuid.UUID
+	selectedUser, errSelectUserByEmail := c.dbQueries.SelectUserByEmail(r.Context(), email)
+	if errSelectUserByEmail == nil {
+		userID = selectedUser.ID
+	}
+	recordAuditEvent(r, auditEvent{
+		eventType: auditLoginFailed,
+		userID:    userID,
+		email:     email,
+		details:   map[string]string{"endpoint": r.URL.Path},
+	})
+}
+
+// respondWithLogin starts the user's session and responds with the user, the access JWT and the refresh token.
+// With useCookies the tokens are set in the session cookies for the web app and it responds with the CSRF token instead.
+func respondWithLogin(w http.ResponseWriter, r *http.Request, selectedUser database.User, deviceName string, useCookies bool) {
+	if useCookies && !jsonRequest(r) {
+		w.WriteHeader(http.StatusUnsupportedMediaType)
+		return
+	}
+
+	// the IP isn't reset so logging in the own account doesn't unblock guessing the others
+	if err := c.emailLoginLimiter.Reset(r.Context(), loginEmailKey(selectedUser.Email)); err != nil {
+		fmt.Fprintf(os.Stderr, "%s", err)
+	}
+
+	familyID := uuid.New()
+	selectedUserJWT, errSignUserJWT := signSessionJWT(selectedUser, familyID)
+	if errSignUserJWT != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	refreshToken, _ := auth.MakeRefreshToken()
+	errInsertRefreshToken := c.dbQueries.InsertRefreshToken(r.Context(),
+		database.InsertRefreshTokenParams{
+			TokenHash:  auth.HashRefreshToken(refreshToken, c.refreshTokenHashKey),
+			UserID:     selectedUser.ID,
+			ExpiresAt:  time.Now().UTC().AddDate(0, 0, 60),
+			FamilyID:   familyID,
+			StartedAt:  time.Now().UTC(),
+			UserAgent:  nullString(r.UserAgent()),
+			IpAddress:  nullString(clientIP(r)),
+			DeviceName: nullString(deviceName),
+		},
+	)
+	if errInsertRefreshToken != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	recordAuditEvent(r, auditEvent{
+		eventType: auditLoginSucceeded,
+		actorID:   selectedUser.ID,
+		userID:    selectedUser.ID,
+		email:     selectedUser.Email,
+		details:   map[string]string{"endpoint": r.URL.Path, "session_id": familyID.String()},
+	})
+
+	var csrfToken string
+	if useCookies {
+		csrfToken = setSessionCookies(w, selectedUserJWT, refreshToken, familyID)
+		selectedUserJWT, refreshToken = "", ""
+	}
+
+	errEncode := json.NewEncoder(w).Encode(
+		struct {
+			ID            uuid.UUID `json:"id"`
+			CreatedAt     string    `json:"created_at"`
+			UpdatedAt     string    `json:"updated_at"`
+			Email         string    `json:"email"`
+			EmailVerified bool      `json:"email_verified"`
+			IsChirpyRed   bool      `json:"is_chirpy_red"`
+			Token         string    `json:"token,omitempty"`
+			RefreshToken  string    `json:"refresh_token,omitempty"`
+			CSRFToken     string    `json:"csrf_token,omitempty"`
+		}{
+			ID:            selectedUser.ID,
+			CreatedAt:     selectedUser.CreatedAt.Format(time.RFC3339),
+			UpdatedAt:     selectedUser.UpdatedAt.Format(time.RFC3339),
+			Email:         selectedUser.Email,
+			EmailVerified: selectedUser.EmailVerifiedAt.Valid,
+			IsChirpyRed:   selectedUser.IsChirpyRed,
+			Token:         selectedUserJWT,
+			RefreshToken:  refreshToken,
+			CSRFToken:     csrfToken,
+		},
+	)
+	if errEncode != nil {
+		fmt.Fprintf(os.Stderr, "%s", errEncode)
+		return
+	}
+}
+
+// respondWithMFAChallenge responds with the token the user exchanges for the login with the second factor
+// at POST /api/login/mfa.
+func respondWithMFAChallenge(w http.ResponseWriter, userID uuid.UUID) {
+	mfaToken, errSignMFAChallenge := c.jwtKeyring.SignMFAChallenge(userID, time.Duration(5)*time.Minute)
+	if errSignMFAChallenge != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	errEncode := json.NewEncoder(w).Encode(struct {
+		MFARequired bool   `json:"mfa_required"`
+		MFAToken    string `json:"mfa_token"`
+	}{
+		MFARequired: true,
+		MFAToken:    mfaToken,
+	})
+	if errEncode != nil {
+		fmt.Fprintf(os.Stderr, "%s", errEncode)
+		return
+	}
+}
+
+func loginMFAHandler(w http.ResponseWriter, r *http.Request) {
 	var reqBody struct {
-		Event string `json:"event"`
-		Data  struct {
-			UserId uuid.UUID `json:"user_id"`
-		} `json:"data"`
+		MFAToken     string `json:"mfa_token"`
+		Code         string `json:"code"`
+		RecoveryCode string `json:"recovery_code"`
+		DeviceName   string `json:"device_name"`
+		UseCookies   bool   `json:"use_cookies"`
 	}
 
-	apiKey, errGetApiKey := auth.GetApiKey(&r.Header)
-	if errGetApiKey != nil {
+	errDecode := json.NewDecoder(r.Body).Decode(&reqBody)
+	if errDecode != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	userID, errValidateMFAChallenge := c.jwtKeyring.ValidateMFAChallenge(reqBody.MFAToken)
+	if errValidateMFAChallenge != nil {
 		w.WriteHeader(http.StatusUnauthorized)
 		return
 	}
-	if apiKey != c.polkaApiKey {
+
+	selectedUser, errSelectUserByID := c.dbQueries.SelectUserByID(r.Context(), userID)
+	if errSelectUserByID != nil {
+		if errors.Is(errSelectUserByID, sql.ErrNoRows) {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	if !selectedUser.TotpEnabledAt.Valid {
 		w.WriteHeader(http.StatusUnauthorized)
 		return
 	}
+	if loginBlocked(w, r, selectedUser.Email) {
+		return
+	}
+	if accountDeleted(w, selectedUser) {
+		return
+	}
+
+	switch {
+	case reqBody.Code != "":
+		step, errValidateTOTP := auth.ValidateTOTP(selectedUser.TotpSecret.String, reqBody.Code, time.Now())
+		if errValidateTOTP != nil {
+			failLogin(w, r, selectedUser.Email)
+			return
+		}
+		// the step is updated only if it's later than the last used one so every code logs in once
+		updatedRows, errUpdateUserTOTPLastStep := c.dbQueries.UpdateUserTOTPLastStep(r.Context(), database.UpdateUserTOTPLastStepParams{
+			ID:           selectedUser.ID,
+			TotpLastStep: sql.NullInt64{Int64: step, Valid: true},
+		})
+		if errUpdateUserTOTPLastStep != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		if updatedRows == 0 {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+	case reqBody.RecoveryCode != "":
+		usedRows, errUseRecoveryCode := c.dbQueries.UseRecoveryCode(r.Context(), database.UseRecoveryCodeParams{
+			UserID:   selectedUser.ID,
+			CodeHash: auth.HashRecoveryCode(reqBody.RecoveryCode),
+		})
+		if errUseRecoveryCode != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		if usedRows == 0 {
+			failLogin(w, r, selectedUser.Email)
+			return
+		}
+	default:
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	respondWithLogin(w, r, selectedUser, reqBody.DeviceName, reqBody.UseCookies)
+}
 
+// startMagicLinkLoginHandler emails the user a single-use sign-in link.
+// The response is the same whether the user exists or not so the endpoint doesn't reveal the registered emails.
+func startMagicLinkLoginHandler(w http.ResponseWriter, r *http.Request) {
+	const (
+		magicLinkTTL      = time.Duration(15) * time.Minute
+		magicLinksPerHour = 5
+	)
+
+	var reqBody struct {
+		Email string `json:"email"`
+	}
 	errDecode := json.NewDecoder(r.Body).Decode(&reqBody)
-	if errDecode != nil {
+	if errDecode != nil || reqBody.Email == "" {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	if loginBlocked(w, r, reqBody.Email) {
+		return
+	}
+
+	selectedUser, errSelectUserByEmail := c.dbQueries.SelectUserByEmail(r.Context(), reqBody.Email)
+	if errSelectUserByEmail != nil {
+		if !errors.Is(errSelectUserByEmail, sql.ErrNoRows) {
+			fmt.Fprintf(os.Stderr, "%s", errSelectUserByEmail)
+		}
+		w.WriteHeader(http.StatusAccepted)
+		return
+	}
+	if selectedUser.DeletedAt.Valid {
+		w.WriteHeader(http.StatusAccepted)
+		return
+	}
+
+	// the links over the limit aren't sent so nobody floods the user's inbox
+	sentCount, errCount := c.dbQueries.CountUserMagicLinkTokensSince(r.Context(), database.CountUserMagicLinkTokensSinceParams{
+		UserID:    selectedUser.ID,
+		CreatedAt: time.Now().UTC().Add(-time.Hour),
+	})
+	if errCount != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	if sentCount >= magicLinksPerHour {
+		w.WriteHeader(http.StatusAccepted)
+		return
+	}
+
+	magicLinkToken, _ := auth.MakeToken()
+	errInsertMagicLinkToken := c.dbQueries.InsertMagicLinkToken(r.Context(), database.InsertMagicLinkTokenParams{
+		TokenHash: auth.HashToken(magicLinkToken),
+		UserID:    selectedUser.ID,
+		Email:     selectedUser.Email,
+		ExpiresAt: time.Now().UTC().Add(magicLinkTTL),
+	})
+	if errInsertMagicLinkToken != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	errSend := c.mailer.Send(r.Context(), mailer.Message{
+		To:      selectedUser.Email,
+		Subject: "Sign in to Chirpy",
+		Body: fmt.Sprintf("Someone has asked to sign in to your Chirpy account.\n\n"+
+			"To sign in follow the link within %d minutes. It works once:\n%s/app/login/magic/?token=%s\n\n"+
+			"If it wasn't you, ignore this email. Nobody can sign in without the link.\n",
+			int(magicLinkTTL.Minutes()), c.baseURL, magicLinkToken,
+		),
+	})
+	if errSend != nil {
+		fmt.Fprintf(os.Stderr, "%s", errSend)
+	}
+
+	w.WriteHeader(http.StatusAccepted)
+}
+
+// magicLinkCallbackHandler exchanges the token of the sign-in link for the login.
+// Following the link proves the user owns the email, so the email is verified too.
+func magicLinkCallbackHandler(w http.ResponseWriter, r *http.Request) {
+	var reqBody struct {
+		Token      string `json:"token"`
+		DeviceName string `json:"device_name"`
+		UseCookies bool   `json:"use_cookies"`
+	}
+	errDecode := json.NewDecoder(r.Body).Decode(&reqBody)
+	if errDecode != nil || reqBody.Token == "" {
 		w.WriteHeader(http.StatusBadRequest)
 		return
 	}
 
-	if reqBody.Event == "user.upgraded" {
+	magicLinkToken, errUseMagicLinkToken := c.dbQueries.UseMagicLinkToken(r.Context(), auth.HashToken(reqBody.Token))
+	if errUseMagicLinkToken != nil {
+		if errors.Is(errUseMagicLinkToken, sql.ErrNoRows) {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
 
-		if err := c.dbQueries.SetUserIsChirpyRed(r.Context(),
-			database.SetUserIsChirpyRedParams{ID: reqBody.Data.UserId, IsChirpyRed: true}); err != nil {
-			if errors.Is(err, sql.ErrNoRows) {
-				w.WriteHeader(http.StatusNotFound)
-				return
-			}
+	selectedUser, errSelectUserByID := c.dbQueries.SelectUserByID(r.Context(), magicLinkToken.UserID)
+	if errSelectUserByID != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	// the link sent to the user's previous email doesn't log in
+	if selectedUser.Email != magicLinkToken.Email {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	if accountDeleted(w, selectedUser) {
+		return
+	}
+
+	if !selectedUser.EmailVerifiedAt.Valid {
+		_, errVerifyUserEmail := c.dbQueries.VerifyUserEmail(r.Context(), database.VerifyUserEmailParams{
+			ID:    selectedUser.ID,
+			Email: selectedUser.Email,
+		})
+		if errVerifyUserEmail != nil {
 			w.WriteHeader(http.StatusInternalServerError)
 			return
 		}
+		selectedUser.EmailVerifiedAt = sql.NullTime{Time: time.Now().UTC(), Valid: true}
+	}
+
+	if selectedUser.TotpEnabledAt.Valid {
+		respondWithMFAChallenge(w, selectedUser.ID)
+		return
+	}
+	respondWithLogin(w, r, selectedUser, reqBody.DeviceName, reqBody.UseCookies)
+}
+
+func enrollTOTPHandler(w http.ResponseWriter, r *http.Request, userID uuid.UUID) {
+	selectedUser, errSelectUserByID := c.dbQueries.SelectUserByID(r.Context(), userID)
+	if errSelectUserByID != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	if selectedUser.TotpEnabledAt.Valid {
+		w.WriteHeader(http.StatusConflict)
+		return
+	}
+
+	totpSecret, errGenerateTOTPSecret := auth.GenerateTOTPSecret()
+	if errGenerateTOTPSecret != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	updatedRows, errSetUserTOTPSecret := c.dbQueries.SetUserTOTPSecret(r.Context(), database.SetUserTOTPSecretParams{
+		ID:         userID,
+		TotpSecret: nullString(totpSecret),
+	})
+	if errSetUserTOTPSecret != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	if updatedRows == 0 {
+		w.WriteHeader(http.StatusConflict)
+		return
+	}
+
+	w.Header().Set("content-type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	errEncode := json.NewEncoder(w).Encode(struct {
+		Secret          string `json:"secret"`
+		ProvisioningURI string `json:"provisioning_uri"`
+	}{
+		Secret:          totpSecret,
+		ProvisioningURI: auth.TOTPProvisioningURI(totpSecret, totpIssuer, selectedUser.Email),
+	})
+	if errEncode != nil {
+		fmt.Fprintf(os.Stderr, "%s", errEncode)
+		return
+	}
+}
+
+func confirmTOTPHandler(w http.ResponseWriter, r *http.Request, userID uuid.UUID) {
+	var reqBody struct {
+		Code string `json:"code"`
+	}
+
+	errDecode := json.NewDecoder(r.Body).Decode(&reqBody)
+	if errDecode != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	selectedUser, errSelectUserByID := c.dbQueries.SelectUserByID(r.Context(), userID)
+	if errSelectUserByID != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	if selectedUser.TotpEnabledAt.Valid {
+		w.WriteHeader(http.StatusConflict)
+		return
+	}
+	if !selectedUser.TotpSecret.Valid {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	step, errValidateTOTP := auth.ValidateTOTP(selectedUser.TotpSecret.String, reqBody.Code, time.Now())
+	if errValidateTOTP != nil {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	recoveryCodes, errEnableTOTP := enableTOTP(r.Context(), userID, step)
+	if errEnableTOTP != nil {
+		fmt.Fprintf(os.Stderr, "%s", errEnableTOTP)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	recordAuditEvent(r, auditEvent{eventType: auditMFAEnabled, userID: userID})
+
+	w.Header().Set("content-type", "application/json")
+	errEncode := json.NewEncoder(w).Encode(struct {
+		RecoveryCodes []string `json:"recovery_codes"`
+	}{
+		RecoveryCodes: recoveryCodes,
+	})
+	if errEncode != nil {
+		fmt.Fprintf(os.Stderr, "%s", errEncode)
+		return
+	}
+}
+
+// enableTOTP enables the confirmed TOTP secret and replaces the user's recovery codes.
+// Only the hashes of the recovery codes are stored so they are returned once.
+func enableTOTP(ctx context.Context, userID uuid.UUID, step int64) ([]string, error) {
+	const recoveryCodesNumber = 10
+
+	recoveryCodes, err := auth.GenerateRecoveryCodes(recoveryCodesNumber)
+	if err != nil {
+		return nil, err
+	}
+
+	tx, err := c.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+	qtx := c.dbQueries.WithTx(tx)
+
+	err = qtx.EnableUserTOTP(ctx, database.EnableUserTOTPParams{
+		ID:           userID,
+		TotpLastStep: sql.NullInt64{Int64: step, Valid: true},
+	})
+	if err != nil {
+		return nil, err
+	}
+	if err := qtx.DeleteUserRecoveryCodes(ctx, userID); err != nil {
+		return nil, err
+	}
+	for _, recoveryCode := range recoveryCodes {
+		err := qtx.InsertRecoveryCode(ctx, database.InsertRecoveryCodeParams{
+			UserID:   userID,
+			CodeHash: auth.HashRecoveryCode(recoveryCode),
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return recoveryCodes, tx.Commit()
+}
+
+// The headers of the signed Polka webhooks
+const (
+	polkaTimestampHeader = "polka-timestamp"
+	polkaSignatureHeader = "polka-signature"
+)
+
+var errWebhookEventReplayed = errors.New("error webhook event has already been processed")
+
+// setUserIsChirpyRed authenticates the Polka webhook by its signature
+// or, in the legacy mode, by the API key if the webhook isn't signed.
+func setUserIsChirpyRed(w http.ResponseWriter, r *http.Request) {
+	var reqBody struct {
+		ID    string `json:"id"`
+		Event string `json:"event"`
+		Data  struct {
+			UserId uuid.UUID `json:"user_id"`
+		} `json:"data"`
+	}
+
+	body, errReadAll := io.ReadAll(io.LimitReader(r.Body, 1<<20))
+	if errReadAll != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	signed := r.Header.Get(polkaSignatureHeader) != ""
+	switch {
+	case signed && c.polkaWebhookVerifier.Enabled():
+		errVerify := c.polkaWebhookVerifier.Verify(body, r.Header.Get(polkaTimestampHeader), r.Header.Get(polkaSignatureHeader))
+		if errVerify != nil {
+			fmt.Fprintf(os.Stderr, "%s\n", errVerify)
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+	case !signed && c.polkaApiKey != "":
+		apiKey, errGetApiKey := auth.GetApiKey(&r.Header)
+		if errGetApiKey != nil {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		if subtle.ConstantTimeCompare([]byte(apiKey), []byte(c.polkaApiKey)) != 1 {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+	default:
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	errUnmarshal := json.Unmarshal(body, &reqBody)
+	if errUnmarshal != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	// the signed webhooks are replayed within the tolerance window unless their ids are checked
+	if signed && reqBody.ID == "" {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	if reqBody.Event == "user.upgraded" {
+		err := upgradeUserToChirpyRed(r.Context(), reqBody.ID, reqBody.Data.UserId)
+		if err != nil {
+			// Polka retries the webhooks until they are responded with 2xx
+			if errors.Is(err, errWebhookEventReplayed) {
+				w.WriteHeader(http.StatusNoContent)
+				return
+			}
+			if errors.Is(err, sql.ErrNoRows) {
+				w.WriteHeader(http.StatusNotFound)
+				return
+			}
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		recordAuditEvent(r, auditEvent{
+			eventType: auditChirpyRedUpgraded,
+			userID:    reqBody.Data.UserId,
+			details:   map[string]string{"event_id": reqBody.ID},
+		})
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// upgradeUserToChirpyRed records the webhook event and upgrades the user.
+// It returns sql.ErrNoRows if there is no such user.
+// The legacy unsigned webhooks may have no event id, then they aren't recorded.
+func upgradeUserToChirpyRed(ctx context.Context, eventID string, userID uuid.UUID) error {
+	tx, err := c.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	qtx := c.dbQueries.WithTx(tx)
+
+	if eventID != "" {
+		insertedRows, err := qtx.InsertWebhookEvent(ctx, eventID)
+		if err != nil {
+			return err
+		}
+		if insertedRows == 0 {
+			return errWebhookEventReplayed
+		}
+	}
+	updatedRows, err := qtx.SetUserIsChirpyRed(ctx, database.SetUserIsChirpyRedParams{ID: userID, IsChirpyRed: true})
+	if err != nil {
+		return err
+	}
+	// the unknown user's event isn't recorded
+	if updatedRows == 0 {
+		return sql.ErrNoRows
+	}
+	return tx.Commit()
+}
+
+func createChirp(w http.ResponseWriter, r *http.Request) {
+	bearerToken, fromCookie, errRequestToken := requestToken(r, accessTokenCookieName)
+	if errRequestToken != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	userClaims, errValidateAccessToken := validateAccessToken(r.Context(), bearerToken)
+	if errValidateAccessToken != nil {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	userID, errUserID := userClaims.UserID()
+	if errUserID != nil {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	if fromCookie && !csrfProtected(r, userClaims.SessionID) {
+		w.WriteHeader(http.StatusForbidden)
+		return
+	}
+	if insufficientScope(w, userClaims, auth.ScopeChirpsWrite) {
+		return
+	}
+
+	if emailUnverified(w, r.Context(), userID) {
+		return
+	}
+
+	var reqBody struct {
+		Body      string    `json:"body"`
+		InReplyTo uuid.UUID `json:"in_reply_to"`
+		QuoteOf   uuid.UUID `json:"quote_of"`
+	}
+	errDecode := json.NewDecoder(r.Body).Decode(&reqBody)
+	if errDecode != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	if chirpInvalid(w, reqBody.Body) {
+		return
+	}
+
+	// the chirp starts its own conversation unless it replies to a chirp of one
+	chirpID := uuid.New()
+	conversationID := chirpID
+	if reqBody.InReplyTo != uuid.Nil {
+		parentChirp, ok := selectReferencedChirp(w, r.Context(), reqBody.InReplyTo, "the chirp to reply to doesn't exist")
+		if !ok {
+			return
+		}
+		reqBody.InReplyTo = parentChirp.ID
+		conversationID = parentChirp.ConversationID
+	}
+	if reqBody.QuoteOf != uuid.Nil {
+		quotedChirp, ok := selectReferencedChirp(w, r.Context(), reqBody.QuoteOf, "the chirp to quote doesn't exist")
+		if !ok {
+			return
+		}
+		reqBody.QuoteOf = quotedChirp.ID
+	}
+
+	createdChirp, errCreateChirp := c.dbQueries.CreateChirp(r.Context(), database.CreateChirpParams{
+		ID:             chirpID,
+		Body:           cleanInput(reqBody.Body),
+		UserID:         userID,
+		InReplyTo:      nullUUID(reqBody.InReplyTo),
+		ConversationID: conversationID,
+		QuoteOf:        nullUUID(reqBody.QuoteOf),
+	})
+
+	if errCreateChirp != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	chirp := newChirpResponse(createdChirp)
+	if err := completeChirpResponses(r.Context(), userID, &chirp); err != nil {
+		fmt.Fprintf(os.Stderr, "%s", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("content-type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	errEncode := json.NewEncoder(w).Encode(chirp)
+	if errEncode != nil {
+		fmt.Fprintf(os.Stderr, "%s", errEncode)
+		return
+	}
+}
+
+// chirpsPage is the page of the chirps the client asks for with the limit and cursor query parameters.
+type chirpsPage struct {
+	limit           int
+	cursorCreatedAt sql.NullTime
+	cursorID        uuid.NullUUID
+}
+
+// rowLimit selects one more chirp than the limit to tell whether there's the next page.
+func (page chirpsPage) rowLimit() int32 {
+	return int32(page.limit + 1)
+}
+
+func parseChirpsPage(query url.Values) (chirpsPage, error) {
+	const (
+		defaultLimit = 20
+		maxLimit     = 100
+	)
+
+	limit, err := pagination.ParseLimit(query.Get("limit"), defaultLimit, maxLimit)
+	if err != nil {
+		return chirpsPage{}, err
+	}
+	page := chirpsPage{limit: limit}
+	if encodedCursor := query.Get("cursor"); encodedCursor != "" {
+		cursor, err := pagination.DecodeCursor(encodedCursor)
+		if err != nil {
+			return chirpsPage{}, err
+		}
+		page.cursorCreatedAt = sql.NullTime{Time: cursor.CreatedAt, Valid: true}
+		page.cursorID = nullUUID(cursor.ID)
+	}
+	return page, nil
+}
+
+// chirpCursor is the cursor of the chirps sorted by their creation time.
+func chirpCursor(chirp database.Chirp) pagination.Cursor {
+	return pagination.Cursor{CreatedAt: chirp.CreatedAt, ID: chirp.ID}
+}
+
+// respondWithChirpsPage responds with the page of the selected chirps as a JSON array.
+// The next page after the page's last chirp is linked in the Link header so the array stays the body the clients expect.
+func respondWithChirpsPage(w http.ResponseWriter, r *http.Request, page chirpsPage, selectedChirps []database.Chirp, cursorOf func(database.Chirp) pagination.Cursor) {
+	if len(selectedChirps) > page.limit {
+		selectedChirps = selectedChirps[:page.limit]
+
+		nextQueryParams := r.URL.Query()
+		nextQueryParams.Set("cursor", cursorOf(selectedChirps[page.limit-1]).Encode())
+		w.Header().Set("link", fmt.Sprintf(`<%s?%s>; rel="next"`, r.URL.Path, nextQueryParams.Encode()))
+	}
+
+	chirps := make([]chirpResponse, len(selectedChirps))
+	chirpPointers := make([]*chirpResponse, len(selectedChirps))
+	for i, v := range selectedChirps {
+		chirps[i] = newChirpResponse(v)
+		chirpPointers[i] = &chirps[i]
+	}
+	if err := completeChirpResponses(r.Context(), viewerID(r), chirpPointers...); err != nil {
+		fmt.Fprintf(os.Stderr, "%s", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("content-type", "application/json")
+	errEncode := json.NewEncoder(w).Encode(chirps)
+	if errEncode != nil {
+		fmt.Fprintf(os.Stderr, "%s", errEncode)
+		return
+	}
+}
+
+// getChirps responds with a page of the chirps sorted by the creation time, the oldest first by default.
+// The next page starts after the opaque cursor of the previous one. It's linked in the Link header.
+func getChirps(w http.ResponseWriter, r *http.Request) {
+	queryParams := r.URL.Query()
+	page, errParseChirpsPage := parseChirpsPage(queryParams)
+	if errParseChirpsPage != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	params := database.SelectChirpsParams{
+		CursorCreatedAt: page.cursorCreatedAt,
+		CursorID:        page.cursorID,
+		RowLimit:        page.rowLimit(),
+	}
+	if authorID := queryParams.Get("author_id"); authorID != "" {
+		user_uuid, errParse := uuid.Parse(authorID)
+		if errParse != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		params.UserID = nullUUID(user_uuid)
+	}
+
+	var (
+		selectedChirps  []database.Chirp
+		errSelectChirps error
+	)
+	switch queryParams.Get("sort") {
+	case "", "asc":
+		selectedChirps, errSelectChirps = c.dbQueries.SelectChirps(r.Context(), params)
+	case "desc":
+		selectedChirps, errSelectChirps = c.dbQueries.SelectChirpsDesc(r.Context(), database.SelectChirpsDescParams(params))
+	default:
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	if errSelectChirps != nil {
+		fmt.Fprintf(os.Stderr, "%s", errSelectChirps)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	respondWithChirpsPage(w, r, page, selectedChirps, chirpCursor)
+}
+
+func getChirp(w http.ResponseWriter, r *http.Request) {
+	chirp_uuid, errParse := uuid.Parse(r.PathValue("chirp_id"))
+	if errParse != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	selectedChirp, errSelectChirp := c.dbQueries.SelectChirp(r.Context(), chirp_uuid)
+	if errSelectChirp != nil {
+		if errors.Is(errSelectChirp, sql.ErrNoRows) {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	if selectedChirp.DeletedAt.Valid {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	chirp := newChirpResponse(selectedChirp)
+	if err := completeChirpResponses(r.Context(), viewerID(r), &chirp); err != nil {
+		fmt.Fprintf(os.Stderr, "%s", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("content-type", "application/json")
+	errEncode := json.NewEncoder(w).Encode(chirp)
+	if errEncode != nil {
+		fmt.Fprintf(os.Stderr, "%s", errEncode)
+		return
+	}
+}
+
+// selectRefreshToken selects the refresh token by its hash.
+// It falls back to the legacy hash for the refresh tokens stored before they were keyed.
+func selectRefreshToken(ctx context.Context, refreshToken string) (database.RefreshToken, error) {
+	selectedRefreshToken, err := c.dbQueries.SelectRefreshToken(ctx, auth.HashRefreshToken(refreshToken, c.refreshTokenHashKey))
+	if errors.Is(err, sql.ErrNoRows) {
+		return c.dbQueries.SelectRefreshToken(ctx, auth.LegacyHashRefreshToken(refreshToken))
+	}
+	return selectedRefreshToken, err
+}
+
+var errRefreshTokenReused = errors.New("error refresh token has already been rotated")
+
+// rotateRefreshToken revokes the refresh token and issues the next one of its family.
+// If the refresh token has already been rotated its whole family is revoked.
+func rotateRefreshToken(r *http.Request, refreshToken database.RefreshToken) (string, error) {
+	ctx := r.Context()
+	if refreshToken.RotatedAt.Valid {
+		if err := c.dbQueries.RevokeRefreshTokenFamily(ctx, refreshToken.FamilyID); err != nil {
+			return "", err
+		}
+		c.accessTokenRevocation.Forget(refreshToken.UserID)
+		return "", errRefreshTokenReused
+	}
+
+	tx, err := c.db.BeginTx(ctx, nil)
+	if err != nil {
+		return "", err
+	}
+	defer tx.Rollback()
+	qtx := c.dbQueries.WithTx(tx)
+
+	now := time.Now().UTC()
+	rotatedRows, err := qtx.UpdateRefreshToken(ctx, database.UpdateRefreshTokenParams{
+		TokenHash: refreshToken.TokenHash,
+		RevokedAt: sql.NullTime{Time: now, Valid: true},
+		RotatedAt: sql.NullTime{Time: now, Valid: true},
+	})
+	if err != nil {
+		return "", err
+	}
+	if rotatedRows == 0 {
+		// a concurrent request has rotated or revoked the token after it was selected
+		tx.Rollback()
+		if err := c.dbQueries.RevokeRefreshTokenFamily(ctx, refreshToken.FamilyID); err != nil {
+			return "", err
+		}
+		c.accessTokenRevocation.Forget(refreshToken.UserID)
+		return "", errRefreshTokenReused
+	}
+
+	nextRefreshToken, _ := auth.MakeRefreshToken()
+	err = qtx.InsertRefreshToken(ctx, database.InsertRefreshTokenParams{
+		TokenHash:  auth.HashRefreshToken(nextRefreshToken, c.refreshTokenHashKey),
+		UserID:     refreshToken.UserID,
+		ExpiresAt:  now.AddDate(0, 0, 60),
+		FamilyID:   refreshToken.FamilyID,
+		StartedAt:  refreshToken.StartedAt,
+		UserAgent:  nullString(r.UserAgent()),
+		IpAddress:  nullString(clientIP(r)),
+		DeviceName: refreshToken.DeviceName,
+	})
+	if err != nil {
+		return "", err
+	}
+
+	return nextRefreshToken, tx.Commit()
+}
+
+func refreshAccessTokenHandler(w http.ResponseWriter, r *http.Request) {
+	b, fromCookie, errRequestToken := requestToken(r, refreshTokenCookieName)
+	if errRequestToken != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	selectedRefreshToken, errSelectRefreshToken := selectRefreshToken(r.Context(), b)
+	if errSelectRefreshToken != nil {
+		if errors.Is(errSelectRefreshToken, sql.ErrNoRows) {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	if fromCookie && !csrfProtected(r, selectedRefreshToken.FamilyID.String()) {
+		w.WriteHeader(http.StatusForbidden)
+		return
+	}
+
+	if !selectedRefreshToken.RotatedAt.Valid &&
+		(selectedRefreshToken.ExpiresAt.Before(time.Now()) || selectedRefreshToken.RevokedAt.Valid) {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	refreshToken, errRotateRefreshToken := rotateRefreshToken(r, selectedRefreshToken)
+	if errRotateRefreshToken != nil {
+		if errors.Is(errRotateRefreshToken, errRefreshTokenReused) {
+			fmt.Fprintf(os.Stderr, "refresh token family %s has been revoked on reuse\n", selectedRefreshToken.FamilyID)
+			recordAuditEvent(r, auditEvent{
+				eventType: auditTokenReuseDetected,
+				userID:    selectedRefreshToken.UserID,
+				details:   map[string]string{"session_id": selectedRefreshToken.FamilyID.String()},
+			})
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		fmt.Fprintf(os.Stderr, "%s", errRotateRefreshToken)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	// the user is selected again so the JWT carries the user's current role
+	selectedUser, errSelectUserByID := c.dbQueries.SelectUserByID(r.Context(), selectedRefreshToken.UserID)
+	if errSelectUserByID != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	token, errSignUserJWT := signSessionJWT(selectedUser, selectedRefreshToken.FamilyID)
+	if errSignUserJWT != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	recordAuditEvent(r, auditEvent{
+		eventType: auditTokenRefreshed,
+		actorID:   selectedRefreshToken.UserID,
+		userID:    selectedRefreshToken.UserID,
+		details:   map[string]string{"session_id": selectedRefreshToken.FamilyID.String()},
+	})
+
+	var csrfToken string
+	if fromCookie {
+		csrfToken = setSessionCookies(w, token, refreshToken, selectedRefreshToken.FamilyID)
+		token, refreshToken = "", ""
+	}
+
+	errEncode := json.NewEncoder(w).Encode(struct {
+		Token        string `json:"token,omitempty"`
+		RefreshToken string `json:"refresh_token,omitempty"`
+		CSRFToken    string `json:"csrf_token,omitempty"`
+	}{
+		Token:        token,
+		RefreshToken: refreshToken,
+		CSRFToken:    csrfToken,
+	})
+	if errEncode != nil {
+		fmt.Fprintf(os.Stderr, "%s", errEncode)
+		return
+	}
+
+}
+
+func UpdateRefreshToken(w http.ResponseWriter, r *http.Request) {
+	b, fromCookie, errRequestToken := requestToken(r, refreshTokenCookieName)
+	if errRequestToken != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	selectedRefreshToken, errSelectRefreshToken := selectRefreshToken(r.Context(), b)
+	if errSelectRefreshToken != nil {
+		if errors.Is(errSelectRefreshToken, sql.ErrNoRows) {
+			if fromCookie {
+				clearSessionCookies(w)
+			}
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	if fromCookie && !csrfProtected(r, selectedRefreshToken.FamilyID.String()) {
+		w.WriteHeader(http.StatusForbidden)
+		return
+	}
+
+	_, errUpdateRefreshToken := c.dbQueries.UpdateRefreshToken(r.Context(),
+		database.UpdateRefreshTokenParams{
+			TokenHash: selectedRefreshToken.TokenHash,
+			RevokedAt: sql.NullTime{Time: time.Now().UTC(), Valid: true},
+		},
+	)
+	if errUpdateRefreshToken != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	// the access JWTs of the session are revoked with its last refresh token
+	c.accessTokenRevocation.Forget(selectedRefreshToken.UserID)
+	recordAuditEvent(r, auditEvent{
+		eventType: auditTokenRevoked,
+		actorID:   selectedRefreshToken.UserID,
+		userID:    selectedRefreshToken.UserID,
+		details:   map[string]string{"session_id": selectedRefreshToken.FamilyID.String()},
+	})
+	if fromCookie {
+		clearSessionCookies(w)
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func putUserHandler(w http.ResponseWriter, r *http.Request, userID uuid.UUID) {
+	var reqBody struct {
+		Email    string `json:"email"`
+		Password string `json:"password"`
+	}
+
+	errDecode := json.NewDecoder(r.Body).Decode(&reqBody)
+	if errDecode != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	if emailInvalid(w, reqBody.Email) {
+		return
+	}
+	if passwordInvalid(w, reqBody.Password) {
+		return
+	}
+
+	hashedPassword, errHashPassword := auth.HashPasswordArgon2id(reqBody.Password, c.passwordParams)
+	if errHashPassword != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	previousUser, errSelectUserByID := c.dbQueries.SelectUserByID(r.Context(), userID)
+	if errSelectUserByID != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	// the PUT passes the current password with the new email when only the email changes
+	passwordChanged := !previousUser.HashedPassword.Valid ||
+		auth.CheckPasswordHash(previousUser.HashedPassword.String, reqBody.Password) != nil
+
+	// the access JWTs issued before the sessions have no session id.
+	// uuid.Nil matches no session and all of them are revoked.
+	currentSessionID, _ := uuid.Parse(userClaimsFromContext(r.Context()).SessionID)
+	updatedUser, errUpdateUser := updateUser(r.Context(), database.UpdateUserParams{
+		ID:    userID,
+		Email: reqBody.Email,
+		HashedPassword: sql.NullString{
+			String: hashedPassword,
+			Valid:  true,
+		},
+	}, currentSessionID)
+	if errUpdateUser != nil {
+		if uniqueViolation(errUpdateUser) {
+			respondWithEmailTaken(w)
+			return
+		}
+		fmt.Fprintf(os.Stderr, "%s", errUpdateUser)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	if passwordChanged {
+		recordAuditEvent(r, auditEvent{eventType: auditPasswordChanged, userID: userID})
+	}
+	if updatedUser.Email != previousUser.Email {
+		recordAuditEvent(r, auditEvent{
+			eventType: auditEmailChanged,
+			userID:    userID,
+			email:     updatedUser.Email,
+			details:   map[string]string{"previous_email": previousUser.Email},
+		})
+
+		// the new email is unverified until the user follows the link
+		errSendVerificationEmail := sendVerificationEmail(r.Context(), userID, updatedUser.Email)
+		if errSendVerificationEmail != nil {
+			// the user can ask for another email at POST /api/users/verify/resend
+			fmt.Fprintf(os.Stderr, "%s", errSendVerificationEmail)
+		}
+	}
+
+	// the passed JWT is revoked with the others, the current session continues with the new one
+	token, errSignSessionJWT := signSessionJWT(updatedUser, currentSessionID)
+	if errSignSessionJWT != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	if r.Header.Get("authorization") == "" {
+		// the web app's session continues with the new JWT in its cookie
+		setAccessTokenCookie(w, token)
+		token = ""
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(struct {
+		Email string `json:"email"`
+		Token string `json:"token,omitempty"`
+	}{
+		Email: updatedUser.Email,
+		Token: token,
+	})
+}
+
+// updateUser updates the user's email and password, revokes the user's access JWTs
+// and logs the user out of the other sessions.
+func updateUser(ctx context.Context, params database.UpdateUserParams, currentSessionID uuid.UUID) (database.User, error) {
+	tx, err := c.db.BeginTx(ctx, nil)
+	if err != nil {
+		return database.User{}, err
+	}
+	defer tx.Rollback()
+	qtx := c.dbQueries.WithTx(tx)
+
+	if err := qtx.UpdateUser(ctx, params); err != nil {
+		return database.User{}, err
+	}
+	if _, err := qtx.BumpUserTokenVersion(ctx, params.ID); err != nil {
+		return database.User{}, err
+	}
+	err = qtx.RevokeUserSessionsExcept(ctx, database.RevokeUserSessionsExceptParams{
+		UserID:   params.ID,
+		FamilyID: currentSessionID,
+	})
+	if err != nil {
+		return database.User{}, err
+	}
+	updatedUser, err := qtx.SelectUserByID(ctx, params.ID)
+	if err != nil {
+		return database.User{}, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return database.User{}, err
+	}
+	c.accessTokenRevocation.Forget(params.ID)
+	return updatedUser, nil
+}
+
+func logoutEverywhereHandler(w http.ResponseWriter, r *http.Request, userID uuid.UUID) {
+	tx, errBeginTx := c.db.BeginTx(r.Context(), nil)
+	if errBeginTx != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
+	qtx := c.dbQueries.WithTx(tx)
+
+	if err := qtx.RevokeUserRefreshTokens(r.Context(), userID); err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	if _, err := qtx.BumpUserTokenVersion(r.Context(), userID); err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	c.accessTokenRevocation.Forget(userID)
+	recordAuditEvent(r, auditEvent{eventType: auditLogoutEverywhere, userID: userID})
+	if r.Header.Get("authorization") == "" {
+		// the web app's session has been logged out with the others
+		clearSessionCookies(w)
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// revokeAccessTokenHandler revokes one of the user's access JWTs by its JWT ID until it expires,
+// e.g. the leaked one. The user's other JWTs and sessions stay valid.
+func revokeAccessTokenHandler(w http.ResponseWriter, r *http.Request, userID uuid.UUID) {
+	var reqBody struct {
+		AccessToken string `json:"access_token"`
+	}
+	errDecode := json.NewDecoder(r.Body).Decode(&reqBody)
+	if errDecode != nil || reqBody.AccessToken == "" {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	revokedClaims, errValidateUserClaims := c.jwtKeyring.ValidateUserClaims(reqBody.AccessToken)
+	if errors.Is(errValidateUserClaims, jwt.ErrTokenExpired) {
+		// the expired JWT is already rejected
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	if errValidateUserClaims != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	if revokedClaims.Subject != userID.String() {
+		w.WriteHeader(http.StatusForbidden)
+		return
+	}
+	if revokedClaims.ID == "" {
+		// the JWTs issued before the JWT IDs are revoked with their session or by POST /api/logout/all
+		w.Header().Set("content-type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		errEncode := json.NewEncoder(w).Encode(struct {
+			Error string `json:"error"`
+		}{Error: "the access token has no jti. Log out everywhere to revoke it"})
+		if errEncode != nil {
+			fmt.Fprintf(os.Stderr, "%s", errEncode)
+		}
+		return
+	}
+
+	errInsertRevokedAccessToken := c.dbQueries.InsertRevokedAccessToken(r.Context(), database.InsertRevokedAccessTokenParams{
+		Jti:       revokedClaims.ID,
+		UserID:    userID,
+		ExpiresAt: revokedClaims.ExpiresAt.Time,
+	})
+	if errInsertRevokedAccessToken != nil {
+		fmt.Fprintf(os.Stderr, "%s", errInsertRevokedAccessToken)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	c.accessTokenRevocation.Forget(userID)
+	recordAuditEvent(r, auditEvent{
+		eventType: auditAccessTokenRevoked,
+		userID:    userID,
+		details:   map[string]string{"jti": revokedClaims.ID},
+	})
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// deleteChirp soft-deletes the author's chirp. Its replies stay in the conversation
+// and the threads keep the deleted chirp's place without its body.
+func deleteChirp(w http.ResponseWriter, r *http.Request, userID uuid.UUID) {
+	chirp_uuid, errParse := uuid.Parse(r.PathValue("chirp_id"))
+	if errParse != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	selectedChirp, errSelectChirp := c.dbQueries.SelectChirp(r.Context(), chirp_uuid)
+	if errSelectChirp != nil {
+		if errors.Is(errSelectChirp, sql.ErrNoRows) {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	if selectedChirp.UserID != userID {
+		w.WriteHeader(http.StatusForbidden)
+		return
+	}
+
+	if selectedChirp.DeletedAt.Valid {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	errUpdateChirp := c.dbQueries.UpdateChirp(r.Context(), database.UpdateChirpParams{
+		ID:     chirp_uuid,
+		UserID: userID,
+		DeletedAt: sql.NullTime{
+			Time:  time.Now().UTC(),
+			Valid: true,
+		},
+	})
+	if errUpdateChirp != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+
+}
+
+var (
+	errChirpNotFound         = errors.New("error chirp doesn't exist")
+	errChirpNotAuthor        = errors.New("error user isn't the chirp's author")
+	errChirpEditWindowClosed = errors.New("error chirp's edit window has closed")
+	errChirpRechirp          = errors.New("error chirp is a rechirp")
+)
+
+// editChirpHandler replaces the body of the author's chirp within the edit window after it's posted.
+// The previous body is kept in the chirp's history.
+func editChirpHandler(w http.ResponseWriter, r *http.Request, userID uuid.UUID) {
+	chirp_uuid, errParse := uuid.Parse(r.PathValue("chirp_id"))
+	if errParse != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	var reqBody struct {
+		Body string `json:"body"`
+	}
+	errDecode := json.NewDecoder(r.Body).Decode(&reqBody)
+	if errDecode != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	if chirpInvalid(w, reqBody.Body) {
+		return
+	}
+	if emailUnverified(w, r.Context(), userID) {
+		return
+	}
+
+	editedChirp, errEditChirp := editChirp(r.Context(), chirp_uuid, userID, cleanInput(reqBody.Body))
+	if errEditChirp != nil {
+		switch {
+		case errors.Is(errEditChirp, errChirpNotFound):
+			w.WriteHeader(http.StatusNotFound)
+		case errors.Is(errEditChirp, errChirpNotAuthor):
+			w.WriteHeader(http.StatusForbidden)
+		case errors.Is(errEditChirp, errChirpEditWindowClosed):
+			w.Header().Set("content-type", "application/json")
+			w.WriteHeader(http.StatusForbidden)
+			errEncode := json.NewEncoder(w).Encode(struct {
+				Error string `json:"error"`
+			}{Error: fmt.Sprintf("chirps can be edited only within %s after they are posted", c.chirpEditWindow)})
+			if errEncode != nil {
+				fmt.Fprintf(os.Stderr, "%s", errEncode)
+			}
+		case errors.Is(errEditChirp, errChirpRechirp):
+			w.Header().Set("content-type", "application/json")
+			w.WriteHeader(http.StatusForbidden)
+			errEncode := json.NewEncoder(w).Encode(struct {
+				Error string `json:"error"`
+			}{Error: "rechirps can't be edited"})
+			if errEncode != nil {
+				fmt.Fprintf(os.Stderr, "%s", errEncode)
+			}
+		default:
+			fmt.Fprintf(os.Stderr, "%s", errEditChirp)
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+		return
+	}
+
+	chirp := newChirpResponse(editedChirp)
+	if err := completeChirpResponses(r.Context(), userID, &chirp); err != nil {
+		fmt.Fprintf(os.Stderr, "%s", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("content-type", "application/json")
+	errEncode := json.NewEncoder(w).Encode(chirp)
+	if errEncode != nil {
+		fmt.Fprintf(os.Stderr, "%s", errEncode)
+		return
+	}
+}
+
+// editChirp stores the chirp's current body as a revision and replaces it with the body.
+// The chirp is locked so the concurrent edits don't lose each other's revisions.
+func editChirp(ctx context.Context, chirpID, userID uuid.UUID, body string) (database.Chirp, error) {
+	tx, err := c.db.BeginTx(ctx, nil)
+	if err != nil {
+		return database.Chirp{}, err
+	}
+	defer tx.Rollback()
+	qtx := c.dbQueries.WithTx(tx)
+
+	selectedChirp, err := qtx.SelectChirpForUpdate(ctx, chirpID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return database.Chirp{}, errChirpNotFound
+		}
+		return database.Chirp{}, err
+	}
+	if selectedChirp.DeletedAt.Valid {
+		return database.Chirp{}, errChirpNotFound
+	}
+	if selectedChirp.UserID != userID {
+		return database.Chirp{}, errChirpNotAuthor
+	}
+	if selectedChirp.RechirpOf.Valid {
+		return database.Chirp{}, errChirpRechirp
+	}
+	if time.Since(selectedChirp.CreatedAt) > c.chirpEditWindow {
+		return database.Chirp{}, errChirpEditWindowClosed
+	}
+	if selectedChirp.Body == body {
+		return selectedChirp, nil
+	}
+
+	// the current body was posted with the chirp or the previous edit
+	bodyCreatedAt := selectedChirp.CreatedAt
+	if selectedChirp.EditedAt.Valid {
+		bodyCreatedAt = selectedChirp.EditedAt.Time
+	}
+	err = qtx.InsertChirpRevision(ctx, database.InsertChirpRevisionParams{
+		ChirpID:   chirpID,
+		Body:      selectedChirp.Body,
+		CreatedAt: bodyCreatedAt,
+	})
+	if err != nil {
+		return database.Chirp{}, err
+	}
+
+	editedChirp, err := qtx.EditChirp(ctx, database.EditChirpParams{
+		ID:   chirpID,
+		Body: body,
+	})
+	if err != nil {
+		return database.Chirp{}, err
+	}
+	return editedChirp, tx.Commit()
+}
+
+// getChirpHistory responds with the chirp and its previous bodies, the latest first.
+func getChirpHistory(w http.ResponseWriter, r *http.Request) {
+	chirp_uuid, errParse := uuid.Parse(r.PathValue("chirp_id"))
+	if errParse != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	selectedChirp, errSelectChirp := c.dbQueries.SelectChirp(r.Context(), chirp_uuid)
+	if errSelectChirp != nil {
+		if errors.Is(errSelectChirp, sql.ErrNoRows) {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	if selectedChirp.DeletedAt.Valid {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	selectedRevisions, errSelectChirpRevisions := c.dbQueries.SelectChirpRevisions(r.Context(), chirp_uuid)
+	if errSelectChirpRevisions != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	type chirpRevision struct {
+		Body       string `json:"body"`
+		CreatedAt  string `json:"created_at"`
+		ReplacedAt string `json:"replaced_at"`
+	}
+	revisions := make([]chirpRevision, len(selectedRevisions))
+	for i, v := range selectedRevisions {
+		revisions[i].Body = v.Body
+		revisions[i].CreatedAt = v.CreatedAt.Format(time.RFC3339)
+		revisions[i].ReplacedAt = v.ReplacedAt.Format(time.RFC3339)
+	}
+
+	chirp := newChirpResponse(selectedChirp)
+	if err := completeChirpResponses(r.Context(), viewerID(r), &chirp); err != nil {
+		fmt.Fprintf(os.Stderr, "%s", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("content-type", "application/json")
+	errEncode := json.NewEncoder(w).Encode(struct {
+		Chirp     chirpResponse   `json:"chirp"`
+		Revisions []chirpRevision `json:"revisions"`
+	}{
+		Chirp:     chirp,
+		Revisions: revisions,
+	})
+	if errEncode != nil {
+		fmt.Fprintf(os.Stderr, "%s", errEncode)
+		return
+	}
+}
+
+// getChirpReplies responds with a page of the chirp's direct replies, the oldest first.
+// The replies of the deleted chirps stay in their conversations and are listed too.
+func getChirpReplies(w http.ResponseWriter, r *http.Request) {
+	chirp_uuid, errParse := uuid.Parse(r.PathValue("chirp_id"))
+	if errParse != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	page, errParseChirpsPage := parseChirpsPage(r.URL.Query())
+	if errParseChirpsPage != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	_, errSelectChirp := c.dbQueries.SelectChirp(r.Context(), chirp_uuid)
+	if errSelectChirp != nil {
+		if errors.Is(errSelectChirp, sql.ErrNoRows) {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	selectedReplies, errSelectChirpReplies := c.dbQueries.SelectChirpReplies(r.Context(), database.SelectChirpRepliesParams{
+		InReplyTo:       nullUUID(chirp_uuid),
+		CursorCreatedAt: page.cursorCreatedAt,
+		CursorID:        page.cursorID,
+		RowLimit:        page.rowLimit(),
+	})
+	if errSelectChirpReplies != nil {
+		fmt.Fprintf(os.Stderr, "%s", errSelectChirpReplies)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	respondWithChirpsPage(w, r, page, selectedReplies, chirpCursor)
+}
+
+// threadChirpResponse is the chirp of the thread. The deleted chirp's id is kept but the chirp is null.
+type threadChirpResponse struct {
+	ID      uuid.UUID             `json:"id"`
+	Chirp   *chirpResponse        `json:"chirp"`
+	Replies []threadChirpResponse `json:"replies,omitempty"`
+}
+
+func newThreadChirpResponse(chirp database.Chirp) threadChirpResponse {
+	response := threadChirpResponse{ID: chirp.ID}
+	if !chirp.DeletedAt.Valid {
+		visibleChirp := newChirpResponse(chirp)
+		response.Chirp = &visibleChirp
+	}
+	return response
+}
+
+// visibleChirps returns the chirps of the thread's tree that aren't deleted.
+func (response threadChirpResponse) visibleChirps() []*chirpResponse {
+	var chirps []*chirpResponse
+	if response.Chirp != nil {
+		chirps = append(chirps, response.Chirp)
+	}
+	for _, reply := range response.Replies {
+		chirps = append(chirps, reply.visibleChirps()...)
+	}
+	return chirps
+}
+
+func newThreadNodeResponse(node *thread.Node) threadChirpResponse {
+	response := newThreadChirpResponse(node.Chirp)
+	for _, reply := range node.Replies {
+		response.Replies = append(response.Replies, newThreadNodeResponse(reply))
+	}
+	return response
+}
+
+// getChirpThread responds with the chirp's ancestors from the start of the conversation and the tree of its replies.
+// The deleted chirps with the replies are kept as null chirps so the thread keeps its shape.
+func getChirpThread(w http.ResponseWriter, r *http.Request) {
+	// maxThreadChirps caps the conversation's chirps in the thread. The latest replies past it are left out
+	const maxThreadChirps = 1000
+
+	chirp_uuid, errParse := uuid.Parse(r.PathValue("chirp_id"))
+	if errParse != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	selectedChirp, errSelectChirp := c.dbQueries.SelectChirp(r.Context(), chirp_uuid)
+	if errSelectChirp != nil {
+		if errors.Is(errSelectChirp, sql.ErrNoRows) {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	if selectedChirp.DeletedAt.Valid {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	conversationChirps, errSelectConversationChirps := c.dbQueries.SelectConversationChirps(r.Context(), database.SelectConversationChirpsParams{
+		ConversationID: selectedChirp.ConversationID,
+		RowLimit:       maxThreadChirps,
+	})
+	if errSelectConversationChirps != nil {
+		fmt.Fprintf(os.Stderr, "%s", errSelectConversationChirps)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	ancestors, node, ok := thread.Build(conversationChirps, chirp_uuid)
+	if !ok {
+		// the chirp is past the cap of the conversation
+		node = &thread.Node{Chirp: selectedChirp}
+	}
+
+	ancestorResponses := make([]threadChirpResponse, len(ancestors))
+	for i, v := range ancestors {
+		ancestorResponses[i] = newThreadChirpResponse(v)
+	}
+	nodeResponse := newThreadNodeResponse(node)
+
+	var threadChirps []*chirpResponse
+	for _, v := range ancestorResponses {
+		threadChirps = append(threadChirps, v.visibleChirps()...)
+	}
+	threadChirps = append(threadChirps, nodeResponse.visibleChirps()...)
+	if err := completeChirpResponses(r.Context(), viewerID(r), threadChirps...); err != nil {
+		fmt.Fprintf(os.Stderr, "%s", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("content-type", "application/json")
+	errEncode := json.NewEncoder(w).Encode(struct {
+		Ancestors []threadChirpResponse `json:"ancestors"`
+		Chirp     threadChirpResponse   `json:"chirp"`
+	}{
+		Ancestors: ancestorResponses,
+		Chirp:     nodeResponse,
+	})
+	if errEncode != nil {
+		fmt.Fprintf(os.Stderr, "%s", errEncode)
+		return
+	}
+}
+
+// likeChirpHandler adds the user's like to the chirp and responds with the chirp. Liking the chirp again changes nothing.
+func likeChirpHandler(w http.ResponseWriter, r *http.Request, userID uuid.UUID) {
+	setChirpLike(w, r, userID, true)
+}
+
+// unlikeChirpHandler removes the user's like from the chirp and responds with the chirp.
+func unlikeChirpHandler(w http.ResponseWriter, r *http.Request, userID uuid.UUID) {
+	setChirpLike(w, r, userID, false)
+}
+
+func setChirpLike(w http.ResponseWriter, r *http.Request, userID uuid.UUID, liked bool) {
+	chirp_uuid, errParse := uuid.Parse(r.PathValue("chirp_id"))
+	if errParse != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	// liking the rechirp likes its original
+	selectedChirp, errSelectOriginalChirp := selectOriginalChirp(r.Context(), chirp_uuid)
+	if errSelectOriginalChirp != nil {
+		if errors.Is(errSelectOriginalChirp, errChirpNotFound) {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	chirp_uuid = selectedChirp.ID
+
+	// the likes' unique key makes the repeated likes and unlikes no-ops. The trigger counts the rest
+	var errLike error
+	if liked {
+		_, errLike = c.dbQueries.InsertLike(r.Context(), database.InsertLikeParams{UserID: userID, ChirpID: chirp_uuid})
+	} else {
+		_, errLike = c.dbQueries.DeleteLike(r.Context(), database.DeleteLikeParams{UserID: userID, ChirpID: chirp_uuid})
+	}
+	if errLike != nil {
+		fmt.Fprintf(os.Stderr, "%s", errLike)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	// the chirp with the updated like count
+	selectedChirp, errSelectChirp := c.dbQueries.SelectChirp(r.Context(), chirp_uuid)
+	if errSelectChirp != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	chirp := newChirpResponse(selectedChirp)
+	if err := completeChirpResponses(r.Context(), userID, &chirp); err != nil {
+		fmt.Fprintf(os.Stderr, "%s", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("content-type", "application/json")
+	errEncode := json.NewEncoder(w).Encode(chirp)
+	if errEncode != nil {
+		fmt.Fprintf(os.Stderr, "%s", errEncode)
+		return
+	}
+}
+
+// emailUnverified responds with 403 Forbidden if REQUIRE_VERIFIED_EMAIL is set and the user hasn't verified the email.
+func emailUnverified(w http.ResponseWriter, ctx context.Context, userID uuid.UUID) bool {
+	if !c.requireVerifiedEmail {
+		return false
+	}
+
+	selectedUser, errSelectUserByID := c.dbQueries.SelectUserByID(ctx, userID)
+	if errSelectUserByID != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return true
+	}
+	if !selectedUser.EmailVerifiedAt.Valid {
+		w.Header().Set("content-type", "application/json")
+		w.WriteHeader(http.StatusForbidden)
+		errEncode := json.NewEncoder(w).Encode(struct {
+			Error string `json:"error"`
+		}{Error: "verify your email to post chirps"})
+		if errEncode != nil {
+			fmt.Fprintf(os.Stderr, "%s", errEncode)
+		}
+		return true
+	}
+	return false
+}
+
+// rechirpHandler shares the chirp in the user's timeline. Rechirping a rechirp shares its original.
+func rechirpHandler(w http.ResponseWriter, r *http.Request, userID uuid.UUID) {
+	chirp_uuid, errParse := uuid.Parse(r.PathValue("chirp_id"))
+	if errParse != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	if emailUnverified(w, r.Context(), userID) {
+		return
+	}
+
+	selectedChirp, errSelectOriginalChirp := selectOriginalChirp(r.Context(), chirp_uuid)
+	if errSelectOriginalChirp != nil {
+		if errors.Is(errSelectOriginalChirp, errChirpNotFound) {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	createdRechirp, errCreateRechirp := c.dbQueries.CreateRechirp(r.Context(), database.CreateRechirpParams{
+		ID:        uuid.New(),
+		UserID:    userID,
+		RechirpOf: nullUUID(selectedChirp.ID),
+	})
+	if errCreateRechirp != nil {
+		if errors.Is(errCreateRechirp, sql.ErrNoRows) {
+			w.Header().Set("content-type", "application/json")
+			w.WriteHeader(http.StatusConflict)
+			errEncode := json.NewEncoder(w).Encode(struct {
+				Error string `json:"error"`
+			}{Error: "the chirp is already rechirped"})
+			if errEncode != nil {
+				fmt.Fprintf(os.Stderr, "%s", errEncode)
+			}
+			return
+		}
+
+		fmt.Fprintf(os.Stderr, "%s", errCreateRechirp)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	chirp := newChirpResponse(createdRechirp)
+	if err := completeChirpResponses(r.Context(), userID, &chirp); err != nil {
+		fmt.Fprintf(os.Stderr, "%s", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("content-type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	errEncode := json.NewEncoder(w).Encode(chirp)
+	if errEncode != nil {
+		fmt.Fprintf(os.Stderr, "%s", errEncode)
+		return
+	}
+}
+
+// unrechirpHandler deletes the user's rechirp of the chirp. The chirp may be a rechirp of the original.
+func unrechirpHandler(w http.ResponseWriter, r *http.Request, userID uuid.UUID) {
+	chirp_uuid, errParse := uuid.Parse(r.PathValue("chirp_id"))
+	if errParse != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	selectedChirp, errSelectChirp := c.dbQueries.SelectChirp(r.Context(), chirp_uuid)
+	if errSelectChirp != nil {
+		if errors.Is(errSelectChirp, sql.ErrNoRows) {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	// the rechirp's own id stands for its original. The rechirps of the deleted originals can be deleted too.
+	originalChirpID := selectedChirp.ID
+	if selectedChirp.RechirpOf.Valid {
+		originalChirpID = selectedChirp.RechirpOf.UUID
+	}
+
+	deletedRows, errDeleteUserRechirp := c.dbQueries.DeleteUserRechirp(r.Context(), database.DeleteUserRechirpParams{
+		UserID:    userID,
+		RechirpOf: nullUUID(originalChirpID),
+	})
+	if errDeleteUserRechirp != nil {
+		fmt.Fprintf(os.Stderr, "%s", errDeleteUserRechirp)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	if deletedRows == 0 {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// getUserLikes responds with a page of the chirps the user likes, the latest liked first.
+func getUserLikes(w http.ResponseWriter, r *http.Request) {
+	user_uuid, errParse := uuid.Parse(r.PathValue("user_id"))
+	if errParse != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	page, errParseChirpsPage := parseChirpsPage(r.URL.Query())
+	if errParseChirpsPage != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	selectedUser, errSelectUserByID := c.dbQueries.SelectUserByID(r.Context(), user_uuid)
+	if errSelectUserByID != nil {
+		if errors.Is(errSelectUserByID, sql.ErrNoRows) {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	if selectedUser.DeletedAt.Valid {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	selectedLikes, errSelectUserLikedChirps := c.dbQueries.SelectUserLikedChirps(r.Context(), database.SelectUserLikedChirpsParams{
+		UserID:          user_uuid,
+		CursorCreatedAt: page.cursorCreatedAt,
+		CursorID:        page.cursorID,
+		RowLimit:        page.rowLimit(),
+	})
+	if errSelectUserLikedChirps != nil {
+		fmt.Fprintf(os.Stderr, "%s", errSelectUserLikedChirps)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	// the pages follow the likes' order rather than the chirps'
+	likedChirps := make([]database.Chirp, len(selectedLikes))
+	likedAt := make(map[uuid.UUID]time.Time, len(selectedLikes))
+	for i, v := range selectedLikes {
+		likedChirps[i] = v.Chirp
+		likedAt[v.Chirp.ID] = v.LikedAt
+	}
+	respondWithChirpsPage(w, r, page, likedChirps, func(chirp database.Chirp) pagination.Cursor {
+		return pagination.Cursor{CreatedAt: likedAt[chirp.ID], ID: chirp.ID}
+	})
+}
+
+func getSessionsHandler(w http.ResponseWriter, r *http.Request, userID uuid.UUID) {
+	selectedSessions, errSelectUserSessions := c.dbQueries.SelectUserSessions(r.Context(), userID)
+	if errSelectUserSessions != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	currentSessionID := userClaimsFromContext(r.Context()).SessionID
+	sessions := make([]struct {
+		ID         uuid.UUID `json:"id"`
+		CreatedAt  string    `json:"created_at"`
+		LastUsedAt string    `json:"last_used_at"`
+		ExpiresAt  string    `json:"expires_at"`
+		UserAgent  string    `json:"user_agent"`
+		IPAddress  string    `json:"ip_address"`
+		DeviceName string    `json:"device_name"`
+		Current    bool      `json:"current"`
+	}, len(selectedSessions))
+	for i, v := range selectedSessions {
+		sessions[i].ID = v.FamilyID
+		sessions[i].CreatedAt = v.StartedAt.Format(time.RFC3339)
+		sessions[i].LastUsedAt = v.LastUsedAt.Format(time.RFC3339)
+		sessions[i].ExpiresAt = v.ExpiresAt.Format(time.RFC3339)
+		sessions[i].UserAgent = v.UserAgent.String
+		sessions[i].IPAddress = v.IpAddress.String
+		sessions[i].DeviceName = v.DeviceName.String
+		sessions[i].Current = v.FamilyID.String() == currentSessionID
+	}
+
+	w.Header().Set("content-type", "application/json")
+	errEncode := json.NewEncoder(w).Encode(sessions)
+	if errEncode != nil {
+		fmt.Fprintf(os.Stderr, "%s", errEncode)
+		return
+	}
+}
+
+func deleteSessionHandler(w http.ResponseWriter, r *http.Request, userID uuid.UUID) {
+	session_uuid, errParse := uuid.Parse(r.PathValue("session_id"))
+	if errParse != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	revokedRows, errRevokeUserSession := c.dbQueries.RevokeUserSession(r.Context(), database.RevokeUserSessionParams{
+		FamilyID: session_uuid,
+		UserID:   userID,
+	})
+	if errRevokeUserSession != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	if revokedRows == 0 {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	c.accessTokenRevocation.Forget(userID)
+	recordAuditEvent(r, auditEvent{
+		eventType: auditSessionRevoked,
+		userID:    userID,
+		details:   map[string]string{"session_id": session_uuid.String()},
+	})
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func deleteOtherSessionsHandler(w http.ResponseWriter, r *http.Request, userID uuid.UUID) {
+	// the access JWTs issued before the sessions have no session id.
+	// uuid.Nil matches no session and all of them are revoked.
+	currentSessionID, _ := uuid.Parse(userClaimsFromContext(r.Context()).SessionID)
+
+	errRevokeUserSessionsExcept := c.dbQueries.RevokeUserSessionsExcept(r.Context(), database.RevokeUserSessionsExceptParams{
+		UserID:   userID,
+		FamilyID: currentSessionID,
+	})
+	if errRevokeUserSessionsExcept != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	c.accessTokenRevocation.Forget(userID)
+	recordAuditEvent(r, auditEvent{eventType: auditOtherSessionsRevoked, userID: userID})
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// forgotPasswordHandler emails the user the link to reset the password.
+// The response is the same and takes the same time whether the user exists or not,
+// so the endpoint doesn't reveal the registered emails: the email is sent after the response.
+func forgotPasswordHandler(w http.ResponseWriter, r *http.Request) {
+	var reqBody struct {
+		Email string `json:"email"`
+	}
+
+	errDecode := json.NewDecoder(r.Body).Decode(&reqBody)
+	if errDecode != nil || reqBody.Email == "" {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	// every request counts so a client can't flood the inboxes of many users
+	ipKey := passwordResetIPKey(clientIP(r))
+	retryAfter, errRetryAfter := c.ipPasswordResetLimiter.RetryAfter(r.Context(), ipKey)
+	if errRetryAfter != nil {
+		fmt.Fprintf(os.Stderr, "%s", errRetryAfter)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	if retryAfter > 0 {
+		w.Header().Set("retry-after", strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))))
+		w.WriteHeader(http.StatusTooManyRequests)
+		return
+	}
+	if _, err := c.ipPasswordResetLimiter.Fail(r.Context(), ipKey); err != nil {
+		fmt.Fprintf(os.Stderr, "%s", err)
+	}
+
+	go sendPasswordResetEmail(context.WithoutCancel(r.Context()), reqBody.Email)
+	w.WriteHeader(http.StatusAccepted)
+}
+
+func passwordResetIPKey(ip string) string {
+	return "password_reset_ip:" + ip
+}
+
+// sendPasswordResetEmail emails the reset link to the user with the email unless the account is deleted
+// or has been sent passwordResetsPerHour links within the last hour. It runs after the response, so it logs the errors.
+func sendPasswordResetEmail(ctx context.Context, email string) {
+	const passwordResetsPerHour = 5
+
+	selectedUser, errSelectUserByEmail := c.dbQueries.SelectUserByEmail(ctx, email)
+	if errSelectUserByEmail != nil {
+		if !errors.Is(errSelectUserByEmail, sql.ErrNoRows) {
+			fmt.Fprintf(os.Stderr, "%s", errSelectUserByEmail)
+		}
+		return
+	}
+	if selectedUser.DeletedAt.Valid {
+		return
+	}
+
+	// the links over the limit aren't sent so nobody floods the user's inbox
+	sentCount, errCount := c.dbQueries.CountUserPasswordResetTokensSince(ctx, database.CountUserPasswordResetTokensSinceParams{
+		UserID:    selectedUser.ID,
+		CreatedAt: time.Now().UTC().Add(-time.Hour),
+	})
+	if errCount != nil {
+		fmt.Fprintf(os.Stderr, "%s", errCount)
+		return
+	}
+	if sentCount >= passwordResetsPerHour {
+		return
+	}
+
+	resetToken, _ := auth.MakeToken()
+	errInsertPasswordResetToken := c.dbQueries.InsertPasswordResetToken(ctx, database.InsertPasswordResetTokenParams{
+		TokenHash: auth.HashToken(resetToken),
+		UserID:    selectedUser.ID,
+		ExpiresAt: time.Now().UTC().Add(time.Duration(1) * time.Hour),
+	})
+	if errInsertPasswordResetToken != nil {
+		fmt.Fprintf(os.Stderr, "%s", errInsertPasswordResetToken)
+		return
+	}
+
+	errSend := c.mailer.Send(ctx, mailer.Message{
+		To:      selectedUser.Email,
+		Subject: "Reset your Chirpy password",
+		Body: fmt.Sprintf("Someone has asked to reset the password of your Chirpy account.\n\n"+
+			"To choose a new password follow the link within 1 hour:\n%s/app/reset-password/?token=%s\n\n"+
+			"If it wasn't you, ignore this email. Your password hasn't been changed.\n",
+			c.baseURL, resetToken,
+		),
+	})
+	if errSend != nil {
+		fmt.Fprintf(os.Stderr, "%s", errSend)
+	}
+}
+
+var errPasswordResetTokenInvalid = errors.New("error password reset token is invalid, used or expired")
+
+func resetPasswordHandler(w http.ResponseWriter, r *http.Request) {
+	var reqBody struct {
+		Token    string `json:"token"`
+		Password string `json:"password"`
+	}
+
+	errDecode := json.NewDecoder(r.Body).Decode(&reqBody)
+	if errDecode != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	if passwordInvalid(w, reqBody.Password) {
+		return
+	}
+
+	hashedPassword, errHashPassword := auth.HashPasswordArgon2id(reqBody.Password, c.passwordParams)
+	if errHashPassword != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	userID, errResetPassword := resetPassword(r.Context(), reqBody.Token, hashedPassword)
+	if errResetPassword != nil {
+		if errors.Is(errResetPassword, errPasswordResetTokenInvalid) {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		fmt.Fprintf(os.Stderr, "%s", errResetPassword)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	recordAuditEvent(r, auditEvent{eventType: auditPasswordReset, userID: userID})
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// resetPassword uses the reset token to set the user's password,
+// invalidates the user's other reset tokens and revokes all the user's refresh tokens. It returns the user's id.
+func resetPassword(ctx context.Context, resetToken, hashedPassword string) (uuid.UUID, error) {
+	tx, err := c.db.BeginTx(ctx, nil)
+	if err != nil {
+		return uuid.Nil, err
+	}
+	defer tx.Rollback()
+	qtx := c.dbQueries.WithTx(tx)
+
+	userID, err := qtx.UsePasswordResetToken(ctx, auth.HashToken(resetToken))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return uuid.Nil, errPasswordResetTokenInvalid
+		}
+		return uuid.Nil, err
+	}
+
+	err = qtx.UpdateUserPassword(ctx, database.UpdateUserPasswordParams{
+		ID:             userID,
+		HashedPassword: nullString(hashedPassword),
+	})
+	if err != nil {
+		return uuid.Nil, err
+	}
+	if err := qtx.InvalidateUserPasswordResetTokens(ctx, userID); err != nil {
+		return uuid.Nil, err
+	}
+	if err := qtx.RevokeUserRefreshTokens(ctx, userID); err != nil {
+		return uuid.Nil, err
+	}
+	if _, err := qtx.BumpUserTokenVersion(ctx, userID); err != nil {
+		return uuid.Nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return uuid.Nil, err
+	}
+	c.accessTokenRevocation.Forget(userID)
+	return userID, nil
+}
+
+// sendVerificationEmail emails the user the link to verify the email.
+func sendVerificationEmail(ctx context.Context, userID uuid.UUID, email string) error {
+	verificationToken, _ := auth.MakeToken()
+	err := c.dbQueries.InsertEmailVerificationToken(ctx, database.InsertEmailVerificationTokenParams{
+		TokenHash: auth.HashToken(verificationToken),
+		UserID:    userID,
+		Email:     email,
+		ExpiresAt: time.Now().UTC().AddDate(0, 0, 2),
+	})
+	if err != nil {
+		return err
+	}
+
+	return c.mailer.Send(ctx, mailer.Message{
+		To:      email,
+		Subject: "Verify your Chirpy email",
+		Body: fmt.Sprintf("Welcome to Chirpy!\n\n"+
+			"To verify your email follow the link within 2 days:\n%s/app/verify-email/?token=%s\n\n"+
+			"If you haven't signed up for Chirpy, ignore this email.\n",
+			c.baseURL, verificationToken,
+		),
+	})
+}
+
+func verifyEmailHandler(w http.ResponseWriter, r *http.Request) {
+	var reqBody struct {
+		Token string `json:"token"`
+	}
+
+	errDecode := json.NewDecoder(r.Body).Decode(&reqBody)
+	if errDecode != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	usedToken, errUseEmailVerificationToken := c.dbQueries.UseEmailVerificationToken(r.Context(), auth.HashToken(reqBody.Token))
+	if errUseEmailVerificationToken != nil {
+		if errors.Is(errUseEmailVerificationToken, sql.ErrNoRows) {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	verifiedRows, errVerifyUserEmail := c.dbQueries.VerifyUserEmail(r.Context(), database.VerifyUserEmailParams{
+		ID:    usedToken.UserID,
+		Email: usedToken.Email,
+	})
+	if errVerifyUserEmail != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	if verifiedRows == 0 {
+		// the user has changed the email since the token was sent
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func resendVerificationEmailHandler(w http.ResponseWriter, r *http.Request, userID uuid.UUID) {
+	const (
+		resendInterval = time.Duration(1) * time.Minute
+		resendsPerHour = 5
+	)
+
+	selectedUser, errSelectUserByID := c.dbQueries.SelectUserByID(r.Context(), userID)
+	if errSelectUserByID != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	if selectedUser.EmailVerifiedAt.Valid {
+		w.WriteHeader(http.StatusConflict)
+		return
+	}
+
+	for _, limit := range []struct {
+		period   time.Duration
+		maxCount int64
+	}{
+		{period: resendInterval, maxCount: 1},
+		{period: time.Duration(1) * time.Hour, maxCount: resendsPerHour},
+	} {
+		sentCount, errCount := c.dbQueries.CountUserEmailVerificationTokensSince(r.Context(),
+			database.CountUserEmailVerificationTokensSinceParams{
+				UserID:    userID,
+				CreatedAt: time.Now().UTC().Add(-limit.period),
+			},
+		)
+		if errCount != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		if sentCount >= limit.maxCount {
+			w.Header().Set("retry-after", strconv.Itoa(int(limit.period.Seconds())))
+			w.WriteHeader(http.StatusTooManyRequests)
+			return
+		}
+	}
+
+	errSendVerificationEmail := sendVerificationEmail(r.Context(), userID, selectedUser.Email)
+	if errSendVerificationEmail != nil {
+		fmt.Fprintf(os.Stderr, "%s", errSendVerificationEmail)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusAccepted)
+}
+
+func unlockLoginHandler(w http.ResponseWriter, r *http.Request) {
+	var reqBody struct {
+		Email string `json:"email"`
+		IP    string `json:"ip"`
+	}
+
+	errDecode := json.NewDecoder(r.Body).Decode(&reqBody)
+	if errDecode != nil || (reqBody.Email == "" && reqBody.IP == "") {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	if reqBody.Email != "" {
+		if err := c.emailLoginLimiter.Reset(r.Context(), loginEmailKey(reqBody.Email)); err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+	}
+	if reqBody.IP != "" {
+		if err := c.ipLoginLimiter.Reset(r.Context(), loginIPKey(reqBody.IP)); err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+	}
+	recordAuditEvent(r, auditEvent{
+		eventType: auditAdminLoginUnlocked,
+		email:     reqBody.Email,
+		details:   map[string]string{"ip": reqBody.IP},
+	})
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func getCurrentUserHandler(w http.ResponseWriter, r *http.Request, userID uuid.UUID) {
+	selectedUser, errSelectUserByID := c.dbQueries.SelectUserByID(r.Context(), userID)
+	if errSelectUserByID != nil {
+		if errors.Is(errSelectUserByID, sql.ErrNoRows) {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("content-type", "application/json")
+	errEncode := json.NewEncoder(w).Encode(struct {
+		ID            uuid.UUID `json:"id"`
+		CreatedAt     string    `json:"created_at"`
+		UpdatedAt     string    `json:"updated_at"`
+		Email         string    `json:"email"`
+		EmailVerified bool      `json:"email_verified"`
+		IsChirpyRed   bool      `json:"is_chirpy_red"`
+	}{
+		ID:            selectedUser.ID,
+		CreatedAt:     selectedUser.CreatedAt.Format(time.RFC3339),
+		UpdatedAt:     selectedUser.UpdatedAt.Format(time.RFC3339),
+		Email:         selectedUser.Email,
+		EmailVerified: selectedUser.EmailVerifiedAt.Valid,
+		IsChirpyRed:   selectedUser.IsChirpyRed,
+	})
+	if errEncode != nil {
+		fmt.Fprintf(os.Stderr, "%s", errEncode)
+		return
+	}
+}
+
+// exportUserDataHandler responds with the JSON archive of the user's personal data:
+// the profile, all the chirps including the deleted ones, the sessions, the linked identities and the personal access tokens.
+func exportUserDataHandler(w http.ResponseWriter, r *http.Request, userID uuid.UUID) {
+	selectedUser, errSelectUserByID := c.dbQueries.SelectUserByID(r.Context(), userID)
+	if errSelectUserByID != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	selectedChirps, errSelectAllChirpsByUserID := c.dbQueries.SelectAllChirpsByUserID(r.Context(), userID)
+	if errSelectAllChirpsByUserID != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	selectedRevisions, errSelectUserChirpRevisions := c.dbQueries.SelectUserChirpRevisions(r.Context(), userID)
+	if errSelectUserChirpRevisions != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	selectedLikes, errSelectUserLikes := c.dbQueries.SelectUserLikes(r.Context(), userID)
+	if errSelectUserLikes != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	selectedSessions, errSelectUserSessions := c.dbQueries.SelectUserSessions(r.Context(), userID)
+	if errSelectUserSessions != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	selectedIdentities, errSelectUserIdentities := c.dbQueries.SelectUserIdentities(r.Context(), userID)
+	if errSelectUserIdentities != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	selectedPersonalAccessTokens, errSelectUserPersonalAccessTokens := c.dbQueries.SelectUserPersonalAccessTokens(r.Context(), userID)
+	if errSelectUserPersonalAccessTokens != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	type exportedChirpRevision struct {
+		Body       string `json:"body"`
+		CreatedAt  string `json:"created_at"`
+		ReplacedAt string `json:"replaced_at"`
+	}
+	revisions := make(map[uuid.UUID][]exportedChirpRevision)
+	for _, v := range selectedRevisions {
+		revisions[v.ChirpID] = append(revisions[v.ChirpID], exportedChirpRevision{
+			Body:       v.Body,
+			CreatedAt:  v.CreatedAt.Format(time.RFC3339),
+			ReplacedAt: v.ReplacedAt.Format(time.RFC3339),
+		})
+	}
+
+	type exportedChirp struct {
+		ID        uuid.UUID               `json:"id"`
+		CreatedAt string                  `json:"created_at"`
+		UpdatedAt string                  `json:"updated_at"`
+		EditedAt  *string                 `json:"edited_at"`
+		Body      string                  `json:"body"`
+		DeletedAt *string                 `json:"deleted_at"`
+		InReplyTo *uuid.UUID              `json:"in_reply_to"`
+		RechirpOf *uuid.UUID              `json:"rechirp_of"`
+		QuoteOf   *uuid.UUID              `json:"quote_of"`
+		Revisions []exportedChirpRevision `json:"revisions"`
+	}
+	chirps := make([]exportedChirp, len(selectedChirps))
+	for i, v := range selectedChirps {
+		chirps[i] = exportedChirp{
+			ID:        v.ID,
+			CreatedAt: v.CreatedAt.Format(time.RFC3339),
+			UpdatedAt: v.UpdatedAt.Format(time.RFC3339),
+			EditedAt:  formatNullTime(v.EditedAt),
+			Body:      v.Body,
+			DeletedAt: formatNullTime(v.DeletedAt),
+			Revisions: revisions[v.ID],
+		}
+		if v.InReplyTo.Valid {
+			chirps[i].InReplyTo = &v.InReplyTo.UUID
+		}
+		if v.RechirpOf.Valid {
+			chirps[i].RechirpOf = &v.RechirpOf.UUID
+		}
+		if v.QuoteOf.Valid {
+			chirps[i].QuoteOf = &v.QuoteOf.UUID
+		}
+		if chirps[i].Revisions == nil {
+			chirps[i].Revisions = []exportedChirpRevision{}
+		}
+	}
+
+	type exportedLike struct {
+		ChirpID   uuid.UUID `json:"chirp_id"`
+		CreatedAt string    `json:"created_at"`
+	}
+	likes := make([]exportedLike, len(selectedLikes))
+	for i, v := range selectedLikes {
+		likes[i] = exportedLike{
+			ChirpID:   v.ChirpID,
+			CreatedAt: v.CreatedAt.Format(time.RFC3339),
+		}
+	}
+
+	type exportedSession struct {
+		ID         uuid.UUID `json:"id"`
+		CreatedAt  string    `json:"created_at"`
+		LastUsedAt string    `json:"last_used_at"`
+		ExpiresAt  string    `json:"expires_at"`
+		UserAgent  string    `json:"user_agent"`
+		IPAddress  string    `json:"ip_address"`
+		DeviceName string    `json:"device_name"`
+	}
+	sessions := make([]exportedSession, len(selectedSessions))
+	for i, v := range selectedSessions {
+		sessions[i] = exportedSession{
+			ID:         v.FamilyID,
+			CreatedAt:  v.StartedAt.Format(time.RFC3339),
+			LastUsedAt: v.LastUsedAt.Format(time.RFC3339),
+			ExpiresAt:  v.ExpiresAt.Format(time.RFC3339),
+			UserAgent:  v.UserAgent.String,
+			IPAddress:  v.IpAddress.String,
+			DeviceName: v.DeviceName.String,
+		}
+	}
+
+	type exportedIdentity struct {
+		Issuer    string `json:"issuer"`
+		Subject   string `json:"subject"`
+		CreatedAt string `json:"created_at"`
+	}
+	identities := make([]exportedIdentity, len(selectedIdentities))
+	for i, v := range selectedIdentities {
+		identities[i] = exportedIdentity{
+			Issuer:    v.Issuer,
+			Subject:   v.Subject,
+			CreatedAt: v.CreatedAt.Format(time.RFC3339),
+		}
+	}
+
+	personalAccessTokens := make([]personalAccessTokenResponse, len(selectedPersonalAccessTokens))
+	for i, v := range selectedPersonalAccessTokens {
+		personalAccessTokens[i] = newPersonalAccessTokenResponse(v)
+	}
+
+	exportedAt := time.Now().UTC()
+	w.Header().Set("content-type", "application/json")
+	w.Header().Set("content-disposition", fmt.Sprintf(`attachment; filename="chirpy-export-%s.json"`, exportedAt.Format(time.DateOnly)))
+	w.Header().Set("cache-control", "no-store")
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	errEncode := encoder.Encode(struct {
+		ExportedAt string `json:"exported_at"`
+		Profile    struct {
+			ID            uuid.UUID `json:"id"`
+			CreatedAt     string    `json:"created_at"`
+			UpdatedAt     string    `json:"updated_at"`
+			Email         string    `json:"email"`
+			EmailVerified bool      `json:"email_verified"`
+			IsChirpyRed   bool      `json:"is_chirpy_red"`
+			Role          string    `json:"role"`
+			TOTPEnabled   bool      `json:"totp_enabled"`
+		} `json:"profile"`
+		Chirps               []exportedChirp               `json:"chirps"`
+		Likes                []exportedLike                `json:"likes"`
+		Sessions             []exportedSession             `json:"sessions"`
+		Identities           []exportedIdentity            `json:"identities"`
+		PersonalAccessTokens []personalAccessTokenResponse `json:"personal_access_tokens"`
+	}{
+		ExportedAt: exportedAt.Format(time.RFC3339),
+		Profile: struct {
+			ID            uuid.UUID `json:"id"`
+			CreatedAt     string    `json:"created_at"`
+			UpdatedAt     string    `json:"updated_at"`
+			Email         string    `json:"email"`
+			EmailVerified bool      `json:"email_verified"`
+			IsChirpyRed   bool      `json:"is_chirpy_red"`
+			Role          string    `json:"role"`
+			TOTPEnabled   bool      `json:"totp_enabled"`
+		}{
+			ID:            selectedUser.ID,
+			CreatedAt:     selectedUser.CreatedAt.Format(time.RFC3339),
+			UpdatedAt:     selectedUser.UpdatedAt.Format(time.RFC3339),
+			Email:         selectedUser.Email,
+			EmailVerified: selectedUser.EmailVerifiedAt.Valid,
+			IsChirpyRed:   selectedUser.IsChirpyRed,
+			Role:          selectedUser.Role,
+			TOTPEnabled:   selectedUser.TotpEnabledAt.Valid,
+		},
+		Chirps:               chirps,
+		Likes:                likes,
+		Sessions:             sessions,
+		Identities:           identities,
+		PersonalAccessTokens: personalAccessTokens,
+	})
+	if errEncode != nil {
+		fmt.Fprintf(os.Stderr, "%s", errEncode)
+		return
+	}
+}
+
+// accountDeleted responds with 403 Forbidden if the user has deleted the account.
+// The account is restored with the link emailed on the deletion.
+func accountDeleted(w http.ResponseWriter, user database.User) bool {
+	if !user.DeletedAt.Valid {
+		return false
+	}
+	w.Header().Set("content-type", "application/json")
+	w.WriteHeader(http.StatusForbidden)
+	errEncode := json.NewEncoder(w).Encode(struct {
+		Error   string `json:"error"`
+		PurgeAt string `json:"purge_at"`
+	}{
+		Error:   "the account has been deleted, restore it with the link sent by email",
+		PurgeAt: user.DeletedAt.Time.Add(accountDeletionGracePeriod).Format(time.RFC3339),
+	})
+	if errEncode != nil {
+		fmt.Fprintf(os.Stderr, "%s", errEncode)
+	}
+	return true
+}
+
+// deleteCurrentUserHandler deletes the user's account after the password confirmation.
+// The users without a password confirm by logging in again shortly before.
+// The account is purged after the grace period and can be restored until then.
+func deleteCurrentUserHandler(w http.ResponseWriter, r *http.Request, userID uuid.UUID) {
+	var reqBody struct {
+		Password string `json:"password"`
+	}
+	errDecode := json.NewDecoder(r.Body).Decode(&reqBody)
+	if errDecode != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	selectedUser, errSelectUserByID := c.dbQueries.SelectUserByID(r.Context(), userID)
+	if errSelectUserByID != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	if selectedUser.HashedPassword.Valid {
+		if loginBlocked(w, r, selectedUser.Email) {
+			return
+		}
+		if auth.CheckPasswordHash(selectedUser.HashedPassword.String, reqBody.Password) != nil {
+			failLogin(w, r, selectedUser.Email)
+			return
+		}
+	} else {
+		confirmed, errRecentlyLoggedIn := recentlyLoggedIn(r.Context(), userID, userClaimsFromContext(r.Context()).SessionID)
+		if errRecentlyLoggedIn != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		if !confirmed {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+	}
+
+	purgeAt, errDeleteUser := deleteUser(r.Context(), selectedUser)
+	if errDeleteUser != nil {
+		fmt.Fprintf(os.Stderr, "%s", errDeleteUser)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	recordAuditEvent(r, auditEvent{eventType: auditAccountDeleted, userID: userID})
+	if r.Header.Get("authorization") == "" {
+		clearSessionCookies(w)
+	}
+
+	w.Header().Set("content-type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	errEncode := json.NewEncoder(w).Encode(struct {
+		PurgeAt string `json:"purge_at"`
+	}{
+		PurgeAt: purgeAt.Format(time.RFC3339),
+	})
+	if errEncode != nil {
+		fmt.Fprintf(os.Stderr, "%s", errEncode)
+	}
+}
+
+// recentlyLoggedIn reports whether the user's session has started within the last 5 minutes.
+func recentlyLoggedIn(ctx context.Context, userID uuid.UUID, sessionID string) (bool, error) {
+	selectedSessions, err := c.dbQueries.SelectUserSessions(ctx, userID)
+	if err != nil {
+		return false, err
+	}
+	for _, session := range selectedSessions {
+		if session.FamilyID.String() == sessionID {
+			return time.Since(session.StartedAt) < time.Duration(5)*time.Minute, nil
+		}
+	}
+	return false, nil
+}
+
+// deleteUser marks the user's account deleted, logs the user out everywhere, revokes the personal access tokens
+// and emails the link to restore the account. It returns when the account is purged.
+func deleteUser(ctx context.Context, user database.User) (time.Time, error) {
+	tx, err := c.db.BeginTx(ctx, nil)
+	if err != nil {
+		return time.Time{}, err
+	}
+	defer tx.Rollback()
+	qtx := c.dbQueries.WithTx(tx)
+
+	if _, err := qtx.DeleteUser(ctx, user.ID); err != nil {
+		return time.Time{}, err
+	}
+	if err := qtx.RevokeUserRefreshTokens(ctx, user.ID); err != nil {
+		return time.Time{}, err
+	}
+	if err := qtx.RevokeUserPersonalAccessTokens(ctx, user.ID); err != nil {
+		return time.Time{}, err
+	}
+	if _, err := qtx.BumpUserTokenVersion(ctx, user.ID); err != nil {
+		return time.Time{}, err
+	}
+	purgeAt := time.Now().UTC().Add(accountDeletionGracePeriod)
+	restoreToken, _ := auth.MakeToken()
+	err = qtx.InsertAccountRestoreToken(ctx, database.InsertAccountRestoreTokenParams{
+		TokenHash: auth.HashToken(restoreToken),
+		UserID:    user.ID,
+		ExpiresAt: purgeAt,
+	})
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return time.Time{}, err
+	}
+	c.accessTokenRevocation.Forget(user.ID)
+
+	errSend := c.mailer.Send(ctx, mailer.Message{
+		To:      user.Email,
+		Subject: "Your Chirpy account has been deleted",
+		Body: fmt.Sprintf("Your Chirpy account has been deleted. It will be erased on %s.\n\n"+
+			"To restore your account follow the link before then:\n%s/app/restore-account/?token=%s\n\n"+
+			"If you've changed your mind later, you'll have to sign up again.\n",
+			purgeAt.Format(time.DateOnly), c.baseURL, restoreToken,
+		),
+	})
+	if errSend != nil {
+		fmt.Fprintf(os.Stderr, "%s", errSend)
+	}
+	return purgeAt, nil
+}
+
+var errAccountRestoreTokenInvalid = errors.New("error account restore token is invalid, used or expired")
+
+func restoreUserHandler(w http.ResponseWriter, r *http.Request) {
+	var reqBody struct {
+		Token string `json:"token"`
+	}
+	errDecode := json.NewDecoder(r.Body).Decode(&reqBody)
+	if errDecode != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	userID, errRestoreUser := restoreUser(r.Context(), reqBody.Token)
+	if errRestoreUser != nil {
+		if errors.Is(errRestoreUser, errAccountRestoreTokenInvalid) {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		fmt.Fprintf(os.Stderr, "%s", errRestoreUser)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	recordAuditEvent(r, auditEvent{eventType: auditAccountRestored, actorID: userID, userID: userID})
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// restoreUser uses the restore token to restore the deleted account and returns its id.
+// The user logs in again afterwards.
+func restoreUser(ctx context.Context, restoreToken string) (uuid.UUID, error) {
+	tx, err := c.db.BeginTx(ctx, nil)
+	if err != nil {
+		return uuid.Nil, err
+	}
+	defer tx.Rollback()
+	qtx := c.dbQueries.WithTx(tx)
+
+	userID, err := qtx.UseAccountRestoreToken(ctx, auth.HashToken(restoreToken))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return uuid.Nil, errAccountRestoreTokenInvalid
+		}
+		return uuid.Nil, err
+	}
+	restoredRows, err := qtx.RestoreUser(ctx, userID)
+	if err != nil {
+		return uuid.Nil, err
+	}
+	if restoredRows == 0 {
+		// the account has been restored with another token
+		return uuid.Nil, errAccountRestoreTokenInvalid
+	}
+	return userID, tx.Commit()
+}
+
+// validOAuthRedirectURI accepts the absolute https URIs without a fragment
+// and the http URIs on the loopback interface for the native apps (RFC 8252, section 7.3).
+func validOAuthRedirectURI(redirectURI string) bool {
+	parsedURI, errParse := url.Parse(redirectURI)
+	if errParse != nil || parsedURI.Host == "" || strings.Contains(redirectURI, "#") {
+		return false
+	}
+	switch parsedURI.Scheme {
+	case "https":
+		return true
+	case "http":
+		host := parsedURI.Hostname()
+		return host == "localhost" || net.ParseIP(host).IsLoopback()
+	default:
+		return false
+	}
+}
+
+func createOAuthClientHandler(w http.ResponseWriter, r *http.Request, userID uuid.UUID) {
+	var reqBody struct {
+		Name         string   `json:"name"`
+		RedirectURIs []string `json:"redirect_uris"`
+		Scope        string   `json:"scope"`
+		// Confidential clients authenticate at the token endpoint with the client secret.
+		// Public clients, like the mobile and single-page apps, can't keep a secret and rely on PKCE only.
+		Confidential bool `json:"confidential"`
+	}
+
+	errDecode := json.NewDecoder(r.Body).Decode(&reqBody)
+	if errDecode != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	respondWithError := func(message string) {
+		w.Header().Set("content-type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		errEncode := json.NewEncoder(w).Encode(struct {
+			Error string `json:"error"`
+		}{Error: message})
+		if errEncode != nil {
+			fmt.Fprintf(os.Stderr, "%s", errEncode)
+		}
+	}
+	if strings.TrimSpace(reqBody.Name) == "" {
+		respondWithError("name is required")
+		return
+	}
+	if len(reqBody.RedirectURIs) == 0 {
+		respondWithError("at least one redirect URI is required")
+		return
+	}
+	for _, redirectURI := range reqBody.RedirectURIs {
+		if !validOAuthRedirectURI(redirectURI) {
+			respondWithError(fmt.Sprintf("redirect URI %q must be an https URI or an http URI on the loopback interface without a fragment", redirectURI))
+			return
+		}
+	}
+	scopes, errParseScope := auth.ParseScope(reqBody.Scope)
+	if errParseScope != nil || len(scopes) == 0 {
+		respondWithError("scope must list some of chirps:read, chirps:write and profile")
+		return
+	}
+
+	var clientSecret string
+	var secretHash sql.NullString
+	if reqBody.Confidential {
+		var errMakeToken error
+		clientSecret, errMakeToken = auth.MakeToken()
+		if errMakeToken != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		secretHash = nullString(auth.HashToken(clientSecret))
+	}
+
+	insertedClient, errInsertOAuthClient := c.dbQueries.InsertOAuthClient(r.Context(), database.InsertOAuthClientParams{
+		ID:           uuid.New().String(),
+		UserID:       userID,
+		Name:         strings.TrimSpace(reqBody.Name),
+		SecretHash:   secretHash,
+		RedirectUris: reqBody.RedirectURIs,
+		Scopes:       scopes,
+	})
+	if errInsertOAuthClient != nil {
+		fmt.Fprintf(os.Stderr, "%s", errInsertOAuthClient)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("content-type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	errEncode := json.NewEncoder(w).Encode(struct {
+		ClientID     string   `json:"client_id"`
+		ClientSecret string   `json:"client_secret,omitempty"`
+		Name         string   `json:"name"`
+		RedirectURIs []string `json:"redirect_uris"`
+		Scope        string   `json:"scope"`
+		CreatedAt    string   `json:"created_at"`
+	}{
+		ClientID:     insertedClient.ID,
+		ClientSecret: clientSecret,
+		Name:         insertedClient.Name,
+		RedirectURIs: insertedClient.RedirectUris,
+		Scope:        strings.Join(insertedClient.Scopes, " "),
+		CreatedAt:    insertedClient.CreatedAt.Format(time.RFC3339),
+	})
+	if errEncode != nil {
+		fmt.Fprintf(os.Stderr, "%s", errEncode)
+		return
+	}
+}
+
+// oauthAuthorizationRequest is the verified authorization request of an OAuth client (RFC 6749, section 4.1.1).
+type oauthAuthorizationRequest struct {
+	client        database.OauthClient
+	redirectURI   string
+	scopes        []string
+	state         string
+	codeChallenge string
+}
+
+// parseOAuthAuthorizationRequest verifies the authorization request in the query or the consent form.
+// The errors before the client and its redirect URI are verified are shown to the user,
+// the later ones are sent to the client with the redirect.
+func parseOAuthAuthorizationRequest(w http.ResponseWriter, r *http.Request) (oauthAuthorizationRequest, bool) {
+	selectedClient, errSelectOAuthClient := c.dbQueries.SelectOAuthClient(r.Context(), r.FormValue("client_id"))
+	if errSelectOAuthClient != nil {
+		if errors.Is(errSelectOAuthClient, sql.ErrNoRows) {
+			http.Error(w, "Unknown OAuth client", http.StatusBadRequest)
+			return oauthAuthorizationRequest{}, false
+		}
+		w.WriteHeader(http.StatusInternalServerError)
+		return oauthAuthorizationRequest{}, false
+	}
+	// the redirect URI must match a registered one exactly so the code can't be sent elsewhere
+	redirectURI := r.FormValue("redirect_uri")
+	if !slices.Contains(selectedClient.RedirectUris, redirectURI) {
+		http.Error(w, "The redirect URI isn't registered for the OAuth client", http.StatusBadRequest)
+		return oauthAuthorizationRequest{}, false
+	}
+
+	state := r.FormValue("state")
+	if r.FormValue("response_type") != "code" {
+		redirectWithOAuthError(w, r, redirectURI, state, "unsupported_response_type", "only the authorization code flow is supported")
+		return oauthAuthorizationRequest{}, false
+	}
+	codeChallenge := r.FormValue("code_challenge")
+	if codeChallenge == "" || r.FormValue("code_challenge_method") != "S256" {
+		redirectWithOAuthError(w, r, redirectURI, state, "invalid_request", "PKCE with the S256 code challenge method is required")
+		return oauthAuthorizationRequest{}, false
+	}
+
+	scopes, errParseScope := auth.ParseScope(r.FormValue("scope"))
+	if errParseScope != nil {
+		redirectWithOAuthError(w, r, redirectURI, state, "invalid_scope", errParseScope.Error())
+		return oauthAuthorizationRequest{}, false
+	}
+	if len(scopes) == 0 {
+		scopes = selectedClient.Scopes
+	}
+	for _, scope := range scopes {
+		if !slices.Contains(selectedClient.Scopes, scope) {
+			redirectWithOAuthError(w, r, redirectURI, state, "invalid_scope", fmt.Sprintf("the client isn't registered for the scope %s", scope))
+			return oauthAuthorizationRequest{}, false
+		}
+	}
+
+	return oauthAuthorizationRequest{
+		client:        selectedClient,
+		redirectURI:   redirectURI,
+		scopes:        scopes,
+		state:         state,
+		codeChallenge: codeChallenge,
+	}, true
+}
+
+// redirectToOAuthClient redirects the user back to the client with the params added to the redirect URI's query.
+func redirectToOAuthClient(w http.ResponseWriter, r *http.Request, redirectURI string, params url.Values) {
+	parsedURI, errParse := url.Parse(redirectURI)
+	if errParse != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	query := parsedURI.Query()
+	for key, values := range params {
+		query[key] = values
+	}
+	parsedURI.RawQuery = query.Encode()
+	http.Redirect(w, r, parsedURI.String(), http.StatusSeeOther)
+}
+
+func redirectWithOAuthError(w http.ResponseWriter, r *http.Request, redirectURI, state, errorCode, description string) {
+	params := url.Values{}
+	params.Set("error", errorCode)
+	params.Set("error_description", description)
+	if state != "" {
+		params.Set("state", state)
+	}
+	redirectToOAuthClient(w, r, redirectURI, params)
+}
+
+var oauthConsentTemplate = template.Must(template.New("consent").Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+  <meta charset="utf-8">
+  <title>Authorize {{.ClientName}} - Chirpy</title>
+</head>
+<body>
+  <h1>Authorize {{.ClientName}}</h1>
+  <p>{{.ClientName}} wants to access your Chirpy account. It will be able to:</p>
+  <ul>
+    {{- range .Scopes}}
+    <li>{{.}}</li>
+    {{- end}}
+  </ul>
+  {{- if .Error}}
+  <p role="alert">{{.Error}}</p>
+  {{- end}}
+  <form method="post" action="/oauth/authorize">
+    <input type="hidden" name="response_type" value="code">
+    <input type="hidden" name="client_id" value="{{.ClientID}}">
+    <input type="hidden" name="redirect_uri" value="{{.RedirectURI}}">
+    <input type="hidden" name="scope" value="{{.Scope}}">
+    <input type="hidden" name="state" value="{{.State}}">
+    <input type="hidden" name="code_challenge" value="{{.CodeChallenge}}">
+    <input type="hidden" name="code_challenge_method" value="S256">
+    <p><label>Email <input type="email" name="email" value="{{.Email}}" autocomplete="username" required></label></p>
+    <p><label>Password <input type="password" name="password" autocomplete="current-password" required></label></p>
+    <p><label>Authenticator code, if you have enabled two-factor authentication <input type="text" name="totp_code" inputmode="numeric" autocomplete="one-time-code"></label></p>
+    <button type="submit" name="decision" value="approve">Allow</button>
+    <button type="submit" name="decision" value="deny" formnovalidate>Deny</button>
+  </form>
+  <p>You will be redirected to {{.RedirectURI}}</p>
+</body>
+</html>
+`))
+
+func renderOAuthConsent(w http.ResponseWriter, authorizationRequest oauthAuthorizationRequest, email, errorMessage string, statusCode int) {
+	scopeDescriptions := make([]string, len(authorizationRequest.scopes))
+	for i, scope := range authorizationRequest.scopes {
+		scopeDescriptions[i] = auth.OAuthScopes[scope]
+	}
+
+	w.Header().Set("content-type", "text/html; charset=utf-8")
+	w.Header().Set("cache-control", "no-store")
+	// the consent page can't be framed to trick the users into clicking Allow
+	w.Header().Set("x-frame-options", "DENY")
+	w.Header().Set("content-security-policy", "frame-ancestors 'none'")
+	w.WriteHeader(statusCode)
+	errExecute := oauthConsentTemplate.Execute(w, struct {
+		ClientID      string
+		ClientName    string
+		RedirectURI   string
+		Scope         string
+		Scopes        []string
+		State         string
+		CodeChallenge string
+		Email         string
+		Error         string
+	}{
+		ClientID:      authorizationRequest.client.ID,
+		ClientName:    authorizationRequest.client.Name,
+		RedirectURI:   authorizationRequest.redirectURI,
+		Scope:         strings.Join(authorizationRequest.scopes, " "),
+		Scopes:        scopeDescriptions,
+		State:         authorizationRequest.state,
+		CodeChallenge: authorizationRequest.codeChallenge,
+		Email:         email,
+		Error:         errorMessage,
+	})
+	if errExecute != nil {
+		fmt.Fprintf(os.Stderr, "%s", errExecute)
+		return
+	}
+}
+
+func oauthAuthorizeHandler(w http.ResponseWriter, r *http.Request) {
+	authorizationRequest, ok := parseOAuthAuthorizationRequest(w, r)
+	if !ok {
+		return
+	}
+	renderOAuthConsent(w, authorizationRequest, "", "", http.StatusOK)
+}
+
+// oauthConsentHandler logs the user in with the consent form and redirects back to the client
+// with the authorization code if the user allows the access.
+func oauthConsentHandler(w http.ResponseWriter, r *http.Request) {
+	authorizationRequest, ok := parseOAuthAuthorizationRequest(w, r)
+	if !ok {
+		return
+	}
+	if r.PostFormValue("decision") != "approve" {
+		redirectWithOAuthError(w, r, authorizationRequest.redirectURI, authorizationRequest.state, "access_denied", "the user denied the access")
+		return
+	}
+
+	email := r.PostFormValue("email")
+	password := r.PostFormValue("password")
+	if loginBlocked(w, r, email) {
+		return
+	}
+
+	selectedUser, errSelectUserByEmail := c.dbQueries.SelectUserByEmail(r.Context(), email)
+	if errSelectUserByEmail != nil && !errors.Is(errSelectUserByEmail, sql.ErrNoRows) {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	if errSelectUserByEmail != nil || auth.CheckPasswordHash(selectedUser.HashedPassword.String, password) != nil {
+		recordFailedLogin(r, email)
+		renderOAuthConsent(w, authorizationRequest, email, "Incorrect email or password", http.StatusUnauthorized)
+		return
+	}
+	if auth.PasswordNeedsRehash(selectedUser.HashedPassword.String, c.passwordParams) {
+		rehashPassword(r.Context(), selectedUser, password)
+	}
+	if selectedUser.DeletedAt.Valid {
+		renderOAuthConsent(w, authorizationRequest, email, "The account has been deleted", http.StatusForbidden)
+		return
+	}
+
+	if selectedUser.TotpEnabledAt.Valid {
+		step, errValidateTOTP := auth.ValidateTOTP(selectedUser.TotpSecret.String, r.PostFormValue("totp_code"), time.Now())
+		if errValidateTOTP != nil {
+			recordFailedLogin(r, email)
+			renderOAuthConsent(w, authorizationRequest, email, "Incorrect authenticator code", http.StatusUnauthorized)
+			return
+		}
+		updatedRows, errUpdateUserTOTPLastStep := c.dbQueries.UpdateUserTOTPLastStep(r.Context(), database.UpdateUserTOTPLastStepParams{
+			ID:           selectedUser.ID,
+			TotpLastStep: sql.NullInt64{Int64: step, Valid: true},
+		})
+		if errUpdateUserTOTPLastStep != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		if updatedRows == 0 {
+			renderOAuthConsent(w, authorizationRequest, email, "The authenticator code has already been used, wait for the next one", http.StatusUnauthorized)
+			return
+		}
+	}
+
+	if err := c.emailLoginLimiter.Reset(r.Context(), loginEmailKey(selectedUser.Email)); err != nil {
+		fmt.Fprintf(os.Stderr, "%s", err)
+	}
+
+	code, errMakeToken := auth.MakeToken()
+	if errMakeToken != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	errInsertOAuthAuthorizationCode := c.dbQueries.InsertOAuthAuthorizationCode(r.Context(), database.InsertOAuthAuthorizationCodeParams{
+		CodeHash:      auth.HashToken(code),
+		ClientID:      authorizationRequest.client.ID,
+		UserID:        selectedUser.ID,
+		RedirectUri:   authorizationRequest.redirectURI,
+		Scopes:        authorizationRequest.scopes,
+		CodeChallenge: authorizationRequest.codeChallenge,
+		// RFC 6749, section 4.1.2 recommends 10 minutes at most
+		ExpiresAt: time.Now().UTC().Add(time.Duration(10) * time.Minute),
+	})
+	if errInsertOAuthAuthorizationCode != nil {
+		fmt.Fprintf(os.Stderr, "%s", errInsertOAuthAuthorizationCode)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	recordAuditEvent(r, auditEvent{
+		eventType: auditOAuthAuthorized,
+		actorID:   selectedUser.ID,
+		userID:    selectedUser.ID,
+		email:     selectedUser.Email,
+		details:   map[string]string{"client_id": authorizationRequest.client.ID, "scope": strings.Join(authorizationRequest.scopes, " ")},
+	})
+
+	params := url.Values{}
+	params.Set("code", code)
+	if authorizationRequest.state != "" {
+		params.Set("state", authorizationRequest.state)
+	}
+	redirectToOAuthClient(w, r, authorizationRequest.redirectURI, params)
+}
+
+// respondWithOAuthError responds with the error of the token endpoint (RFC 6749, section 5.2).
+func respondWithOAuthError(w http.ResponseWriter, statusCode int, errorCode, description string) {
+	w.Header().Set("content-type", "application/json")
+	w.WriteHeader(statusCode)
+	errEncode := json.NewEncoder(w).Encode(struct {
+		Error            string `json:"error"`
+		ErrorDescription string `json:"error_description"`
+	}{
+		Error:            errorCode,
+		ErrorDescription: description,
+	})
+	if errEncode != nil {
+		fmt.Fprintf(os.Stderr, "%s", errEncode)
+	}
+}
+
+// oauthTokenHandler exchanges the authorization code for the scoped access JWT.
+func oauthTokenHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("cache-control", "no-store")
+
+	if errParseForm := r.ParseForm(); errParseForm != nil {
+		respondWithOAuthError(w, http.StatusBadRequest, "invalid_request", errParseForm.Error())
+		return
+	}
+	if r.PostFormValue("grant_type") != "authorization_code" {
+		respondWithOAuthError(w, http.StatusBadRequest, "unsupported_grant_type", "only the authorization_code grant type is supported")
+		return
+	}
+
+	// the confidential clients authenticate with HTTP Basic or the form params (RFC 6749, section 2.3.1)
+	clientID, clientSecret, basicAuth := r.BasicAuth()
+	if basicAuth {
+		clientID, _ = url.QueryUnescape(clientID)
+		clientSecret, _ = url.QueryUnescape(clientSecret)
+	} else {
+		clientID = r.PostFormValue("client_id")
+		clientSecret = r.PostFormValue("client_secret")
+	}
+	selectedClient, errSelectOAuthClient := c.dbQueries.SelectOAuthClient(r.Context(), clientID)
+	if errSelectOAuthClient != nil && !errors.Is(errSelectOAuthClient, sql.ErrNoRows) {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	if errSelectOAuthClient != nil ||
+		(selectedClient.SecretHash.Valid && subtle.ConstantTimeCompare([]byte(auth.HashToken(clientSecret)), []byte(selectedClient.SecretHash.String)) != 1) {
+		if basicAuth {
+			w.Header().Set("www-authenticate", `Basic realm="chirpy"`)
+		}
+		respondWithOAuthError(w, http.StatusUnauthorized, "invalid_client", "client authentication failed")
+		return
+	}
+
+	// the code is used up even if the rest of the request is invalid
+	authorizationCode, errUseOAuthAuthorizationCode := c.dbQueries.UseOAuthAuthorizationCode(r.Context(), auth.HashToken(r.PostFormValue("code")))
+	if errUseOAuthAuthorizationCode != nil {
+		if errors.Is(errUseOAuthAuthorizationCode, sql.ErrNoRows) {
+			respondWithOAuthError(w, http.StatusBadRequest, "invalid_grant", "authorization code is invalid, used or expired")
+			return
+		}
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	if authorizationCode.ClientID != selectedClient.ID || authorizationCode.RedirectUri != r.PostFormValue("redirect_uri") {
+		respondWithOAuthError(w, http.StatusBadRequest, "invalid_grant", "authorization code was issued to another client or redirect URI")
+		return
+	}
+	if !auth.VerifyPKCE(r.PostFormValue("code_verifier"), authorizationCode.CodeChallenge) {
+		respondWithOAuthError(w, http.StatusBadRequest, "invalid_grant", "code verifier doesn't match the code challenge")
+		return
 	}
-	w.WriteHeader(http.StatusNoContent)
-}
 
-func createChirp(w http.ResponseWriter, r *http.Request) {
-	bearerToken, errGetBearerToken := auth.GetBearerToken(&r.Header)
-	if errGetBearerToken != nil {
-		w.WriteHeader(http.StatusBadRequest)
+	selectedUser, errSelectUserByID := c.dbQueries.SelectUserByID(r.Context(), authorizationCode.UserID)
+	if errSelectUserByID != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	if selectedUser.DeletedAt.Valid {
+		respondWithOAuthError(w, http.StatusBadRequest, "invalid_grant", "the user's account has been deleted")
 		return
 	}
 
-	userID, errValitateUserJWT := auth.ValidateUserJWT(bearerToken, c.jwtSecret)
-	if errValitateUserJWT != nil {
-		w.WriteHeader(http.StatusUnauthorized)
+	expiresIn := accessTokenLifetime
+	userClaims := auth.NewUserClaims(authorizationCode.UserID, expiresIn)
+	userClaims.Scope = strings.Join(authorizationCode.Scopes, " ")
+	userClaims.ClientID = selectedClient.ID
+	userClaims.TokenVersion = selectedUser.TokenVersion
+	accessToken, errSignUserClaims := c.jwtKeyring.SignUserClaims(userClaims)
+	if errSignUserClaims != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("content-type", "application/json")
+	errEncode := json.NewEncoder(w).Encode(struct {
+		AccessToken string `json:"access_token"`
+		TokenType   string `json:"token_type"`
+		ExpiresIn   int    `json:"expires_in"`
+		Scope       string `json:"scope"`
+	}{
+		AccessToken: accessToken,
+		TokenType:   "Bearer",
+		ExpiresIn:   int(expiresIn.Seconds()),
+		Scope:       userClaims.Scope,
+	})
+	if errEncode != nil {
+		fmt.Fprintf(os.Stderr, "%s", errEncode)
 		return
 	}
+}
 
+func createPersonalAccessTokenHandler(w http.ResponseWriter, r *http.Request, userID uuid.UUID) {
 	var reqBody struct {
-		Body string `json:"body"`
+		Name  string `json:"name"`
+		Scope string `json:"scope"`
+		// ExpiresInDays is optional. 0 is for the token that never expires.
+		ExpiresInDays int `json:"expires_in_days"`
 	}
+
 	errDecode := json.NewDecoder(r.Body).Decode(&reqBody)
 	if errDecode != nil {
 		w.WriteHeader(http.StatusBadRequest)
 		return
 	}
-	errValidateChirp := validateChirp(reqBody.Body)
-	if errValidateChirp != nil {
+
+	respondWithError := func(message string) {
 		w.Header().Set("content-type", "application/json")
 		w.WriteHeader(http.StatusBadRequest)
 		errEncode := json.NewEncoder(w).Encode(struct {
 			Error string `json:"error"`
-		}{Error: errValidateChirp.Error()})
+		}{Error: message})
 		if errEncode != nil {
 			fmt.Fprintf(os.Stderr, "%s", errEncode)
-			return
 		}
+	}
+	if strings.TrimSpace(reqBody.Name) == "" {
+		respondWithError("name is required")
+		return
+	}
+	scopes, errParseScope := auth.ParseScope(reqBody.Scope)
+	if errParseScope != nil || len(scopes) == 0 {
+		respondWithError("scope must list some of chirps:read, chirps:write and profile")
+		return
+	}
+	if reqBody.ExpiresInDays < 0 {
+		respondWithError("expires_in_days must be positive or 0 for the token that never expires")
 		return
 	}
 
-	createdChirp, errCreateChirp := c.dbQueries.CreateChirp(r.Context(), database.CreateChirpParams{
-		Body:   cleanInput(reqBody.Body),
-		UserID: userID,
-	})
+	var expiresAt sql.NullTime
+	if reqBody.ExpiresInDays > 0 {
+		expiresAt = sql.NullTime{Time: time.Now().UTC().AddDate(0, 0, reqBody.ExpiresInDays), Valid: true}
+	}
 
-	if errCreateChirp != nil {
+	token, errMakePersonalAccessToken := auth.MakePersonalAccessToken()
+	if errMakePersonalAccessToken != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	insertedToken, errInsertPersonalAccessToken := c.dbQueries.InsertPersonalAccessToken(r.Context(), database.InsertPersonalAccessTokenParams{
+		UserID:    userID,
+		Name:      strings.TrimSpace(reqBody.Name),
+		TokenHash: auth.HashToken(token),
+		Scopes:    scopes,
+		ExpiresAt: expiresAt,
+	})
+	if errInsertPersonalAccessToken != nil {
+		fmt.Fprintf(os.Stderr, "%s", errInsertPersonalAccessToken)
 		w.WriteHeader(http.StatusInternalServerError)
 		return
 	}
+	recordAuditEvent(r, auditEvent{
+		eventType: auditPersonalAccessTokenCreated,
+		userID:    userID,
+		details:   map[string]string{"token_id": insertedToken.ID.String(), "scope": strings.Join(insertedToken.Scopes, " ")},
+	})
 
 	w.Header().Set("content-type", "application/json")
 	w.WriteHeader(http.StatusCreated)
-	errEncode := json.NewEncoder(w).Encode(
-		struct {
-			ID        uuid.UUID `json:"id"`
-			CreatedAt string    `json:"created_at"`
-			UpdatedAt string    `json:"updated_at"`
-			Body      string    `json:"body"`
-			UserID    string    `json:"user_id"`
-		}{
-			ID:        createdChirp.ID,
-			CreatedAt: createdChirp.CreatedAt.Format(time.RFC3339),
-			UpdatedAt: createdChirp.UpdatedAt.Format(time.RFC3339),
-			Body:      createdChirp.Body,
-			UserID:    createdChirp.UserID.String(),
-		},
-	)
+	errEncode := json.NewEncoder(w).Encode(struct {
+		personalAccessTokenResponse
+		// Token is shown only once
+		Token string `json:"token"`
+	}{
+		personalAccessTokenResponse: newPersonalAccessTokenResponse(insertedToken),
+		Token:                       token,
+	})
 	if errEncode != nil {
 		fmt.Fprintf(os.Stderr, "%s", errEncode)
 		return
 	}
 }
 
-func getChirps(w http.ResponseWriter, r *http.Request) {
-	queryParams := r.URL.Query()
+type personalAccessTokenResponse struct {
+	ID         uuid.UUID `json:"id"`
+	Name       string    `json:"name"`
+	Scope      string    `json:"scope"`
+	CreatedAt  string    `json:"created_at"`
+	ExpiresAt  *string   `json:"expires_at"`
+	LastUsedAt *string   `json:"last_used_at"`
+}
 
-	authorValues := queryParams["author_id"]
-	var (
-		//sorted by CreatedAt ascendently in the db queries
-		selectedChirps  []database.Chirp
-		errSelectChirps error
-	)
-	switch {
-	case len(authorValues) == 1:
-		user_uuid, errParse := uuid.Parse(authorValues[0])
-		if errParse != nil {
-			w.WriteHeader(http.StatusInternalServerError)
-			return
-		}
-		selectedChirps, errSelectChirps = c.dbQueries.SelectChirpsByUserID(r.Context(), user_uuid)
-	default:
-		selectedChirps, errSelectChirps = c.dbQueries.SelectChirps(r.Context())
+func newPersonalAccessTokenResponse(personalAccessToken database.PersonalAccessToken) personalAccessTokenResponse {
+	return personalAccessTokenResponse{
+		ID:         personalAccessToken.ID,
+		Name:       personalAccessToken.Name,
+		Scope:      strings.Join(personalAccessToken.Scopes, " "),
+		CreatedAt:  personalAccessToken.CreatedAt.Format(time.RFC3339),
+		ExpiresAt:  formatNullTime(personalAccessToken.ExpiresAt),
+		LastUsedAt: formatNullTime(personalAccessToken.LastUsedAt),
 	}
-	if errSelectChirps != nil {
+}
+
+func getPersonalAccessTokensHandler(w http.ResponseWriter, r *http.Request, userID uuid.UUID) {
+	personalAccessTokens, errSelectUserPersonalAccessTokens := c.dbQueries.SelectUserPersonalAccessTokens(r.Context(), userID)
+	if errSelectUserPersonalAccessTokens != nil {
 		w.WriteHeader(http.StatusInternalServerError)
 		return
 	}
 
-	sortValues := queryParams["sort"]
-	if len(sortValues) == 1 {
-		sortValue := sortValues[0]
-		if sortValue == "desc" {
-			sort.Slice(selectedChirps, func(i, j int) bool {
-				return selectedChirps[i].CreatedAt.After(selectedChirps[j].CreatedAt)
-			})
-		}
-		// selectedChirps are already sorted by CreatedAt ascendently is SQL
-		// no need to sort in memory
-	}
-
-	// are sorted by CreatedAt
-	chirps := make([]struct {
-		ID        uuid.UUID `json:"id"`
-		CreatedAt string    `json:"created_at"`
-		UpdatedAt string    `json:"updated_at"`
-		Body      string    `json:"body"`
-		UserID    string    `json:"user_id"`
-	}, len(selectedChirps))
-	for i, v := range selectedChirps {
-		chirps[i].ID = v.ID
-		chirps[i].CreatedAt = v.CreatedAt.Format(time.RFC3339)
-		chirps[i].UpdatedAt = v.UpdatedAt.Format(time.RFC3339)
-		chirps[i].Body = v.Body
-		chirps[i].UserID = v.UserID.String()
+	respBody := make([]personalAccessTokenResponse, len(personalAccessTokens))
+	for i, personalAccessToken := range personalAccessTokens {
+		respBody[i] = newPersonalAccessTokenResponse(personalAccessToken)
 	}
 
-	errEncode := json.NewEncoder(w).Encode(chirps)
+	w.Header().Set("content-type", "application/json")
+	errEncode := json.NewEncoder(w).Encode(respBody)
 	if errEncode != nil {
 		fmt.Fprintf(os.Stderr, "%s", errEncode)
 		return
 	}
 }
 
-func getChirp(w http.ResponseWriter, r *http.Request) {
-	chirp_uuid, errParse := uuid.Parse(r.PathValue("chirp_id"))
+func deletePersonalAccessTokenHandler(w http.ResponseWriter, r *http.Request, userID uuid.UUID) {
+	tokenID, errParse := uuid.Parse(r.PathValue("token_id"))
 	if errParse != nil {
 		w.WriteHeader(http.StatusBadRequest)
 		return
 	}
 
-	selectedChirp, errSelectChirp := c.dbQueries.SelectChirp(r.Context(), chirp_uuid)
-	if errSelectChirp != nil {
-		if errors.Is(errSelectChirp, sql.ErrNoRows) {
-			w.WriteHeader(http.StatusNotFound)
-			return
-		}
-
+	revokedRows, errRevokePersonalAccessToken := c.dbQueries.RevokePersonalAccessToken(r.Context(), database.RevokePersonalAccessTokenParams{
+		ID:     tokenID,
+		UserID: userID,
+	})
+	if errRevokePersonalAccessToken != nil {
 		w.WriteHeader(http.StatusInternalServerError)
 		return
 	}
-
-	if selectedChirp.DeletedAt.Valid {
+	if revokedRows == 0 {
 		w.WriteHeader(http.StatusNotFound)
 		return
 	}
-
-	errEncode := json.NewEncoder(w).Encode(struct {
-		ID        uuid.UUID `json:"id"`
-		CreatedAt string    `json:"created_at"`
-		UpdatedAt string    `json:"updated_at"`
-		Body      string    `json:"body"`
-		UserID    uuid.UUID `json:"user_id"`
-	}{
-		ID:        selectedChirp.ID,
-		CreatedAt: selectedChirp.CreatedAt.Format(time.RFC3339),
-		UpdatedAt: selectedChirp.UpdatedAt.Format(time.RFC3339),
-		Body:      selectedChirp.Body,
-		UserID:    selectedChirp.UserID,
+	recordAuditEvent(r, auditEvent{
+		eventType: auditPersonalAccessTokenRevoked,
+		userID:    userID,
+		details:   map[string]string{"token_id": tokenID.String()},
 	})
-	if errEncode != nil {
-		fmt.Fprintf(os.Stderr, "%s", errEncode)
-		return
-	}
+	w.WriteHeader(http.StatusNoContent)
 }
 
-func refreshAccessTokenHandler(w http.ResponseWriter, r *http.Request) {
-	b, errGetBearerToken := auth.GetBearerToken(&r.Header)
-	if errGetBearerToken != nil {
+// setUserRoleHandler grants the role to the user. The admins can't change their own role
+// so the last admin can't lock everyone out of the admin endpoints.
+func setUserRoleHandler(w http.ResponseWriter, r *http.Request) {
+	userID, errParse := uuid.Parse(r.PathValue("user_id"))
+	if errParse != nil {
 		w.WriteHeader(http.StatusBadRequest)
 		return
 	}
 
-	selectedRefreshToken, errSelectRefreshToken := c.dbQueries.SelectRefreshToken(r.Context(), b)
+	var reqBody struct {
+		Role string `json:"role"`
+	}
+	errDecode := json.NewDecoder(r.Body).Decode(&reqBody)
+	if errDecode != nil || !auth.ValidRole(reqBody.Role) {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
 
-	if errors.Is(errSelectRefreshToken, sql.ErrNoRows) ||
-		selectedRefreshToken.ExpiresAt.Before(time.Now()) ||
-		selectedRefreshToken.RevokedAt.Valid {
-		w.WriteHeader(http.StatusUnauthorized)
+	if userClaimsFromContext(r.Context()).Subject == userID.String() {
+		w.WriteHeader(http.StatusConflict)
 		return
 	}
 
-	if errSelectRefreshToken != nil {
+	updatedRows, errSetUserRole := c.dbQueries.SetUserRole(r.Context(), database.SetUserRoleParams{
+		ID:   userID,
+		Role: reqBody.Role,
+	})
+	if errSetUserRole != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	if updatedRows == 0 {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	// the user's JWTs with the previous role are revoked. The refreshed ones carry the new role
+	if _, err := revokeUserAccessTokens(r.Context(), c.dbQueries, userID); err != nil {
 		w.WriteHeader(http.StatusInternalServerError)
 		return
 	}
+	recordAuditEvent(r, auditEvent{
+		eventType: auditAdminRoleChanged,
+		userID:    userID,
+		details:   map[string]string{"role": reqBody.Role},
+	})
+	w.WriteHeader(http.StatusNoContent)
+}
 
-	token, errSignUserJWT := auth.SignUserJWT(selectedRefreshToken.UserID, c.jwtSecret, time.Duration(1)*time.Hour)
-	if errSignUserJWT != nil {
+var errAuditEventsQueryInvalid = errors.New("error invalid audit events query")
+
+// auditEventsQuery parses the filters of the audit events query:
+// user_id, actor_id, event_type, ip_address, since and until in RFC3339 and limit.
+func auditEventsQuery(query url.Values) (database.SelectAuditEventsParams, error) {
+	const (
+		defaultLimit = 50
+		maxLimit     = 500
+	)
+
+	params := database.SelectAuditEventsParams{
+		EventType: nullString(query.Get("event_type")),
+		IpAddress: nullString(query.Get("ip_address")),
+	}
+	for name, id := range map[string]*uuid.NullUUID{"user_id": &params.UserID, "actor_id": &params.ActorID} {
+		if query.Get(name) == "" {
+			continue
+		}
+		parsedID, errParse := uuid.Parse(query.Get(name))
+		if errParse != nil {
+			return database.SelectAuditEventsParams{}, errAuditEventsQueryInvalid
+		}
+		*id = nullUUID(parsedID)
+	}
+	for name, t := range map[string]*sql.NullTime{"since": &params.Since, "until": &params.Until} {
+		if query.Get(name) == "" {
+			continue
+		}
+		parsedTime, errParse := time.Parse(time.RFC3339, query.Get(name))
+		if errParse != nil {
+			return database.SelectAuditEventsParams{}, errAuditEventsQueryInvalid
+		}
+		*t = sql.NullTime{Time: parsedTime, Valid: true}
+	}
+	limit, errParseLimit := pagination.ParseLimit(query.Get("limit"), defaultLimit, maxLimit)
+	if errParseLimit != nil {
+		return database.SelectAuditEventsParams{}, errAuditEventsQueryInvalid
+	}
+	params.RowLimit = int32(limit)
+	return params, nil
+}
+
+// respondWithAuditEvents selects the audit events and responds with them, the newest first.
+func respondWithAuditEvents(w http.ResponseWriter, r *http.Request, params database.SelectAuditEventsParams) {
+	selectedEvents, errSelectAuditEvents := c.dbQueries.SelectAuditEvents(r.Context(), params)
+	if errSelectAuditEvents != nil {
+		fmt.Fprintf(os.Stderr, "%s", errSelectAuditEvents)
 		w.WriteHeader(http.StatusInternalServerError)
 		return
 	}
 
-	errEncode := json.NewEncoder(w).Encode(struct {
-		Token string `json:"token"`
-	}{
-		Token: token,
-	})
+	events := make([]struct {
+		ID        uuid.UUID       `json:"id"`
+		CreatedAt string          `json:"created_at"`
+		EventType string          `json:"event_type"`
+		ActorID   *uuid.UUID      `json:"actor_id"`
+		UserID    *uuid.UUID      `json:"user_id"`
+		Email     string          `json:"email,omitempty"`
+		IPAddress string          `json:"ip_address"`
+		UserAgent string          `json:"user_agent"`
+		Details   json.RawMessage `json:"details"`
+	}, len(selectedEvents))
+	for i, v := range selectedEvents {
+		events[i].ID = v.ID
+		events[i].CreatedAt = v.CreatedAt.Format(time.RFC3339)
+		events[i].EventType = v.EventType
+		if v.ActorID.Valid {
+			events[i].ActorID = &v.ActorID.UUID
+		}
+		if v.UserID.Valid {
+			events[i].UserID = &v.UserID.UUID
+		}
+		events[i].Email = v.Email.String
+		events[i].IPAddress = v.IpAddress.String
+		events[i].UserAgent = v.UserAgent.String
+		events[i].Details = v.Details
+	}
+
+	w.Header().Set("content-type", "application/json")
+	errEncode := json.NewEncoder(w).Encode(events)
 	if errEncode != nil {
 		fmt.Fprintf(os.Stderr, "%s", errEncode)
 		return
 	}
-
 }
 
-func UpdateRefreshToken(w http.ResponseWriter, r *http.Request) {
-	b, errGetBearerToken := auth.GetBearerToken(&r.Header)
-	if errGetBearerToken != nil {
+// getAuditEventsHandler responds with the audit events of all the users for the admins.
+func getAuditEventsHandler(w http.ResponseWriter, r *http.Request) {
+	params, errAuditEventsQuery := auditEventsQuery(r.URL.Query())
+	if errAuditEventsQuery != nil {
 		w.WriteHeader(http.StatusBadRequest)
 		return
 	}
+	respondWithAuditEvents(w, r, params)
+}
 
-	errUpdateRefreshToken := c.dbQueries.UpdateRefreshToken(r.Context(),
-		database.UpdateRefreshTokenParams{
-			Token:     b,
-			RevokedAt: sql.NullTime{Time: time.Now().UTC(), Valid: true},
-		},
-	)
-	if errUpdateRefreshToken != nil {
-		w.WriteHeader(http.StatusInternalServerError)
+// getSecurityEventsHandler responds with the audit events of the user's own account.
+func getSecurityEventsHandler(w http.ResponseWriter, r *http.Request, userID uuid.UUID) {
+	params, errAuditEventsQuery := auditEventsQuery(r.URL.Query())
+	if errAuditEventsQuery != nil {
+		w.WriteHeader(http.StatusBadRequest)
 		return
 	}
-	w.WriteHeader(http.StatusNoContent)
+	params.UserID = nullUUID(userID)
+	respondWithAuditEvents(w, r, params)
 }
 
-func putUserHandler(w http.ResponseWriter, r *http.Request, userID uuid.UUID) {
-	var reqBody struct {
-		Email    string `json:"email"`
-		Password string `json:"password"`
-	}
+// oidcStateCookieName binds the OpenID Connect login to the browser that starts it.
+// The callback with the state of the login started elsewhere is refused: it would log the user in as someone else.
+const (
+	oidcStateCookieName = "chirpy_oidc_state"
+	oidcStateCookiePath = "/api/login/oidc"
+	oidcStateLifetime   = time.Duration(10) * time.Minute
+)
 
-	errDecode := json.NewDecoder(r.Body).Decode(&reqBody)
-	if errDecode != nil {
-		w.WriteHeader(http.StatusBadRequest)
+// startOIDCLoginHandler responds with the URL of the OpenID Connect provider the web app sends the user to.
+// The provider redirects the user back to the web app with the code and the state for POST /api/login/oidc/callback.
+func startOIDCLoginHandler(w http.ResponseWriter, r *http.Request) {
+	if c.oidcProvider == nil {
+		w.WriteHeader(http.StatusNotFound)
 		return
 	}
 
-	hashedPassword, errHashPassword := auth.HashPassword(reqBody.Password)
-	if errHashPassword != nil {
+	state, _ := auth.MakeToken()
+	nonce, _ := auth.MakeToken()
+	codeVerifier, _ := auth.MakeToken()
+	errInsertOIDCLoginState := c.dbQueries.InsertOIDCLoginState(r.Context(), database.InsertOIDCLoginStateParams{
+		StateHash:    auth.HashToken(state),
+		Nonce:        nonce,
+		CodeVerifier: codeVerifier,
+		ExpiresAt:    time.Now().UTC().Add(oidcStateLifetime),
+	})
+	if errInsertOIDCLoginState != nil {
 		w.WriteHeader(http.StatusInternalServerError)
 		return
 	}
 
-	errUpdateUser := c.dbQueries.UpdateUser(r.Context(), database.UpdateUserParams{
-		ID:    userID,
-		Email: reqBody.Email,
-		HashedPassword: sql.NullString{
-			String: hashedPassword,
-			Valid:  true,
-		},
-	})
-
-	if errUpdateUser != nil {
-		w.WriteHeader(http.StatusInternalServerError)
+	authorizationURL, errAuthCodeURL := c.oidcProvider.AuthCodeURL(r.Context(), state, nonce, auth.PKCEChallenge(codeVerifier))
+	if errAuthCodeURL != nil {
+		fmt.Fprintf(os.Stderr, "%s", errAuthCodeURL)
+		w.WriteHeader(http.StatusBadGateway)
 		return
 	}
-	w.WriteHeader(http.StatusOK)
-	json.NewEncoder(w).Encode(struct {
-		Email string `json:"email"`
+
+	// Lax: the callback follows the provider's cross-site redirect
+	http.SetCookie(w, &http.Cookie{
+		Name:     oidcStateCookieName,
+		Value:    auth.HashToken(state),
+		Path:     oidcStateCookiePath,
+		MaxAge:   int(oidcStateLifetime.Seconds()),
+		Secure:   true,
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	})
+
+	w.Header().Set("content-type", "application/json")
+	errEncode := json.NewEncoder(w).Encode(struct {
+		AuthorizationURL string `json:"authorization_url"`
+		State            string `json:"state"`
 	}{
-		Email: reqBody.Email,
+		AuthorizationURL: authorizationURL,
+		State:            state,
 	})
+	if errEncode != nil {
+		fmt.Fprintf(os.Stderr, "%s", errEncode)
+		return
+	}
 }
 
-func deleteChirp(w http.ResponseWriter, r *http.Request, userID uuid.UUID) {
-	chirp_uuid, errParse := uuid.Parse(r.PathValue("chirp_id"))
-	if errParse != nil {
+func oidcCallbackHandler(w http.ResponseWriter, r *http.Request) {
+	if c.oidcProvider == nil {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	var reqBody struct {
+		Code       string `json:"code"`
+		State      string `json:"state"`
+		DeviceName string `json:"device_name"`
+		UseCookies bool   `json:"use_cookies"`
+	}
+	errDecode := json.NewDecoder(r.Body).Decode(&reqBody)
+	if errDecode != nil || reqBody.Code == "" || reqBody.State == "" {
 		w.WriteHeader(http.StatusBadRequest)
 		return
 	}
 
-	selectedChirp, errSelectChirp := c.dbQueries.SelectChirp(r.Context(), chirp_uuid)
-	if errSelectChirp != nil {
+	stateCookie, errCookie := r.Cookie(oidcStateCookieName)
+	stateHash := auth.HashToken(reqBody.State)
+	if errCookie != nil || subtle.ConstantTimeCompare([]byte(stateCookie.Value), []byte(stateHash)) != 1 {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	http.SetCookie(w, &http.Cookie{
+		Name:     oidcStateCookieName,
+		Path:     oidcStateCookiePath,
+		MaxAge:   -1,
+		Secure:   true,
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	})
+
+	loginState, errUseOIDCLoginState := c.dbQueries.UseOIDCLoginState(r.Context(), stateHash)
+	if errUseOIDCLoginState != nil {
+		if errors.Is(errUseOIDCLoginState, sql.ErrNoRows) {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
 		w.WriteHeader(http.StatusInternalServerError)
 		return
 	}
 
-	fmt.Printf("%+v\n", selectedChirp)
+	idTokenClaims, errExchange := c.oidcProvider.Exchange(r.Context(), reqBody.Code, loginState.CodeVerifier, loginState.Nonce)
+	if errExchange != nil {
+		fmt.Fprintf(os.Stderr, "%s", errExchange)
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
 
-	if selectedChirp.UserID != userID {
-		w.WriteHeader(http.StatusForbidden)
+	selectedUser, errOIDCUser := oidcUser(r.Context(), idTokenClaims)
+	if errOIDCUser != nil {
+		var statusCode int
+		switch {
+		case errors.Is(errOIDCUser, errOIDCEmailUnverified):
+			statusCode = http.StatusForbidden
+		case errors.Is(errOIDCUser, errOIDCEmailTaken):
+			statusCode = http.StatusConflict
+		default:
+			fmt.Fprintf(os.Stderr, "%s", errOIDCUser)
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("content-type", "application/json")
+		w.WriteHeader(statusCode)
+		errEncode := json.NewEncoder(w).Encode(struct {
+			Error string `json:"error"`
+		}{Error: errOIDCUser.Error()})
+		if errEncode != nil {
+			fmt.Fprintf(os.Stderr, "%s", errEncode)
+		}
+		return
+	}
+	if accountDeleted(w, selectedUser) {
 		return
 	}
 
-	if selectedChirp.DeletedAt.Valid {
-		w.WriteHeader(http.StatusNotFound)
+	if selectedUser.TotpEnabledAt.Valid {
+		respondWithMFAChallenge(w, selectedUser.ID)
 		return
 	}
+	respondWithLogin(w, r, selectedUser, reqBody.DeviceName, reqBody.UseCookies)
+}
 
-	errUpdateChirp := c.dbQueries.UpdateChirp(r.Context(), database.UpdateChirpParams{
-		ID:     chirp_uuid,
-		UserID: userID,
-		DeletedAt: sql.NullTime{
-			Time:  time.Now().UTC(),
-			Valid: true,
-		},
+var (
+	errOIDCEmailUnverified = errors.New("error identity provider hasn't verified the email")
+	errOIDCEmailTaken      = errors.New("error an unverified Chirpy account has the email. Verify the email or log in with the password first")
+)
+
+// oidcUser returns the user linked to the provider's account. On the first login the account is linked
+// to the user with the same verified email or a new user without a password is created.
+// The unverified users aren't linked: whoever registered the email without verifying it could take over the account.
+func oidcUser(ctx context.Context, idTokenClaims oidc.IDTokenClaims) (database.User, error) {
+	issuer := c.oidcProvider.Issuer()
+	userIdentity, err := c.dbQueries.SelectUserIdentity(ctx, database.SelectUserIdentityParams{
+		Issuer:  issuer,
+		Subject: idTokenClaims.Subject,
 	})
-	if errUpdateChirp != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		return
+	if err == nil {
+		return c.dbQueries.SelectUserByID(ctx, userIdentity.UserID)
+	}
+	if !errors.Is(err, sql.ErrNoRows) {
+		return database.User{}, err
+	}
+
+	if idTokenClaims.Email == "" || !idTokenClaims.EmailVerified {
+		return database.User{}, errOIDCEmailUnverified
+	}
+
+	tx, err := c.db.BeginTx(ctx, nil)
+	if err != nil {
+		return database.User{}, err
+	}
+	defer tx.Rollback()
+	qtx := c.dbQueries.WithTx(tx)
+
+	var userID uuid.UUID
+	selectedUser, err := qtx.SelectUserByEmail(ctx, idTokenClaims.Email)
+	switch {
+	case err == nil:
+		if !selectedUser.EmailVerifiedAt.Valid {
+			return database.User{}, errOIDCEmailTaken
+		}
+		userID = selectedUser.ID
+	case errors.Is(err, sql.ErrNoRows):
+		insertedUser, err := qtx.InsertUser(ctx, database.InsertUserParams{
+			Email: idTokenClaims.Email,
+		})
+		if err != nil {
+			return database.User{}, err
+		}
+		_, err = qtx.VerifyUserEmail(ctx, database.VerifyUserEmailParams{
+			ID:    insertedUser.ID,
+			Email: insertedUser.Email,
+		})
+		if err != nil {
+			return database.User{}, err
+		}
+		userID = insertedUser.ID
+	default:
+		return database.User{}, err
 	}
-	w.WriteHeader(http.StatusNoContent)
 
+	err = qtx.InsertUserIdentity(ctx, database.InsertUserIdentityParams{
+		Issuer:  issuer,
+		Subject: idTokenClaims.Subject,
+		UserID:  userID,
+	})
+	if err != nil {
+		return database.User{}, err
+	}
+	selectedUser, err = qtx.SelectUserByID(ctx, userID)
+	if err != nil {
+		return database.User{}, err
+	}
+	return selectedUser, tx.Commit()
 }
@@ -0,0 +1,186 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+	"github.com/oleshko-g/chirpy/internal/auth"
+	"github.com/oleshko-g/chirpy/internal/database"
+	"github.com/oleshko-g/chirpy/internal/revocation"
+	"github.com/stretchr/testify/assert"
+)
+
+// activeStore keeps every user's sessions valid and the JWTs of the token version
+type activeStore struct {
+	tokenVersion int32
+}
+
+func (s *activeStore) UserTokenVersion(context.Context, uuid.UUID) (int32, error) {
+	return s.tokenVersion, nil
+}
+func (s *activeStore) SessionActive(context.Context, uuid.UUID) (bool, error) { return true, nil }
+func (s *activeStore) TokenRevoked(context.Context, string) (bool, error)     { return false, nil }
+
+func TestAuthenticateUserMiddlewareCSRF(t *testing.T) {
+	keyring, err := auth.NewKeyring("")
+	assert.NoError(t, err)
+	keyring.SetLegacySecret("secret")
+	previousConfig := c
+	c = &apiConfig{
+		jwtKeyring:            keyring,
+		refreshTokenHashKey:   "key",
+		accessTokenRevocation: revocation.NewChecker(&activeStore{}, 10*time.Second),
+	}
+	t.Cleanup(func() { c = previousConfig })
+
+	userID := uuid.New()
+	sessionID := uuid.New()
+	claims := auth.NewUserClaims(userID, accessTokenLifetime)
+	claims.SessionID = sessionID.String()
+	accessToken, err := keyring.SignUserClaims(claims)
+	assert.NoError(t, err)
+	csrfToken := auth.CSRFToken(sessionID.String(), c.refreshTokenHashKey)
+
+	handler := authenticateUserMiddleware(func(w http.ResponseWriter, _ *http.Request, authenticatedUserID uuid.UUID) {
+		assert.Equal(t, userID, authenticatedUserID)
+		w.WriteHeader(http.StatusNoContent)
+	})
+	serve := func(method string, header http.Header, cookie *http.Cookie) int {
+		r := httptest.NewRequest(method, "/api/test", nil)
+		for name, values := range header {
+			r.Header[name] = values
+		}
+		if cookie != nil {
+			r.AddCookie(cookie)
+		}
+		w := httptest.NewRecorder()
+		handler(w, r)
+		return w.Code
+	}
+	accessTokenCookie := &http.Cookie{Name: accessTokenCookieName, Value: accessToken}
+
+	// Test: the cookie-authenticated POST without the CSRF token
+	assert.Equal(t, http.StatusForbidden, serve(http.MethodPost, nil, accessTokenCookie))
+
+	// Test: the cookie-authenticated POST with another session's CSRF token
+	assert.Equal(t, http.StatusForbidden, serve(http.MethodPost, http.Header{
+		"X-Csrf-Token": {auth.CSRFToken(uuid.NewString(), c.refreshTokenHashKey)},
+	}, accessTokenCookie))
+
+	// Test: the cookie-authenticated POST with the session's CSRF token
+	assert.Equal(t, http.StatusNoContent, serve(http.MethodPost, http.Header{
+		"X-Csrf-Token": {csrfToken},
+	}, accessTokenCookie))
+
+	// Test: the cookie-authenticated GET doesn't need the CSRF token
+	assert.Equal(t, http.StatusNoContent, serve(http.MethodGet, nil, accessTokenCookie))
+
+	// Test: the POST with the bearer token and the CSRF token header
+	assert.Equal(t, http.StatusNoContent, serve(http.MethodPost, http.Header{
+		"Authorization": {"Bearer " + accessToken},
+		"X-Csrf-Token":  {csrfToken},
+	}, nil))
+
+	// Test: the bearer token doesn't need the CSRF token, the cookie isn't a fallback for it
+	assert.Equal(t, http.StatusNoContent, serve(http.MethodPost, http.Header{
+		"Authorization": {"Bearer " + accessToken},
+	}, nil))
+	assert.Equal(t, http.StatusUnauthorized, serve(http.MethodPost, http.Header{
+		"Authorization": {"Bearer invalid"},
+	}, accessTokenCookie))
+
+	// Test: the request without the bearer token and the cookie
+	assert.Equal(t, http.StatusUnauthorized, serve(http.MethodPost, nil, nil))
+}
+
+func TestPersonalAccessTokenClaims(t *testing.T) {
+	ctx := context.Background()
+	store := &activeStore{tokenVersion: 1}
+	previousConfig := c
+	c = &apiConfig{accessTokenRevocation: revocation.NewChecker(store, 10*time.Second)}
+	t.Cleanup(func() { c = previousConfig })
+
+	personalAccessToken := database.PersonalAccessToken{
+		ID:           uuid.New(),
+		UserID:       uuid.New(),
+		Scopes:       []string{auth.ScopeChirpsRead, auth.ScopeChirpsWrite},
+		TokenVersion: 1,
+	}
+
+	// Test: the token of the user's current token version
+	userClaims, err := personalAccessTokenClaims(ctx, personalAccessToken)
+	assert.NoError(t, err)
+	assert.Equal(t, personalAccessToken.UserID.String(), userClaims.Subject)
+	assert.Equal(t, personalAccessToken.ID.String(), userClaims.ID)
+	assert.False(t, userClaims.FirstParty())
+
+	// Test: the password change bumps the user's token version and revokes the token
+	store.tokenVersion = 2
+	c.accessTokenRevocation.Forget(personalAccessToken.UserID)
+	_, err = personalAccessTokenClaims(ctx, personalAccessToken)
+	assert.ErrorIs(t, err, revocation.ErrRevoked)
+}
+
+func TestEmailInvalid(t *testing.T) {
+	for _, tc := range []struct {
+		email   string
+		invalid bool
+	}{
+		{email: "lane@example.com"},
+		{email: "lane.wagner+chirpy@boot.dev"},
+		{email: "", invalid: true},
+		{email: "lane", invalid: true},
+		{email: "lane@", invalid: true},
+		{email: "@example.com", invalid: true},
+		{email: "Lane <lane@example.com>", invalid: true},
+		{email: " lane@example.com", invalid: true},
+	} {
+		w := httptest.NewRecorder()
+		assert.Equal(t, tc.invalid, emailInvalid(w, tc.email), tc.email)
+		if tc.invalid {
+			assert.Equal(t, http.StatusBadRequest, w.Code, tc.email)
+		}
+	}
+}
+
+func TestUniqueViolation(t *testing.T) {
+	// Test: the duplicate email responds with 409 Conflict
+	errDuplicateEmail := fmt.Errorf("error inserting the user: %w", &pq.Error{Code: "23505", Constraint: "users_email_key"})
+	assert.True(t, uniqueViolation(errDuplicateEmail))
+	w := httptest.NewRecorder()
+	respondWithEmailTaken(w)
+	assert.Equal(t, http.StatusConflict, w.Code)
+
+	// Test: the other errors aren't conflicts
+	assert.False(t, uniqueViolation(&pq.Error{Code: "23503"}))
+	assert.False(t, uniqueViolation(sql.ErrConnDone))
+	assert.False(t, uniqueViolation(nil))
+}
+
+func TestCreateUserPasswordRequired(t *testing.T) {
+	previousConfig := c
+	c = &apiConfig{passwordPolicy: auth.PasswordPolicy{MinLength: 8}}
+	t.Cleanup(func() { c = previousConfig })
+
+	for _, tc := range []struct {
+		name string
+		body string
+	}{
+		{name: "missing password", body: `{"email":"lane@example.com"}`},
+		{name: "empty password", body: `{"email":"lane@example.com","password":""}`},
+		{name: "passwordless with password", body: `{"email":"lane@example.com","password":"correct horse","passwordless":true}`},
+	} {
+		w := httptest.NewRecorder()
+		createUser(w, httptest.NewRequest(http.MethodPost, "/api/users", strings.NewReader(tc.body)))
+		assert.Equal(t, http.StatusBadRequest, w.Code, tc.name)
+		assert.Contains(t, w.Body.String(), `"error"`, tc.name)
+	}
+}
@@ -0,0 +1,20 @@
+-- name: InsertAccountRestoreToken :exec
+INSERT INTO
+    account_restore_tokens (
+        token_hash,
+        created_at,
+        user_id,
+        expires_at
+    )
+VALUES ($1, now(), $2, $3);
+
+-- name: UseAccountRestoreToken :one
+UPDATE account_restore_tokens
+SET
+    used_at = now()
+WHERE
+    token_hash = $1
+    AND used_at IS NULL
+    AND expires_at > now()
+RETURNING
+    user_id;
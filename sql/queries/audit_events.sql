@@ -0,0 +1,54 @@
+-- name: InsertAuditEvent :exec
+INSERT INTO
+    audit_events (
+        id,
+        created_at,
+        event_type,
+        actor_id,
+        user_id,
+        email,
+        ip_address,
+        user_agent,
+        details
+    )
+VALUES (
+        gen_random_uuid(),
+        now(),
+        $1,
+        $2,
+        $3,
+        $4,
+        $5,
+        $6,
+        $7
+    );
+
+-- name: SelectAuditEvents :many
+SELECT *
+FROM audit_events
+WHERE (
+        sqlc.narg(user_id)::uuid IS NULL
+        OR user_id = sqlc.narg(user_id)
+    )
+    AND (
+        sqlc.narg(actor_id)::uuid IS NULL
+        OR actor_id = sqlc.narg(actor_id)
+    )
+    AND (
+        sqlc.narg(event_type)::text IS NULL
+        OR event_type = sqlc.narg(event_type)
+    )
+    AND (
+        sqlc.narg(ip_address)::text IS NULL
+        OR ip_address = sqlc.narg(ip_address)
+    )
+    AND (
+        sqlc.narg(since)::timestamptz IS NULL
+        OR created_at >= sqlc.narg(since)
+    )
+    AND (
+        sqlc.narg(until)::timestamptz IS NULL
+        OR created_at < sqlc.narg(until)
+    )
+ORDER BY created_at DESC, id DESC
+LIMIT sqlc.arg(row_limit);
@@ -0,0 +1,31 @@
+-- name: InsertChirpRevision :exec
+INSERT INTO
+    chirp_revisions (
+        id,
+        chirp_id,
+        body,
+        created_at,
+        replaced_at
+    )
+VALUES (
+        gen_random_uuid(),
+        $1,
+        $2,
+        $3,
+        now()
+    );
+
+-- name: SelectChirpRevisions :many
+SELECT *
+FROM chirp_revisions
+WHERE
+    chirp_id = $1
+ORDER BY replaced_at DESC;
+
+-- name: SelectUserChirpRevisions :many
+SELECT chirp_revisions.*
+FROM chirp_revisions
+    JOIN chirps ON chirps.id = chirp_revisions.chirp_id
+WHERE
+    chirps.user_id = $1
+ORDER BY chirp_revisions.replaced_at;
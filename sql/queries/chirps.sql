@@ -5,17 +5,70 @@ INSERT INTO
         created_at,
         updated_at,
         body,
-        user_id
+        user_id,
+        in_reply_to,
+        conversation_id,
+        quote_of
     )
 VALUES (
-        gen_random_uuid(),
+        $1,
+        now(),
+        now(),
+        $2,
+        $3,
+        $4,
+        $5,
+        $6
+    )
+RETURNING
+    *;
+
+-- name: CreateRechirp :one
+-- the rechirp starts its own conversation. sql.ErrNoRows if the user has already rechirped the chirp
+INSERT INTO
+    chirps (
+        id,
+        created_at,
+        updated_at,
+        body,
+        user_id,
+        conversation_id,
+        rechirp_of
+    )
+VALUES (
+        $1,
         now(),
         now(),
+        '',
+        $2,
         $1,
-        $2
+        $3
     )
+ON CONFLICT (user_id, rechirp_of) WHERE rechirp_of IS NOT NULL
+AND deleted_at IS NULL DO NOTHING
 RETURNING
     *;
+
+-- name: DeleteUserRechirp :execrows
+UPDATE chirps
+SET
+    deleted_at = now()
+WHERE
+    user_id = $1
+    AND rechirp_of = $2
+    AND deleted_at IS NULL;
+
+-- name: EditChirp :one
+UPDATE chirps
+SET
+    body = $2,
+    updated_at = now(),
+    edited_at = now()
+WHERE
+    id = $1
+RETURNING
+    *;
+
 -- name: UpdateChirp :exec
 UPDATE chirps
 SET
@@ -26,15 +79,114 @@ WHERE
     AND user_id = $2;
 
 -- name: SelectChirps :many
-SELECT * FROM chirps WHERE deleted_at IS NULL ORDER BY created_at;
+-- the page of the chirps after the cursor in the ascending order of (created_at, id)
+SELECT chirps.*
+FROM chirps
+    JOIN users ON users.id = chirps.user_id
+WHERE
+    chirps.deleted_at IS NULL
+    AND users.deleted_at IS NULL
+    AND (
+        sqlc.narg(user_id)::uuid IS NULL
+        OR chirps.user_id = sqlc.narg(user_id)
+    )
+    AND (
+        sqlc.narg(cursor_created_at)::timestamptz IS NULL
+        OR (chirps.created_at, chirps.id) > (
+            sqlc.narg(cursor_created_at),
+            sqlc.narg(cursor_id)::uuid
+        )
+    )
+ORDER BY chirps.created_at, chirps.id
+LIMIT sqlc.arg(row_limit);
 
--- name: SelectChirpsByUserID :many
-SELECT *
+-- name: SelectChirpsDesc :many
+-- the page of the chirps after the cursor in the descending order of (created_at, id)
+SELECT chirps.*
 FROM chirps
+    JOIN users ON users.id = chirps.user_id
 WHERE
-    deleted_at IS NULL
-    AND user_id = $1
-ORDER BY created_at;
+    chirps.deleted_at IS NULL
+    AND users.deleted_at IS NULL
+    AND (
+        sqlc.narg(user_id)::uuid IS NULL
+        OR chirps.user_id = sqlc.narg(user_id)
+    )
+    AND (
+        sqlc.narg(cursor_created_at)::timestamptz IS NULL
+        OR (chirps.created_at, chirps.id) < (
+            sqlc.narg(cursor_created_at),
+            sqlc.narg(cursor_id)::uuid
+        )
+    )
+ORDER BY chirps.created_at DESC, chirps.id DESC
+LIMIT sqlc.arg(row_limit);
 
 -- name: SelectChirp :one
-SELECT * FROM chirps WHERE id = $1;
\ No newline at end of file
+SELECT chirps.*
+FROM chirps
+    JOIN users ON users.id = chirps.user_id
+WHERE
+    chirps.id = $1
+    AND users.deleted_at IS NULL;
+-- name: SelectAllChirpsByUserID :many
+SELECT * FROM chirps WHERE user_id = $1 ORDER BY created_at;
+
+-- name: SelectChirpForUpdate :one
+-- locks the chirp until the transaction ends
+SELECT * FROM chirps WHERE id = $1 FOR UPDATE;
+
+-- name: SelectChirpReplies :many
+-- the page of the chirp's direct replies after the cursor, the oldest first
+SELECT chirps.*
+FROM chirps
+    JOIN users ON users.id = chirps.user_id
+WHERE
+    chirps.in_reply_to = $1
+    AND chirps.deleted_at IS NULL
+    AND users.deleted_at IS NULL
+    AND (
+        sqlc.narg(cursor_created_at)::timestamptz IS NULL
+        OR (chirps.created_at, chirps.id) > (
+            sqlc.narg(cursor_created_at),
+            sqlc.narg(cursor_id)::uuid
+        )
+    )
+ORDER BY chirps.created_at, chirps.id
+LIMIT sqlc.arg(row_limit);
+
+-- name: SelectConversationChirps :many
+-- the chirps of the conversation including the deleted ones.
+-- The chirps of the deleted users are deleted with them.
+SELECT
+    chirps.id,
+    chirps.created_at,
+    chirps.updated_at,
+    chirps.body,
+    chirps.user_id,
+    COALESCE(chirps.deleted_at, users.deleted_at) AS deleted_at,
+    chirps.edited_at,
+    chirps.in_reply_to,
+    chirps.conversation_id,
+    chirps.reply_count,
+    chirps.like_count,
+    chirps.rechirp_of,
+    chirps.quote_of,
+    chirps.rechirp_count,
+    chirps.quote_count
+FROM chirps
+    JOIN users ON users.id = chirps.user_id
+WHERE
+    chirps.conversation_id = $1
+ORDER BY chirps.created_at, chirps.id
+LIMIT sqlc.arg(row_limit);
+
+-- name: SelectVisibleChirpsByIDs :many
+-- the chirps among the ids that aren't deleted and whose authors aren't deleted
+SELECT chirps.*
+FROM chirps
+    JOIN users ON users.id = chirps.user_id
+WHERE
+    chirps.id = ANY(sqlc.arg(ids)::uuid[])
+    AND chirps.deleted_at IS NULL
+    AND users.deleted_at IS NULL;
@@ -0,0 +1,29 @@
+-- name: InsertEmailVerificationToken :exec
+INSERT INTO
+    email_verification_tokens (
+        token_hash,
+        created_at,
+        user_id,
+        email,
+        expires_at
+    )
+VALUES ($1, now(), $2, $3, $4);
+
+-- name: UseEmailVerificationToken :one
+UPDATE email_verification_tokens
+SET
+    used_at = now()
+WHERE
+    token_hash = $1
+    AND used_at IS NULL
+    AND expires_at > now()
+RETURNING
+    user_id,
+    email;
+
+-- name: CountUserEmailVerificationTokensSince :one
+SELECT COUNT(*)
+FROM email_verification_tokens
+WHERE
+    user_id = $1
+    AND created_at > $2;
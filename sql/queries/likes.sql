@@ -0,0 +1,39 @@
+-- name: InsertLike :execrows
+INSERT INTO
+    likes (user_id, chirp_id, created_at)
+VALUES ($1, $2, now())
+ON CONFLICT DO NOTHING;
+
+-- name: DeleteLike :execrows
+DELETE FROM likes WHERE user_id = $1 AND chirp_id = $2;
+
+-- name: SelectLikedChirpIDs :many
+-- the chirps among the chirp ids the user likes
+SELECT chirp_id
+FROM likes
+WHERE
+    user_id = $1
+    AND chirp_id = ANY(sqlc.arg(chirp_ids)::uuid[]);
+
+-- name: SelectUserLikedChirps :many
+-- the page of the chirps the user likes after the cursor, the latest liked first
+SELECT sqlc.embed(chirps), likes.created_at AS liked_at
+FROM likes
+    JOIN chirps ON chirps.id = likes.chirp_id
+    JOIN users ON users.id = chirps.user_id
+WHERE
+    likes.user_id = $1
+    AND chirps.deleted_at IS NULL
+    AND users.deleted_at IS NULL
+    AND (
+        sqlc.narg(cursor_created_at)::timestamptz IS NULL
+        OR (likes.created_at, likes.chirp_id) < (
+            sqlc.narg(cursor_created_at),
+            sqlc.narg(cursor_id)::uuid
+        )
+    )
+ORDER BY likes.created_at DESC, likes.chirp_id DESC
+LIMIT sqlc.arg(row_limit);
+
+-- name: SelectUserLikes :many
+SELECT * FROM likes WHERE user_id = $1 ORDER BY created_at;
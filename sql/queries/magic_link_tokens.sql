@@ -0,0 +1,29 @@
+-- name: InsertMagicLinkToken :exec
+INSERT INTO
+    magic_link_tokens (
+        token_hash,
+        created_at,
+        user_id,
+        email,
+        expires_at
+    )
+VALUES ($1, now(), $2, $3, $4);
+
+-- name: UseMagicLinkToken :one
+UPDATE magic_link_tokens
+SET
+    used_at = now()
+WHERE
+    token_hash = $1
+    AND used_at IS NULL
+    AND expires_at > now()
+RETURNING
+    user_id,
+    email;
+
+-- name: CountUserMagicLinkTokensSince :one
+SELECT COUNT(*)
+FROM magic_link_tokens
+WHERE
+    user_id = $1
+    AND created_at > $2;
@@ -0,0 +1,43 @@
+-- name: InsertOAuthClient :one
+INSERT INTO
+    oauth_clients (
+        id,
+        created_at,
+        updated_at,
+        user_id,
+        name,
+        secret_hash,
+        redirect_uris,
+        scopes
+    )
+VALUES ($1, now(), now(), $2, $3, $4, $5, $6)
+RETURNING
+    *;
+
+-- name: SelectOAuthClient :one
+SELECT * FROM oauth_clients WHERE id = $1;
+
+-- name: InsertOAuthAuthorizationCode :exec
+INSERT INTO
+    oauth_authorization_codes (
+        code_hash,
+        created_at,
+        client_id,
+        user_id,
+        redirect_uri,
+        scopes,
+        code_challenge,
+        expires_at
+    )
+VALUES ($1, now(), $2, $3, $4, $5, $6, $7);
+
+-- name: UseOAuthAuthorizationCode :one
+UPDATE oauth_authorization_codes
+SET
+    used_at = now()
+WHERE
+    code_hash = $1
+    AND used_at IS NULL
+    AND expires_at > now()
+RETURNING
+    *;
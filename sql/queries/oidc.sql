@@ -0,0 +1,38 @@
+-- name: InsertOIDCLoginState :exec
+INSERT INTO
+    oidc_login_states (
+        state_hash,
+        created_at,
+        nonce,
+        code_verifier,
+        expires_at
+    )
+VALUES ($1, now(), $2, $3, $4);
+
+-- name: UseOIDCLoginState :one
+UPDATE oidc_login_states
+SET
+    used_at = now()
+WHERE
+    state_hash = $1
+    AND used_at IS NULL
+    AND expires_at > now()
+RETURNING
+    nonce,
+    code_verifier;
+
+-- name: InsertUserIdentity :exec
+INSERT INTO
+    user_identities (
+        issuer,
+        subject,
+        created_at,
+        user_id
+    )
+VALUES ($1, $2, now(), $3);
+
+-- name: SelectUserIdentity :one
+SELECT * FROM user_identities WHERE issuer = $1 AND subject = $2;
+
+-- name: SelectUserIdentities :many
+SELECT * FROM user_identities WHERE user_id = $1 ORDER BY created_at;
@@ -0,0 +1,35 @@
+-- name: InsertPasswordResetToken :exec
+INSERT INTO
+    password_reset_tokens (
+        token_hash,
+        created_at,
+        user_id,
+        expires_at
+    )
+VALUES ($1, now(), $2, $3);
+
+-- name: UsePasswordResetToken :one
+UPDATE password_reset_tokens
+SET
+    used_at = now()
+WHERE
+    token_hash = $1
+    AND used_at IS NULL
+    AND expires_at > now()
+RETURNING
+    user_id;
+
+-- name: InvalidateUserPasswordResetTokens :exec
+UPDATE password_reset_tokens
+SET
+    used_at = now()
+WHERE
+    user_id = $1
+    AND used_at IS NULL;
+
+-- name: CountUserPasswordResetTokensSince :one
+SELECT COUNT(*)
+FROM password_reset_tokens
+WHERE
+    user_id = $1
+    AND created_at > $2;
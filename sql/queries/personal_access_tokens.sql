@@ -0,0 +1,72 @@
+-- name: InsertPersonalAccessToken :one
+INSERT INTO
+    personal_access_tokens (
+        id,
+        created_at,
+        user_id,
+        name,
+        token_hash,
+        scopes,
+        expires_at,
+        token_version
+    )
+VALUES (
+        gen_random_uuid(),
+        now(),
+        $1,
+        $2,
+        $3,
+        $4,
+        $5,
+        (
+            SELECT token_version
+            FROM users
+            WHERE
+                id = $1
+        )
+    )
+RETURNING
+    *;
+
+-- name: UsePersonalAccessToken :one
+UPDATE personal_access_tokens
+SET
+    last_used_at = now()
+WHERE
+    token_hash = $1
+    AND revoked_at IS NULL
+    AND (
+        expires_at IS NULL
+        OR expires_at > now()
+    )
+RETURNING
+    *;
+
+-- name: SelectUserPersonalAccessTokens :many
+SELECT *
+FROM personal_access_tokens
+WHERE
+    user_id = $1
+    AND revoked_at IS NULL
+    AND (
+        expires_at IS NULL
+        OR expires_at > now()
+    )
+ORDER BY created_at DESC;
+
+-- name: RevokePersonalAccessToken :execrows
+UPDATE personal_access_tokens
+SET
+    revoked_at = now()
+WHERE
+    id = $1
+    AND user_id = $2
+    AND revoked_at IS NULL;
+
+-- name: RevokeUserPersonalAccessTokens :exec
+UPDATE personal_access_tokens
+SET
+    revoked_at = now()
+WHERE
+    user_id = $1
+    AND revoked_at IS NULL;
@@ -0,0 +1,21 @@
+-- name: InsertRecoveryCode :exec
+INSERT INTO
+    recovery_codes (
+        id,
+        created_at,
+        user_id,
+        code_hash
+    )
+VALUES (gen_random_uuid(), now(), $1, $2);
+
+-- name: DeleteUserRecoveryCodes :exec
+DELETE FROM recovery_codes WHERE user_id = $1;
+
+-- name: UseRecoveryCode :execrows
+UPDATE recovery_codes
+SET
+    used_at = now()
+WHERE
+    user_id = $1
+    AND code_hash = $2
+    AND used_at IS NULL;
@@ -1,21 +1,105 @@
 -- name: InsertRefreshToken :exec
 INSERT INTO
     refresh_tokens (
-        token,
+        token_hash,
         created_at,
         updated_at,
         user_id,
-        expires_at
+        expires_at,
+        family_id,
+        started_at,
+        last_used_at,
+        user_agent,
+        ip_address,
+        device_name
     )
-VALUES ($1, NOW(), NOW(), $2, $3);
+VALUES (
+        $1,
+        NOW(),
+        NOW(),
+        $2,
+        $3,
+        $4,
+        $5,
+        NOW(),
+        $6,
+        $7,
+        $8
+    );
 
 -- name: SelectRefreshToken :one
-SELECT * FROM refresh_tokens WHERE token = $1;
+SELECT * FROM refresh_tokens WHERE token_hash = $1;
 
--- name: UpdateRefreshToken :exec
+-- name: UpdateRefreshToken :execrows
 UPDATE refresh_tokens
 SET
     updated_at = now(),
-    revoked_at = $2
+    revoked_at = $2,
+    rotated_at = $3
 WHERE
-    token = $1;
\ No newline at end of file
+    token_hash = $1
+    AND revoked_at IS NULL;
+
+-- name: RevokeRefreshTokenFamily :exec
+UPDATE refresh_tokens
+SET
+    updated_at = now(),
+    revoked_at = now()
+WHERE
+    family_id = $1
+    AND revoked_at IS NULL;
+
+-- name: SelectUserSessions :many
+SELECT
+    family_id,
+    started_at,
+    last_used_at,
+    expires_at,
+    user_agent,
+    ip_address,
+    device_name
+FROM refresh_tokens
+WHERE
+    user_id = $1
+    AND revoked_at IS NULL
+    AND expires_at > now()
+ORDER BY last_used_at DESC;
+
+-- name: RevokeUserSession :execrows
+UPDATE refresh_tokens
+SET
+    updated_at = now(),
+    revoked_at = now()
+WHERE
+    family_id = $1
+    AND user_id = $2
+    AND revoked_at IS NULL;
+
+-- name: RevokeUserSessionsExcept :exec
+UPDATE refresh_tokens
+SET
+    updated_at = now(),
+    revoked_at = now()
+WHERE
+    user_id = $1
+    AND family_id <> $2
+    AND revoked_at IS NULL;
+
+-- name: RevokeUserRefreshTokens :exec
+UPDATE refresh_tokens
+SET
+    updated_at = now(),
+    revoked_at = now()
+WHERE
+    user_id = $1
+    AND revoked_at IS NULL;
+
+-- name: SelectSessionActive :one
+SELECT EXISTS (
+        SELECT 1
+        FROM refresh_tokens
+        WHERE
+            family_id = $1
+            AND revoked_at IS NULL
+            AND expires_at > now()
+    );
@@ -0,0 +1,21 @@
+-- name: InsertRevokedAccessToken :exec
+INSERT INTO
+    revoked_access_tokens (
+        jti,
+        user_id,
+        revoked_at,
+        expires_at
+    )
+VALUES ($1, $2, now(), $3)
+ON CONFLICT (jti) DO NOTHING;
+
+-- name: SelectAccessTokenRevoked :one
+SELECT EXISTS (
+        SELECT 1
+        FROM revoked_access_tokens
+        WHERE
+            jti = $1
+    );
+
+-- name: DeleteRevokedAccessTokensBefore :execrows
+DELETE FROM revoked_access_tokens WHERE expires_at < $1;
@@ -25,6 +25,10 @@ RETURNING
 UPDATE users
 SET
     updated_at = now(),
+    email_verified_at = CASE
+        WHEN email = $2 THEN email_verified_at
+        ELSE NULL
+    END,
     email = $2,
     hashed_password = $3
 WHERE
@@ -36,5 +40,99 @@ DELETE FROM users;
 -- name: SelectUserByEmail :one
 SELECT * FROM users WHERE email = $1;
 
--- name: SetUserIsChirpyRed :exec
-UPDATE users SET is_chirpy_red = $2 WHERE id = $1;
\ No newline at end of file
+-- name: SetUserIsChirpyRed :execrows
+UPDATE users SET is_chirpy_red = $2 WHERE id = $1;
+
+-- name: SelectUserByID :one
+SELECT * FROM users WHERE id = $1;
+
+-- name: SetUserTOTPSecret :execrows
+UPDATE users
+SET
+    updated_at = now(),
+    totp_secret = $2
+WHERE
+    id = $1
+    AND totp_enabled_at IS NULL;
+
+-- name: EnableUserTOTP :exec
+UPDATE users
+SET
+    updated_at = now(),
+    totp_enabled_at = now(),
+    totp_last_step = $2
+WHERE
+    id = $1;
+
+-- name: UpdateUserTOTPLastStep :execrows
+UPDATE users
+SET
+    totp_last_step = $2
+WHERE
+    id = $1
+    AND (
+        totp_last_step IS NULL
+        OR totp_last_step < $2
+    );
+
+-- name: UpdateUserPassword :exec
+UPDATE users
+SET
+    updated_at = now(),
+    hashed_password = $2
+WHERE
+    id = $1;
+
+-- name: VerifyUserEmail :execrows
+UPDATE users
+SET
+    updated_at = now(),
+    email_verified_at = now()
+WHERE
+    id = $1
+    AND email = $2;
+
+-- name: RehashUserPassword :exec
+UPDATE users
+SET
+    hashed_password = sqlc.arg(new_hashed_password)
+WHERE
+    id = sqlc.arg(id)
+    AND hashed_password = sqlc.arg(old_hashed_password);
+
+-- name: SetUserRole :execrows
+UPDATE users SET updated_at = now(), role = $2 WHERE id = $1;
+
+-- name: BumpUserTokenVersion :one
+UPDATE users
+SET
+    updated_at = now(),
+    token_version = token_version + 1
+WHERE
+    id = $1
+RETURNING
+    token_version;
+
+-- name: SelectUserTokenVersion :one
+SELECT token_version FROM users WHERE id = $1;
+
+-- name: DeleteUser :execrows
+UPDATE users
+SET
+    updated_at = now(),
+    deleted_at = now()
+WHERE
+    id = $1
+    AND deleted_at IS NULL;
+
+-- name: RestoreUser :execrows
+UPDATE users
+SET
+    updated_at = now(),
+    deleted_at = NULL
+WHERE
+    id = $1
+    AND deleted_at IS NOT NULL;
+
+-- name: PurgeDeletedUsers :execrows
+DELETE FROM users WHERE deleted_at < $1;
@@ -0,0 +1,8 @@
+-- name: InsertWebhookEvent :execrows
+INSERT INTO
+    webhook_events (id, received_at)
+VALUES ($1, now())
+ON CONFLICT (id) DO NOTHING;
+
+-- name: DeleteWebhookEventsBefore :execrows
+DELETE FROM webhook_events WHERE received_at < $1;
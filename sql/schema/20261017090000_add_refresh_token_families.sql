@@ -0,0 +1,24 @@
+-- +goose Up
+-- +goose StatementBegin
+ALTER TABLE refresh_tokens
+ADD COLUMN family_id UUID,
+ADD COLUMN rotated_at TIMESTAMPTZ;
+
+UPDATE refresh_tokens SET family_id = gen_random_uuid();
+
+ALTER TABLE refresh_tokens ALTER COLUMN family_id SET NOT NULL;
+
+CREATE INDEX IF NOT EXISTS refresh_tokens_family_id_idx ON refresh_tokens (family_id);
+
+COMMENT ON COLUMN refresh_tokens.family_id is 'All the refresh tokens rotated from the same login';
+COMMENT ON COLUMN refresh_tokens.rotated_at is 'When the refresh token was exchanged for the next one';
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP INDEX IF EXISTS refresh_tokens_family_id_idx;
+
+ALTER TABLE refresh_tokens
+DROP COLUMN IF EXISTS family_id,
+DROP COLUMN IF EXISTS rotated_at;
+-- +goose StatementEnd
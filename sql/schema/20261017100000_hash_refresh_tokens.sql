@@ -0,0 +1,23 @@
+-- +goose Up
+-- +goose StatementBegin
+ALTER TABLE refresh_tokens RENAME COLUMN token TO token_hash;
+
+-- The HMAC key is known to the server only.
+-- The existing tokens are hashed with unkeyed SHA-256 and the server looks them up by the "sha256:" prefix
+-- until they are rotated or expire.
+UPDATE refresh_tokens
+SET
+    token_hash = 'sha256:' || encode(sha256(convert_to(token_hash, 'UTF8')), 'hex');
+
+COMMENT ON COLUMN refresh_tokens.token_hash is 'HMAC-SHA256 of the refresh token';
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+-- The refresh tokens can't be restored from their hashes. All the users have to log in again.
+DELETE FROM refresh_tokens;
+
+COMMENT ON COLUMN refresh_tokens.token_hash is NULL;
+
+ALTER TABLE refresh_tokens RENAME COLUMN token_hash TO token;
+-- +goose StatementEnd
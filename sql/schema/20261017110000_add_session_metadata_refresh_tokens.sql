@@ -0,0 +1,35 @@
+-- +goose Up
+-- +goose StatementBegin
+ALTER TABLE refresh_tokens
+ADD COLUMN started_at TIMESTAMPTZ,
+ADD COLUMN last_used_at TIMESTAMPTZ,
+ADD COLUMN user_agent TEXT,
+ADD COLUMN ip_address TEXT,
+ADD COLUMN device_name TEXT;
+
+UPDATE refresh_tokens
+SET
+    started_at = created_at,
+    last_used_at = updated_at;
+
+ALTER TABLE refresh_tokens
+ALTER COLUMN started_at SET NOT NULL,
+ALTER COLUMN last_used_at SET NOT NULL;
+
+CREATE INDEX IF NOT EXISTS refresh_tokens_user_id_idx ON refresh_tokens (user_id);
+
+COMMENT ON COLUMN refresh_tokens.started_at is 'When the user logged in the session';
+COMMENT ON COLUMN refresh_tokens.device_name is 'Optional name of the device the user has given at login';
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP INDEX IF EXISTS refresh_tokens_user_id_idx;
+
+ALTER TABLE refresh_tokens
+DROP COLUMN IF EXISTS started_at,
+DROP COLUMN IF EXISTS last_used_at,
+DROP COLUMN IF EXISTS user_agent,
+DROP COLUMN IF EXISTS ip_address,
+DROP COLUMN IF EXISTS device_name;
+-- +goose StatementEnd
@@ -0,0 +1,30 @@
+-- +goose Up
+-- +goose StatementBegin
+ALTER TABLE users ADD COLUMN email_verified_at TIMESTAMPTZ;
+
+COMMENT ON COLUMN users.email_verified_at is 'When the user confirmed the current email. NULL if unverified';
+
+-- the users registered before the verification keep posting when REQUIRE_VERIFIED_EMAIL is set
+UPDATE users SET email_verified_at = created_at;
+
+CREATE TABLE IF NOT EXISTS email_verification_tokens (
+    token_hash TEXT PRIMARY KEY,
+    created_at TIMESTAMPTZ NOT NULL,
+    user_id UUID NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+    email TEXT NOT NULL,
+    expires_at TIMESTAMPTZ NOT NULL,
+    used_at TIMESTAMPTZ
+);
+
+CREATE INDEX IF NOT EXISTS email_verification_tokens_user_id_created_at_idx ON email_verification_tokens (user_id, created_at);
+
+COMMENT ON COLUMN email_verification_tokens.token_hash is 'SHA-256 of the email verification token';
+COMMENT ON COLUMN email_verification_tokens.email is 'The email the token verifies. The token is void if the user changes the email';
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP TABLE IF EXISTS email_verification_tokens;
+
+ALTER TABLE users DROP COLUMN IF EXISTS email_verified_at;
+-- +goose StatementEnd
@@ -0,0 +1,14 @@
+-- +goose Up
+-- +goose StatementBegin
+ALTER TABLE users
+ADD COLUMN IF NOT EXISTS role TEXT NOT NULL DEFAULT 'user' CHECK (
+    role IN ('user', 'moderator', 'admin')
+);
+
+COMMENT ON COLUMN users.role is 'user, moderator or admin. Every role has the permissions of the previous ones';
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+ALTER TABLE users DROP COLUMN IF EXISTS role;
+-- +goose StatementEnd
@@ -0,0 +1,31 @@
+-- +goose Up
+-- +goose StatementBegin
+CREATE TABLE IF NOT EXISTS oidc_login_states (
+    state_hash TEXT PRIMARY KEY,
+    created_at TIMESTAMPTZ NOT NULL,
+    nonce TEXT NOT NULL,
+    code_verifier TEXT NOT NULL,
+    expires_at TIMESTAMPTZ NOT NULL,
+    used_at TIMESTAMPTZ
+);
+
+COMMENT ON TABLE oidc_login_states is 'Pending logins with the OpenID Connect provider';
+COMMENT ON COLUMN oidc_login_states.state_hash is 'SHA-256 of the state param';
+
+CREATE TABLE IF NOT EXISTS user_identities (
+    issuer TEXT NOT NULL,
+    subject TEXT NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL,
+    user_id UUID NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+    PRIMARY KEY (issuer, subject)
+);
+
+COMMENT ON TABLE user_identities is 'Users'' accounts at the OpenID Connect providers';
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP TABLE IF EXISTS user_identities;
+
+DROP TABLE IF EXISTS oidc_login_states;
+-- +goose StatementEnd
@@ -0,0 +1,21 @@
+-- +goose Up
+-- +goose StatementBegin
+CREATE TABLE IF NOT EXISTS magic_link_tokens (
+    token_hash TEXT PRIMARY KEY,
+    created_at TIMESTAMPTZ NOT NULL,
+    user_id UUID NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+    email TEXT NOT NULL,
+    expires_at TIMESTAMPTZ NOT NULL,
+    used_at TIMESTAMPTZ
+);
+
+CREATE INDEX IF NOT EXISTS magic_link_tokens_user_id_created_at_idx ON magic_link_tokens (user_id, created_at);
+
+COMMENT ON COLUMN magic_link_tokens.token_hash is 'SHA-256 of the sign-in link token';
+COMMENT ON COLUMN magic_link_tokens.email is 'The email the link is sent to. The link is void if the user changes the email';
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP TABLE IF EXISTS magic_link_tokens;
+-- +goose StatementEnd
@@ -0,0 +1,14 @@
+-- +goose Up
+-- +goose StatementBegin
+-- back the keyset pagination of GET /api/chirps in both directions, with and without the author filter
+CREATE INDEX IF NOT EXISTS chirps_created_at_id_idx ON chirps (created_at, id) WHERE deleted_at IS NULL;
+
+CREATE INDEX IF NOT EXISTS chirps_user_id_created_at_id_idx ON chirps (user_id, created_at, id) WHERE deleted_at IS NULL;
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP INDEX IF EXISTS chirps_user_id_created_at_id_idx;
+
+DROP INDEX IF EXISTS chirps_created_at_id_idx;
+-- +goose StatementEnd
@@ -0,0 +1,27 @@
+-- +goose Up
+-- +goose StatementBegin
+ALTER TABLE chirps ADD COLUMN IF NOT EXISTS edited_at TIMESTAMPTZ;
+
+COMMENT ON COLUMN chirps.edited_at is 'When the author last edited the body. NULL if it has never been edited';
+
+CREATE TABLE IF NOT EXISTS chirp_revisions (
+    id UUID PRIMARY KEY,
+    chirp_id UUID NOT NULL REFERENCES chirps (id) ON DELETE CASCADE,
+    body TEXT NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL,
+    replaced_at TIMESTAMPTZ NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS chirp_revisions_chirp_id_replaced_at_idx ON chirp_revisions (chirp_id, replaced_at);
+
+COMMENT ON TABLE chirp_revisions is 'The previous bodies of the edited chirps';
+COMMENT ON COLUMN chirp_revisions.created_at is 'When the body was posted or edited in';
+COMMENT ON COLUMN chirp_revisions.replaced_at is 'When the edit replaced the body';
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP TABLE IF EXISTS chirp_revisions;
+
+ALTER TABLE chirps DROP COLUMN IF EXISTS edited_at;
+-- +goose StatementEnd
@@ -0,0 +1,53 @@
+-- +goose Up
+-- +goose StatementBegin
+ALTER TABLE chirps
+ADD COLUMN IF NOT EXISTS in_reply_to UUID REFERENCES chirps (id) ON DELETE SET NULL,
+ADD COLUMN IF NOT EXISTS conversation_id UUID,
+ADD COLUMN IF NOT EXISTS reply_count INTEGER NOT NULL DEFAULT 0;
+
+-- the existing chirps start their own conversations
+UPDATE chirps SET conversation_id = id WHERE conversation_id IS NULL;
+
+ALTER TABLE chirps ALTER COLUMN conversation_id SET NOT NULL;
+
+COMMENT ON COLUMN chirps.in_reply_to is 'The chirp it replies to. NULL for the chirps that start the conversations';
+COMMENT ON COLUMN chirps.conversation_id is 'The id of the chirp that started the conversation';
+COMMENT ON COLUMN chirps.reply_count is 'The number of the direct replies that aren''t deleted';
+
+CREATE INDEX IF NOT EXISTS chirps_in_reply_to_created_at_id_idx ON chirps (in_reply_to, created_at, id) WHERE deleted_at IS NULL;
+
+CREATE INDEX IF NOT EXISTS chirps_conversation_id_idx ON chirps (conversation_id);
+
+-- the reply counts follow the replies however they are posted, deleted or purged with their authors
+CREATE OR REPLACE FUNCTION chirps_count_replies() RETURNS trigger AS $$
+BEGIN
+    IF TG_OP IN ('UPDATE', 'DELETE') AND OLD.in_reply_to IS NOT NULL AND OLD.deleted_at IS NULL THEN
+        UPDATE chirps SET reply_count = reply_count - 1 WHERE id = OLD.in_reply_to;
+    END IF;
+    IF TG_OP IN ('INSERT', 'UPDATE') AND NEW.in_reply_to IS NOT NULL AND NEW.deleted_at IS NULL THEN
+        UPDATE chirps SET reply_count = reply_count + 1 WHERE id = NEW.in_reply_to;
+    END IF;
+    RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER chirps_count_replies
+AFTER INSERT OR DELETE OR UPDATE OF in_reply_to, deleted_at ON chirps
+FOR EACH ROW EXECUTE FUNCTION chirps_count_replies();
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP TRIGGER IF EXISTS chirps_count_replies ON chirps;
+
+DROP FUNCTION IF EXISTS chirps_count_replies();
+
+DROP INDEX IF EXISTS chirps_conversation_id_idx;
+
+DROP INDEX IF EXISTS chirps_in_reply_to_created_at_id_idx;
+
+ALTER TABLE chirps
+DROP COLUMN IF EXISTS reply_count,
+DROP COLUMN IF EXISTS conversation_id,
+DROP COLUMN IF EXISTS in_reply_to;
+-- +goose StatementEnd
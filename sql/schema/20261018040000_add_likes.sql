@@ -0,0 +1,45 @@
+-- +goose Up
+-- +goose StatementBegin
+CREATE TABLE IF NOT EXISTS likes (
+    user_id UUID NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+    chirp_id UUID NOT NULL REFERENCES chirps (id) ON DELETE CASCADE,
+    created_at TIMESTAMPTZ NOT NULL,
+    PRIMARY KEY (user_id, chirp_id)
+);
+
+CREATE INDEX IF NOT EXISTS likes_user_id_created_at_chirp_id_idx ON likes (user_id, created_at, chirp_id);
+
+CREATE INDEX IF NOT EXISTS likes_chirp_id_idx ON likes (chirp_id);
+
+COMMENT ON TABLE likes is 'The users'' likes of the chirps. A user likes a chirp once';
+
+ALTER TABLE chirps ADD COLUMN IF NOT EXISTS like_count INTEGER NOT NULL DEFAULT 0;
+
+COMMENT ON COLUMN chirps.like_count is 'The number of the likes';
+
+-- the like counts follow the likes however they are added or deleted with their users.
+-- The concurrent likes of a chirp queue on its row so none of them is lost
+CREATE OR REPLACE FUNCTION likes_count_likes() RETURNS trigger AS $$
+BEGIN
+    IF TG_OP = 'INSERT' THEN
+        UPDATE chirps SET like_count = like_count + 1 WHERE id = NEW.chirp_id;
+    ELSE
+        UPDATE chirps SET like_count = like_count - 1 WHERE id = OLD.chirp_id;
+    END IF;
+    RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER likes_count_likes
+AFTER INSERT OR DELETE ON likes
+FOR EACH ROW EXECUTE FUNCTION likes_count_likes();
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP TABLE IF EXISTS likes;
+
+DROP FUNCTION IF EXISTS likes_count_likes();
+
+ALTER TABLE chirps DROP COLUMN IF EXISTS like_count;
+-- +goose StatementEnd
@@ -0,0 +1,60 @@
+-- +goose Up
+-- +goose StatementBegin
+-- the shared chirps are referenced without the foreign keys so the shares of the purged chirps
+-- still show that their originals are gone
+ALTER TABLE chirps
+ADD COLUMN IF NOT EXISTS rechirp_of UUID,
+ADD COLUMN IF NOT EXISTS quote_of UUID,
+ADD COLUMN IF NOT EXISTS rechirp_count INTEGER NOT NULL DEFAULT 0,
+ADD COLUMN IF NOT EXISTS quote_count INTEGER NOT NULL DEFAULT 0;
+
+COMMENT ON COLUMN chirps.rechirp_of is 'The original chirp the rechirp shares. The rechirps have no body';
+COMMENT ON COLUMN chirps.quote_of is 'The chirp the quote chirp wraps with its body';
+COMMENT ON COLUMN chirps.rechirp_count is 'The number of the rechirps that aren''t deleted';
+COMMENT ON COLUMN chirps.quote_count is 'The number of the quote chirps that aren''t deleted';
+
+-- a user rechirps a chirp once until the rechirp is deleted
+CREATE UNIQUE INDEX IF NOT EXISTS chirps_user_id_rechirp_of_idx ON chirps (user_id, rechirp_of) WHERE rechirp_of IS NOT NULL AND deleted_at IS NULL;
+
+-- the rechirp and quote counts follow the shares however they are posted, deleted or purged with their authors
+CREATE OR REPLACE FUNCTION chirps_count_shares() RETURNS trigger AS $$
+BEGIN
+    IF TG_OP IN ('UPDATE', 'DELETE') AND OLD.deleted_at IS NULL THEN
+        IF OLD.rechirp_of IS NOT NULL THEN
+            UPDATE chirps SET rechirp_count = rechirp_count - 1 WHERE id = OLD.rechirp_of;
+        END IF;
+        IF OLD.quote_of IS NOT NULL THEN
+            UPDATE chirps SET quote_count = quote_count - 1 WHERE id = OLD.quote_of;
+        END IF;
+    END IF;
+    IF TG_OP IN ('INSERT', 'UPDATE') AND NEW.deleted_at IS NULL THEN
+        IF NEW.rechirp_of IS NOT NULL THEN
+            UPDATE chirps SET rechirp_count = rechirp_count + 1 WHERE id = NEW.rechirp_of;
+        END IF;
+        IF NEW.quote_of IS NOT NULL THEN
+            UPDATE chirps SET quote_count = quote_count + 1 WHERE id = NEW.quote_of;
+        END IF;
+    END IF;
+    RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER chirps_count_shares
+AFTER INSERT OR DELETE OR UPDATE OF rechirp_of, quote_of, deleted_at ON chirps
+FOR EACH ROW EXECUTE FUNCTION chirps_count_shares();
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP TRIGGER IF EXISTS chirps_count_shares ON chirps;
+
+DROP FUNCTION IF EXISTS chirps_count_shares();
+
+DROP INDEX IF EXISTS chirps_user_id_rechirp_of_idx;
+
+ALTER TABLE chirps
+DROP COLUMN IF EXISTS quote_count,
+DROP COLUMN IF EXISTS rechirp_count,
+DROP COLUMN IF EXISTS quote_of,
+DROP COLUMN IF EXISTS rechirp_of;
+-- +goose StatementEnd
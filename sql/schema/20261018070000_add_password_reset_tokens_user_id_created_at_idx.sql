@@ -0,0 +1,10 @@
+-- +goose Up
+-- +goose StatementBegin
+-- the reset emails sent to a user are counted to throttle them
+CREATE INDEX IF NOT EXISTS password_reset_tokens_user_id_created_at_idx ON password_reset_tokens (user_id, created_at);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP INDEX IF EXISTS password_reset_tokens_user_id_created_at_idx;
+-- +goose StatementEnd
@@ -0,0 +1,20 @@
+-- +goose Up
+-- +goose StatementBegin
+ALTER TABLE personal_access_tokens
+ADD COLUMN IF NOT EXISTS token_version INTEGER NOT NULL DEFAULT 0;
+
+COMMENT ON COLUMN personal_access_tokens.token_version is 'The user''s token version when the token is created. Bumping the user''s version revokes the token';
+
+-- the existing tokens keep working until the user's version is bumped
+UPDATE personal_access_tokens
+SET
+    token_version = users.token_version
+FROM users
+WHERE
+    users.id = personal_access_tokens.user_id;
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+ALTER TABLE personal_access_tokens DROP COLUMN IF EXISTS token_version;
+-- +goose StatementEnd